@@ -1,12 +1,11 @@
 package ar_synonyms
 
 import (
-	"fmt"
+	"context"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/gocolly/colly"
 	"github.com/stretchr/testify/assert"
 	"net/http"
-	"os"
 	"testing"
 )
 
@@ -72,15 +71,6 @@ func TestCleanURL(t *testing.T) {
 }
 
 func TestGetRandomNode(t *testing.T) {
-	errorsLogged := []string{}
-	logErr = func(format string, args ...interface{}) {
-		if len(args) > 0 {
-			errorsLogged = append(errorsLogged, fmt.Sprintf(format, args))
-		} else {
-			errorsLogged = append(errorsLogged, format)
-		}
-	}
-
 	type Test struct {
 		Name          string
 		ExpectedError string
@@ -94,7 +84,7 @@ func TestGetRandomNode(t *testing.T) {
 			Name:          "gets random word succesfully",
 			ExpectedError: "",
 			Before: func() {
-				os.Setenv("ARABIC_WORD_LIST_PATH", defaultTextDir)
+				wordListPath = defaultTextDir
 			},
 			After: func() {},
 		},
@@ -175,6 +165,6 @@ func TestFilterPage(t *testing.T) {
 func TestAddEdgesIfDoNotExist(t *testing.T) {
 	node := "/synonym/ar/test"
 	neighbors := []string{}
-	added, _ := AddEdgesIfDoNotExist(node, neighbors)
+	added, _ := AddEdgesIfDoNotExist(context.Background(), node, neighbors)
 	assert.Equal(t, added, []string(nil))
 }
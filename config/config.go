@@ -0,0 +1,536 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// effective configuration of a crawl
+type Config struct {
+	GraphDBEndpoint         string `json:"graphDbEndpoint"`
+	TwoWayKVEndpoint        string `json:"twoWayKvEndpoint"`
+	StartingEndpoint        string `json:"startingEndpoint"`
+	MaxApproxNodes          int    `json:"maxApproxNodes"`
+	MaxDepth                int    `json:"maxDepth"`
+	Parallelism             int    `json:"parallelism"`
+	MsDelay                 int    `json:"msDelay"`
+	MetricsPort             string `json:"metricsPort"`
+	EnglishWordListPath     string `json:"englishWordListPath"`
+	ArabicWordListPath      string `json:"arabicWordListPath"`
+	CountiesList            string `json:"countiesList"`
+	LogLevel                string `json:"logLevel"`
+	LogFormat               string `json:"logFormat"`
+	LogSampleIntervalSec    int    `json:"logSampleIntervalSec"`
+	HealthProgressWindowSec int    `json:"healthProgressWindowSec"`
+	ReadinessIntervalSec    int    `json:"readinessIntervalSec"`
+	TracingExporter         string `json:"tracingExporter"`
+	TracingFile             string `json:"tracingFile"`
+	UserAgent               string `json:"userAgent"`
+	RequestHeaders          string `json:"requestHeaders"`
+	Proxy                   string `json:"proxy"`
+	ProxyListFile           string `json:"proxyListFile"`
+	SeedSitemap             string `json:"seedSitemap"`
+	CoordinatorAddr         string `json:"coordinatorAddr"`
+	CoordinatorPort         string `json:"coordinatorPort"`
+	LeaseSeconds            int    `json:"leaseSeconds"`
+	LeaseSize               int    `json:"leaseSize"`
+	ShardIndex              int    `json:"shardIndex"`
+	ShardCount              int    `json:"shardCount"`
+	ShardPeers              string `json:"shardPeers"`
+	ShardIdleSec            int    `json:"shardIdleSec"`
+	AllowedContentTypes     string `json:"allowedContentTypes"`
+	MaxBodyBytes            int    `json:"maxBodyBytes"`
+	RequestTimeoutSec       int    `json:"requestTimeoutSec"`
+	MaxRedirects            int    `json:"maxRedirects"`
+	AllowPatterns           string `json:"allowPatterns"`
+	DenyPatterns            string `json:"denyPatterns"`
+	DenyListFiles           string `json:"denyListFiles"`
+	ReportFile              string `json:"reportFile"`
+	ReportMarkdownFile      string `json:"reportMarkdownFile"`
+	RunID                   string `json:"runId"`
+	EdgeProvenance          bool   `json:"edgeProvenance"`
+	EdgeMode                string `json:"edgeMode"`
+	Relations               string `json:"relations"`
+	RelationEndpoints       string `json:"relationEndpoints"`
+	PageStateFile           string `json:"pageStateFile"`
+	RecrawlTTLSec           int    `json:"recrawlTtlSec"`
+	WikipediaLang           string `json:"wikipediaLang"`
+	WikipediaLinks          string `json:"wikipediaLinks"`
+	WikipediaZone           string `json:"wikipediaZone"`
+	WikipediaSeeAlso        bool   `json:"wikipediaSeeAlso"`
+	WikipediaInfobox        bool   `json:"wikipediaInfobox"`
+	WikipediaSeedBatch      int    `json:"wikipediaSeedBatch"`
+	WikipediaSeedFile       string `json:"wikipediaSeedFile"`
+	WikipediaDisambiguation string `json:"wikipediaDisambiguation"`
+	// overrides by site name, only read from config files
+	Sites map[string]json.RawMessage `json:"sites,omitempty"`
+}
+
+// a single configuration option
+type Field struct {
+	// name of the CLI flag
+	Flag string
+	// name of the environment variable
+	Env   string
+	Usage string
+	// may contain credentials, redacted when printed
+	Secret bool
+	// pointer to *string, *int or *bool in Config
+	value interface{}
+}
+
+// all configuration options, in the order they are printed
+func (c *Config) Fields() []Field {
+	return []Field{
+		{"graph-db-endpoint", "GRAPH_DB_ENDPOINT", "endpoint of graph database", true, &c.GraphDBEndpoint},
+		{"two-way-kv-endpoint", "TWO_WAY_KV_ENDPOINT", "endpoint of k:v <-> v:k lookup metadata db", true, &c.TwoWayKVEndpoint},
+		{"starting-endpoint", "STARTING_ENDPOINT", "page to start crawling at, random if empty", false, &c.StartingEndpoint},
+		{"max-approx-nodes", "MAX_APPROX_NODES", "approximate number of nodes to add, '-1' for unlimited", false, &c.MaxApproxNodes},
+		{"max-depth", "MAX_DEPTH", "pages at most this many links away from a seed are crawled, links of the last ones are only added as edges, '-1' for unlimited", false, &c.MaxDepth},
+		{"parallelism", "PARALLELISM", "number of parallel requests to each host", false, &c.Parallelism},
+		{"ms-delay", "MS_DELAY", "ms delay between each request", false, &c.MsDelay},
+		{"metrics-port", "METRICS_PORT", "port where metrics, status and health checks are served", false, &c.MetricsPort},
+		{"english-word-list-path", "ENGLISH_WORD_LIST_PATH", "word list used to seed synonyms crawls", false, &c.EnglishWordListPath},
+		{"arabic-word-list-path", "ARABIC_WORD_LIST_PATH", "word list used to seed synonyms-ar crawls", false, &c.ArabicWordListPath},
+		{"counties-list", "COUNTIES_LIST", "list of counties for us_counties crawls", false, &c.CountiesList},
+		{"log-level", "LOG_LEVEL", "debug, info, warn or error", false, &c.LogLevel},
+		{"log-format", "LOG_FORMAT", "text or json", false, &c.LogFormat},
+		{"log-sample-interval-sec", "LOG_SAMPLE_INTERVAL_SEC", "log repetitive messages at most once per interval", false, &c.LogSampleIntervalSec},
+		{"health-progress-window-sec", "HEALTH_PROGRESS_WINDOW_SEC", "crawler is unhealthy if it made no progress within this window", false, &c.HealthProgressWindowSec},
+		{"readiness-interval-sec", "READINESS_INTERVAL_SEC", "interval between readiness checks", false, &c.ReadinessIntervalSec},
+		{"tracing-exporter", "TRACING_EXPORTER", "otlp or file, tracing is off if empty", false, &c.TracingExporter},
+		{"tracing-file", "TRACING_FILE", "file spans are written to with the file exporter", false, &c.TracingFile},
+		{"user-agent", "USER_AGENT", "User-Agent sent with every page request", false, &c.UserAgent},
+		{"request-headers", "REQUEST_HEADERS", "extra headers sent with every page request, as 'Name: value|Name: value'", false, &c.RequestHeaders},
+		{"proxy", "PROXY", "http, https or socks5 proxy URL for page requests", true, &c.Proxy},
+		{"proxy-list-file", "PROXY_LIST_FILE", "file with one proxy URL per line, rotated round-robin", false, &c.ProxyListFile},
+		{"seed-sitemap", "SEED_SITEMAP", "sitemap or sitemap index URL or file (optionally gzipped) to seed the crawl from", false, &c.SeedSitemap},
+		{"coordinator-addr", "COORDINATOR_ADDR", "address of coordinator to lease URLs from, crawls run as workers if set", false, &c.CoordinatorAddr},
+		{"coordinator-port", "COORDINATOR_PORT", "port the coordinator serves leases on", false, &c.CoordinatorPort},
+		{"lease-seconds", "LEASE_SECONDS", "URLs leased to a worker are re-issued if not renewed within this time", false, &c.LeaseSeconds},
+		{"lease-size", "LEASE_SIZE", "number of URLs leased to a worker at once", false, &c.LeaseSize},
+		{"shard-index", "SHARD_INDEX", "shard of this instance, from 0 to SHARD_COUNT-1", false, &c.ShardIndex},
+		{"shard-count", "SHARD_COUNT", "number of instances links are hash-partitioned between", false, &c.ShardCount},
+		{"shard-peers", "SHARD_PEERS", "comma separated metrics addresses of all shards by index, links owned by other shards are forwarded to them", false, &c.ShardPeers},
+		{"shard-idle-sec", "SHARD_IDLE_SEC", "a forwarding shard with an empty frontier finishes once no links were forwarded to it for this long", false, &c.ShardIdleSec},
+		{"allowed-content-types", "ALLOWED_CONTENT_TYPES", "comma separated content types of pages which are parsed", false, &c.AllowedContentTypes},
+		{"max-body-bytes", "MAX_BODY_BYTES", "pages larger than this are rejected", false, &c.MaxBodyBytes},
+		{"request-timeout-sec", "REQUEST_TIMEOUT_SEC", "page requests taking longer than this are rejected", false, &c.RequestTimeoutSec},
+		{"max-redirects", "MAX_REDIRECTS", "pages redirecting more often than this are rejected", false, &c.MaxRedirects},
+		{"allow-patterns", "ALLOW_PATTERNS", "space separated regexes, links valid for the site must also match one of them", false, &c.AllowPatterns},
+		{"deny-patterns", "DENY_PATTERNS", "space separated regexes, links matching any of them are never crawled", false, &c.DenyPatterns},
+		{"deny-list-files", "DENY_LIST_FILES", "comma separated files of node keys which are never crawled, one per line", false, &c.DenyListFiles},
+		{"report-file", "REPORT_FILE", "JSON report of the crawl is written here when it finishes", false, &c.ReportFile},
+		{"report-markdown-file", "REPORT_MARKDOWN_FILE", "Markdown report of the crawl is written here when it finishes", false, &c.ReportMarkdownFile},
+		{"run-id", "RUN_ID", "identifies this crawl in its report and edges, generated if empty", false, &c.RunID},
+		{"edge-provenance", "EDGE_PROVENANCE", "send run ID, first-seen and write time and source page with every edge", false, &c.EdgeProvenance},
+		{"edge-mode", "EDGE_MODE", "directed, undirected (edges are written both ways) or reciprocal (edges are written both ways once both pages link to each other)", false, &c.EdgeMode},
+		{"relations", "RELATIONS", "comma separated relations of the site to crawl as typed edges, e.g. 'synonym,antonym', links are untyped if empty", false, &c.Relations},
+		{"relation-endpoints", "RELATION_ENDPOINTS", "graph databases for relations which are kept in their own graph, as 'relation=endpoint,relation=endpoint'", true, &c.RelationEndpoints},
+		{"page-state-file", "PAGE_STATE_FILE", "links and cache headers of crawled pages are appended here, needed to recrawl", false, &c.PageStateFile},
+		{"recrawl-ttl-sec", "RECRAWL_TTL_SEC", "pages crawled longer ago than this are revisited by recrawl", false, &c.RecrawlTTLSec},
+		{"wikipedia-lang", "WIKIPEDIA_LANG", "language of wikipedia to crawl, e.g. 'de' for de.wikipedia.org", false, &c.WikipediaLang},
+		{"wikipedia-zone", "WIKIPEDIA_ZONE", "part of wikipedia articles links are kept from: the whole 'page', the article 'body' without navboxes and references, or its 'lead' section", false, &c.WikipediaZone},
+		{"wikipedia-see-also", "WIKIPEDIA_SEE_ALSO", "keep links in the 'See also' section of the article body", false, &c.WikipediaSeeAlso},
+		{"wikipedia-infobox", "WIKIPEDIA_INFOBOX", "keep links in the infobox of the article body or lead", false, &c.WikipediaInfobox},
+		{"wikipedia-links", "WIKIPEDIA_LINKS", "read links of wikipedia articles from their 'html' or from the MediaWiki 'api', which skips sidebars and footers", false, &c.WikipediaLinks},
+		{"wikipedia-disambiguation", "WIKIPEDIA_DISAMBIGUATION", "how wikipedia disambiguation pages are crawled: 'keep' them like articles, 'skip' them and links to them, 'mark' their nodes or 'tag' edges from and to them", false, &c.WikipediaDisambiguation},
+		{"wikipedia-seed-batch", "WIKIPEDIA_SEED_BATCH", "random wikipedia pages requested at once, unused ones are kept for later seeds (at most 500)", false, &c.WikipediaSeedBatch},
+		{"wikipedia-seed-file", "WIKIPEDIA_SEED_FILE", "wikipedia titles, one per line, random seeds are drawn from when the API cannot be reached, e.g. enwiki-latest-all-titles-in-ns0.gz", false, &c.WikipediaSeedFile},
+	}
+}
+
+// wikipedia language codes, e.g. "en", "simple" or "zh-yue"
+var languageCode = regexp.MustCompile(`^[a-z]+(-[a-z]+)*$`)
+
+// identifies the crawler to sites, with a URL to contact its operators
+const DefaultUserAgent = "dgoldstein1-crawler/1.4.1 (+https://github.com/dgoldstein1/crawler)"
+
+// default configuration
+func Default() Config {
+	return Config{
+		Parallelism:             1,
+		MsDelay:                 5,
+		LogLevel:                "info",
+		LogFormat:               "text",
+		LogSampleIntervalSec:    10,
+		HealthProgressWindowSec: 300,
+		ReadinessIntervalSec:    30,
+		UserAgent:               DefaultUserAgent,
+		CoordinatorPort:         "8010",
+		LeaseSeconds:            60,
+		LeaseSize:               10,
+		MaxDepth:                -1,
+		ShardCount:              1,
+		ShardIdleSec:            600,
+		AllowedContentTypes:     "text/html,application/xhtml+xml",
+		MaxBodyBytes:            5 * 1024 * 1024,
+		RequestTimeoutSec:       10,
+		MaxRedirects:            10,
+		RecrawlTTLSec:           7 * 24 * 60 * 60,
+		EdgeMode:                "directed",
+		WikipediaLang:           "en",
+		WikipediaLinks:          "html",
+		WikipediaZone:           "page",
+		WikipediaSeeAlso:        true,
+		WikipediaInfobox:        true,
+		WikipediaSeedBatch:      20,
+		WikipediaDisambiguation: "keep",
+	}
+}
+
+// list of problems found in configuration
+type Errors []string
+
+func (e Errors) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// loads config from env, then the JSON config file at path (if not empty)
+// and its overrides for site, then flags, each overriding the last. flags
+// maps flag names to values and should only contain flags which were
+// explicitly set. site overrides look like
+// {"maxBodyBytes": 1000000, "sites": {"wikipedia": {"maxBodyBytes": 2000000}}}
+func LoadForSite(path string, site string, flags map[string]string) (Config, error) {
+	c := Default()
+	problems := Errors{}
+	for _, f := range c.Fields() {
+		if v, ok := os.LookupEnv(f.Env); ok && v != "" {
+			if err := f.set(v); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %v", f.Env, err))
+			}
+		}
+	}
+	// metrics were served on PORT before METRICS_PORT existed
+	if c.MetricsPort == "" {
+		c.MetricsPort = os.Getenv("PORT")
+	}
+	if path != "" {
+		if err := c.loadFile(path); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if overrides, ok := c.Sites[site]; ok && site != "" {
+		if err := decode(overrides, &c); err != nil {
+			problems = append(problems, fmt.Sprintf("could not parse config for site %s: %v", site, err))
+		}
+	}
+	for _, f := range c.Fields() {
+		if v, ok := flags[f.Flag]; ok {
+			if err := f.set(v); err != nil {
+				problems = append(problems, fmt.Sprintf("--%s: %v", f.Flag, err))
+			}
+		}
+	}
+	problems = append(problems, c.validate()...)
+	if len(problems) > 0 {
+		return c, problems
+	}
+	return c, nil
+}
+
+// overrides values with those set in JSON file at path
+func (c *Config) loadFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("could not read config file: %v", err)
+	}
+	if err := decode(b, c); err != nil {
+		return fmt.Errorf("could not parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// overrides values of c with those in JSON b, failing on unknown keys so
+// misspelled settings are not silently ignored
+func decode(b []byte, c *Config) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.DisallowUnknownFields()
+	return d.Decode(c)
+}
+
+// sets field from its string representation
+func (f Field) set(v string) error {
+	switch p := f.value.(type) {
+	case *string:
+		*p = v
+	case *int:
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid int", v)
+		}
+		*p = i
+	case *bool:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a valid bool", v)
+		}
+		*p = b
+	}
+	return nil
+}
+
+// string representation of field
+func (f Field) String() string {
+	switch p := f.value.(type) {
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *bool:
+		return strconv.FormatBool(*p)
+	}
+	return ""
+}
+
+// returns all problems with the configuration
+func (c Config) validate() Errors {
+	problems := Errors{}
+	required := map[string]string{
+		"GRAPH_DB_ENDPOINT":   c.GraphDBEndpoint,
+		"TWO_WAY_KV_ENDPOINT": c.TwoWayKVEndpoint,
+	}
+	for _, name := range []string{"GRAPH_DB_ENDPOINT", "TWO_WAY_KV_ENDPOINT"} {
+		if required[name] == "" {
+			problems = append(problems, fmt.Sprintf("'%s' was not set", name))
+		} else if _, err := url.ParseRequestURI(required[name]); err != nil {
+			problems = append(problems, fmt.Sprintf("%s is not a valid URL: %v", name, err))
+		}
+	}
+	if c.MaxApproxNodes < 1 && c.MaxApproxNodes != -1 {
+		problems = append(problems, fmt.Sprintf("MAX_APPROX_NODES must be greater than 0 or '-1' but was '%d'", c.MaxApproxNodes))
+	}
+	if c.MaxDepth < 0 && c.MaxDepth != -1 {
+		problems = append(problems, fmt.Sprintf("MAX_DEPTH must be at least 0 or '-1' but was '%d'", c.MaxDepth))
+	}
+	if c.Parallelism < 1 {
+		problems = append(problems, fmt.Sprintf("PARALLELISM must be greater than 0 but was '%d'", c.Parallelism))
+	}
+	if c.MsDelay < 0 {
+		problems = append(problems, fmt.Sprintf("MS_DELAY must not be negative but was '%d'", c.MsDelay))
+	}
+	if c.MetricsPort != "" && !validPort(c.MetricsPort) {
+		problems = append(problems, fmt.Sprintf("METRICS_PORT must be a valid port but was '%s'", c.MetricsPort))
+	}
+	if !validPort(c.CoordinatorPort) {
+		problems = append(problems, fmt.Sprintf("COORDINATOR_PORT must be a valid port but was '%s'", c.CoordinatorPort))
+	}
+	if c.LeaseSeconds < 1 {
+		problems = append(problems, fmt.Sprintf("LEASE_SECONDS must be greater than 0 but was '%d'", c.LeaseSeconds))
+	}
+	if c.LeaseSize < 1 {
+		problems = append(problems, fmt.Sprintf("LEASE_SIZE must be greater than 0 but was '%d'", c.LeaseSize))
+	}
+	for _, entry := range strings.Split(c.RelationEndpoints, ",") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			problems = append(problems, fmt.Sprintf("RELATION_ENDPOINTS entry '%s' must be formatted as 'relation=endpoint'", entry))
+		} else if _, err := url.ParseRequestURI(parts[1]); err != nil {
+			problems = append(problems, fmt.Sprintf("RELATION_ENDPOINTS endpoint for '%s' is not a valid URL: %v", parts[0], err))
+		}
+	}
+	switch c.EdgeMode {
+	case "directed", "undirected", "reciprocal":
+	default:
+		problems = append(problems, fmt.Sprintf("EDGE_MODE must be 'directed', 'undirected' or 'reciprocal' but was '%s'", c.EdgeMode))
+	}
+	if c.RecrawlTTLSec < 1 {
+		problems = append(problems, fmt.Sprintf("RECRAWL_TTL_SEC must be greater than 0 but was '%d'", c.RecrawlTTLSec))
+	}
+	if !languageCode.MatchString(c.WikipediaLang) {
+		problems = append(problems, fmt.Sprintf("WIKIPEDIA_LANG '%s' is not a valid language code", c.WikipediaLang))
+	}
+	if c.WikipediaLinks != "html" && c.WikipediaLinks != "api" {
+		problems = append(problems, fmt.Sprintf("WIKIPEDIA_LINKS must be 'html' or 'api' but was '%s'", c.WikipediaLinks))
+	}
+	switch c.WikipediaZone {
+	case "page", "body", "lead":
+	default:
+		problems = append(problems, fmt.Sprintf("WIKIPEDIA_ZONE must be 'page', 'body' or 'lead' but was '%s'", c.WikipediaZone))
+	}
+	if c.WikipediaZone != "page" && c.WikipediaLinks == "api" {
+		problems = append(problems, "WIKIPEDIA_ZONE cannot be used with WIKIPEDIA_LINKS 'api', which has no page to filter")
+	}
+	switch c.WikipediaDisambiguation {
+	case "keep", "skip", "mark", "tag":
+	default:
+		problems = append(problems, fmt.Sprintf("WIKIPEDIA_DISAMBIGUATION must be 'keep', 'skip', 'mark' or 'tag' but was '%s'", c.WikipediaDisambiguation))
+	}
+	if c.WikipediaSeedBatch < 1 || c.WikipediaSeedBatch > 500 {
+		problems = append(problems, fmt.Sprintf("WIKIPEDIA_SEED_BATCH must be between 1 and 500 but was '%d'", c.WikipediaSeedBatch))
+	}
+	switch strings.ToLower(c.LogLevel) {
+	case "trace", "debug", "info", "warn", "warning", "error", "fatal", "panic":
+	default:
+		problems = append(problems, fmt.Sprintf("LOG_LEVEL must be one of debug, info, warn or error but was '%s'", c.LogLevel))
+	}
+	switch strings.ToLower(c.LogFormat) {
+	case "text", "json":
+	default:
+		problems = append(problems, fmt.Sprintf("LOG_FORMAT must be 'text' or 'json' but was '%s'", c.LogFormat))
+	}
+	if c.LogSampleIntervalSec < 0 {
+		problems = append(problems, fmt.Sprintf("LOG_SAMPLE_INTERVAL_SEC must not be negative but was '%d'", c.LogSampleIntervalSec))
+	}
+	if c.HealthProgressWindowSec < 1 {
+		problems = append(problems, fmt.Sprintf("HEALTH_PROGRESS_WINDOW_SEC must be greater than 0 but was '%d'", c.HealthProgressWindowSec))
+	}
+	if c.ReadinessIntervalSec < 1 {
+		problems = append(problems, fmt.Sprintf("READINESS_INTERVAL_SEC must be greater than 0 but was '%d'", c.ReadinessIntervalSec))
+	}
+	switch c.TracingExporter {
+	case "", "otlp":
+	case "file":
+		if c.TracingFile == "" {
+			problems = append(problems, "TRACING_FILE must be set when TRACING_EXPORTER is 'file'")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("TRACING_EXPORTER must be 'otlp' or 'file' but was '%s'", c.TracingExporter))
+	}
+	if c.UserAgent == "" {
+		problems = append(problems, "USER_AGENT must not be empty")
+	}
+	if strings.Trim(c.AllowedContentTypes, ", ") == "" {
+		problems = append(problems, "ALLOWED_CONTENT_TYPES must not be empty")
+	}
+	if c.MaxBodyBytes < 1 {
+		problems = append(problems, fmt.Sprintf("MAX_BODY_BYTES must be greater than 0 but was '%d'", c.MaxBodyBytes))
+	}
+	if c.RequestTimeoutSec < 1 {
+		problems = append(problems, fmt.Sprintf("REQUEST_TIMEOUT_SEC must be greater than 0 but was '%d'", c.RequestTimeoutSec))
+	}
+	if c.MaxRedirects < 0 {
+		problems = append(problems, fmt.Sprintf("MAX_REDIRECTS must not be negative but was '%d'", c.MaxRedirects))
+	}
+	if _, err := ParsePatterns(c.AllowPatterns); err != nil {
+		problems = append(problems, fmt.Sprintf("ALLOW_PATTERNS %v", err))
+	}
+	if _, err := ParsePatterns(c.DenyPatterns); err != nil {
+		problems = append(problems, fmt.Sprintf("DENY_PATTERNS %v", err))
+	}
+	if c.ShardCount < 1 {
+		problems = append(problems, fmt.Sprintf("SHARD_COUNT must be greater than 0 but was '%d'", c.ShardCount))
+	} else if c.ShardIndex < 0 || c.ShardIndex >= c.ShardCount {
+		problems = append(problems, fmt.Sprintf("SHARD_INDEX must be between 0 and %d but was '%d'", c.ShardCount-1, c.ShardIndex))
+	}
+	if c.ShardIdleSec < 1 {
+		problems = append(problems, fmt.Sprintf("SHARD_IDLE_SEC must be greater than 0 but was '%d'", c.ShardIdleSec))
+	}
+	if c.ShardPeers != "" && len(strings.Split(c.ShardPeers, ",")) != c.ShardCount {
+		problems = append(problems, fmt.Sprintf("SHARD_PEERS must list all %d shards", c.ShardCount))
+	}
+	if c.ShardCount > 1 && c.CoordinatorAddr != "" {
+		problems = append(problems, "SHARD_COUNT and COORDINATOR_ADDR cannot be used together")
+	}
+	if _, err := ParseHeaders(c.RequestHeaders); err != nil {
+		problems = append(problems, fmt.Sprintf("REQUEST_HEADERS %v", err))
+	}
+	if c.Proxy != "" {
+		if c.ProxyListFile != "" {
+			problems = append(problems, "only one of PROXY and PROXY_LIST_FILE may be set")
+		}
+		if err := ValidateProxy(c.Proxy); err != nil {
+			problems = append(problems, fmt.Sprintf("PROXY %v", err))
+		}
+	}
+	return problems
+}
+
+func validPort(port string) bool {
+	p, err := strconv.Atoi(port)
+	return err == nil && p >= 1 && p <= 65535
+}
+
+// parses headers formatted as 'Name: value|Name: value'
+func ParseHeaders(s string) (map[string]string, error) {
+	headers := make(map[string]string)
+	for _, h := range strings.Split(s, "|") {
+		if strings.TrimSpace(h) == "" {
+			continue
+		}
+		parts := strings.SplitN(h, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, fmt.Errorf("header '%s' must be formatted as 'Name: value'", h)
+		}
+		headers[name] = strings.TrimSpace(parts[1])
+	}
+	return headers, nil
+}
+
+// compiles space separated regexes
+func ParsePatterns(s string) ([]*regexp.Regexp, error) {
+	patterns := []*regexp.Regexp{}
+	for _, p := range strings.Fields(s) {
+		r, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("'%s' is not a valid regex: %v", p, err)
+		}
+		patterns = append(patterns, r)
+	}
+	return patterns, nil
+}
+
+// checks that proxy is a URL with a supported scheme
+func ValidateProxy(proxy string) error {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return fmt.Errorf("'%s' is not a valid URL", proxy)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return fmt.Errorf("'%s' must use scheme http, https or socks5", u.Redacted())
+	}
+	if u.Host == "" {
+		return fmt.Errorf("'%s' has no host", u.Redacted())
+	}
+	return nil
+}
+
+// value of the field set by the environment variable env, "" if there is none
+func (c Config) Get(env string) string {
+	for _, f := range c.Fields() {
+		if f.Env == env {
+			return f.String()
+		}
+	}
+	return ""
+}
+
+// copy of config with credentials removed from secret fields
+func (c Config) Redacted() Config {
+	r := c
+	for _, f := range r.Fields() {
+		if !f.Secret {
+			continue
+		}
+		// may be a list of 'name=URL'
+		entries := strings.Split(f.String(), ",")
+		for i, entry := range entries {
+			name := ""
+			if j := strings.Index(entry, "="); j != -1 && !strings.Contains(entry[:j], "/") {
+				name, entry = entry[:j+1], entry[j+1:]
+			}
+			if u, err := url.Parse(entry); err == nil && u.User != nil {
+				if _, hasPassword := u.User.Password(); hasPassword {
+					u.User = url.UserPassword(u.User.Username(), "REDACTED")
+					entry = u.String()
+				}
+			}
+			entries[i] = name + entry
+		}
+		f.set(strings.Join(entries, ","))
+	}
+	return r
+}
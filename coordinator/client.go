@@ -0,0 +1,89 @@
+package coordinator
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// talks to a coordinator over HTTP
+type Client struct {
+	addr   string
+	client http.Client
+}
+
+// client for coordinator at addr, "http://" is assumed if there is no scheme
+func NewClient(addr string) *Client {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+	return &Client{
+		addr:   strings.TrimSuffix(addr, "/"),
+		client: http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// requests a lease of up to max URLs. the lease has no URLs if none are
+// available right now, done is true once the crawl is finished.
+func (c *Client) Lease(worker string, max int) (l Lease, done bool, err error) {
+	res, err := c.post("/leases", leaseRequest{Worker: worker, Max: max})
+	if err != nil {
+		return l, false, err
+	}
+	defer res.Body.Close()
+	switch res.StatusCode {
+	case http.StatusGone:
+		return l, true, nil
+	case http.StatusNoContent:
+		return l, false, nil
+	case http.StatusOK:
+		err = json.NewDecoder(res.Body).Decode(&l)
+		return l, false, err
+	}
+	return l, false, unexpected(res)
+}
+
+// extends lease with id
+func (c *Client) Renew(id string) (l Lease, err error) {
+	res, err := c.post("/leases/"+id+"/renew", nil)
+	if err != nil {
+		return l, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return l, ErrUnknownLease
+	}
+	if res.StatusCode != http.StatusOK {
+		return l, unexpected(res)
+	}
+	err = json.NewDecoder(res.Body).Decode(&l)
+	return l, err
+}
+
+// reports results of lease with id
+func (c *Client) Complete(id string, results []Result) error {
+	res, err := c.post("/leases/"+id+"/complete", completeRequest{Results: results})
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusNoContent {
+		return unexpected(res)
+	}
+	return nil
+}
+
+func (c *Client) post(path string, body interface{}) (*http.Response, error) {
+	b, _ := json.Marshal(body)
+	return c.client.Post(c.addr+path, "application/json", bytes.NewBuffer(b))
+}
+
+// error from an unexpected response
+func unexpected(res *http.Response) error {
+	body, _ := ioutil.ReadAll(res.Body)
+	return fmt.Errorf("coordinator responded with %d: %s", res.StatusCode, strings.TrimSpace(string(body)))
+}
@@ -0,0 +1,345 @@
+package coordinator
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var logMsg = log.Infof
+var logWarn = log.Warnf
+
+// lease was never issued, or expired and its URLs were re-issued
+var ErrUnknownLease = errors.New("unknown or expired lease")
+
+// URL to crawl and its distance from a seed
+type Item struct {
+	URL   string `json:"url"`
+	Depth int    `json:"depth"`
+}
+
+// URLs handed to a worker until ExpiresAt
+type Lease struct {
+	ID        string    `json:"id"`
+	URLs      []Item    `json:"urls"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// time from issuing or renewing the lease until it expires, which unlike
+	// ExpiresAt does not depend on the worker's clock
+	Duration time.Duration `json:"duration"`
+}
+
+// outcome of crawling one leased URL
+type Result struct {
+	URL        string   `json:"url"`
+	Depth      int      `json:"depth"`
+	Discovered []string `json:"discovered"`
+	NodesAdded int      `json:"nodesAdded"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// progress of a distributed crawl, served on /status
+type Status struct {
+	Frontier   int            `json:"frontier"`
+	Leased     int            `json:"leased"`
+	Completed  int            `json:"completed"`
+	Failed     int            `json:"failed"`
+	Reissued   int            `json:"reissued"`
+	NodesAdded int            `json:"nodesAdded"`
+	Budget     int            `json:"budget"`
+	MaxDepth   int            `json:"maxDepth"`
+	Workers    map[string]int `json:"workers"`
+	Done       bool           `json:"done"`
+}
+
+type lease struct {
+	Lease
+	worker string
+}
+
+// owns the frontier and visited set, handing out URLs to workers in leases.
+// leases which are not renewed or completed in time are re-issued.
+type Coordinator struct {
+	sync.Mutex
+	frontier      []Item
+	seen          map[string]bool
+	leases        map[string]*lease
+	leaseDuration time.Duration
+	budget        int
+	maxDepth      int
+	nextID        int
+	status        Status
+	done          chan struct{}
+	// current time, replaced in tests
+	now func() time.Time
+}
+
+// coordinator starting from seeds, done once budget nodes are added ('-1' for
+// unlimited) or there is nothing left to crawl
+func New(seeds []string, budget int, leaseDuration time.Duration) *Coordinator {
+	c := &Coordinator{
+		seen:          make(map[string]bool),
+		leases:        make(map[string]*lease),
+		leaseDuration: leaseDuration,
+		budget:        budget,
+		maxDepth:      -1,
+		status:        Status{Budget: budget, Workers: make(map[string]int)},
+		done:          make(chan struct{}),
+		now:           time.Now,
+	}
+	for _, s := range seeds {
+		c.enqueue(Item{URL: s})
+	}
+	return c
+}
+
+// only hands out pages at most max links away from a seed, '-1' for
+// unlimited
+func (c *Coordinator) LimitDepth(max int) {
+	c.Lock()
+	defer c.Unlock()
+	c.maxDepth = max
+}
+
+// adds item to frontier if it was not seen before
+func (c *Coordinator) enqueue(item Item) {
+	if c.seen[item.URL] {
+		return
+	}
+	c.seen[item.URL] = true
+	c.frontier = append(c.frontier, item)
+}
+
+// leases up to max URLs to worker. the lease has no URLs if none are
+// available right now, done is true once the crawl is finished.
+func (c *Coordinator) Lease(worker string, max int) (l Lease, done bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.reap()
+	if c.checkDone() {
+		return l, true
+	}
+	if max < 1 {
+		max = 1
+	}
+	if max > len(c.frontier) {
+		max = len(c.frontier)
+	}
+	if max == 0 {
+		return l, false
+	}
+	c.nextID++
+	l = Lease{
+		ID:        strconv.Itoa(c.nextID),
+		URLs:      append([]Item{}, c.frontier[:max]...),
+		ExpiresAt: c.now().Add(c.leaseDuration),
+		Duration:  c.leaseDuration,
+	}
+	c.frontier = c.frontier[max:]
+	c.leases[l.ID] = &lease{Lease: l, worker: worker}
+	c.status.Workers[worker] += len(l.URLs)
+	return l, false
+}
+
+// extends lease by the lease duration
+func (c *Coordinator) Renew(id string) (Lease, error) {
+	c.Lock()
+	defer c.Unlock()
+	c.reap()
+	l, ok := c.leases[id]
+	if !ok {
+		return Lease{}, ErrUnknownLease
+	}
+	l.ExpiresAt = c.now().Add(c.leaseDuration)
+	l.Duration = c.leaseDuration
+	return l.Lease, nil
+}
+
+// records results and releases lease. results of unknown leases are still
+// recorded, their URLs may have been re-issued and be crawled twice.
+func (c *Coordinator) Complete(id string, results []Result) error {
+	c.Lock()
+	defer c.Unlock()
+	var err error
+	if _, ok := c.leases[id]; ok {
+		delete(c.leases, id)
+	} else {
+		err = ErrUnknownLease
+	}
+	for _, r := range results {
+		if r.Error != "" {
+			c.status.Failed++
+			continue
+		}
+		c.status.Completed++
+		c.status.NodesAdded += r.NodesAdded
+		if r.Depth > c.status.MaxDepth {
+			c.status.MaxDepth = r.Depth
+		}
+		if c.maxDepth != -1 && r.Depth >= c.maxDepth {
+			continue
+		}
+		for _, u := range r.Discovered {
+			c.enqueue(Item{URL: u, Depth: r.Depth + 1})
+		}
+	}
+	c.checkDone()
+	return err
+}
+
+// returns URLs of expired leases to the front of the frontier
+func (c *Coordinator) reap() {
+	now := c.now()
+	for id, l := range c.leases {
+		if now.Before(l.ExpiresAt) {
+			continue
+		}
+		logWarn("lease %s of worker %s expired, re-issuing %d URLs", id, l.worker, len(l.URLs))
+		c.frontier = append(append([]Item{}, l.URLs...), c.frontier...)
+		c.status.Reissued += len(l.URLs)
+		delete(c.leases, id)
+	}
+}
+
+// marks crawl as done if budget is reached or nothing is left to crawl
+func (c *Coordinator) checkDone() bool {
+	if c.status.Done {
+		return true
+	}
+	budgetReached := c.budget != -1 && c.status.NodesAdded >= c.budget
+	exhausted := len(c.frontier) == 0 && len(c.leases) == 0
+	if budgetReached || exhausted {
+		logMsg("crawl done: %d nodes added, %d URLs left in frontier", c.status.NodesAdded, len(c.frontier))
+		c.status.Done = true
+		close(c.done)
+	}
+	return c.status.Done
+}
+
+// closed once the crawl is done
+func (c *Coordinator) Done() <-chan struct{} {
+	return c.done
+}
+
+// snapshot of crawl progress
+func (c *Coordinator) Status() Status {
+	c.Lock()
+	defer c.Unlock()
+	s := c.status
+	s.Frontier = len(c.frontier)
+	s.Workers = make(map[string]int)
+	for w, n := range c.status.Workers {
+		s.Workers[w] = n
+	}
+	for _, l := range c.leases {
+		s.Leased += len(l.URLs)
+	}
+	return s
+}
+
+// HTTP API used by workers:
+//
+//	POST /leases                 {"worker": "...", "max": 10} => Lease, 204 if none available, 410 when done
+//	POST /leases/{id}/renew      => Lease, 404 if unknown or expired
+//	POST /leases/{id}/complete   {"results": [...]}
+//	GET  /status                 => Status
+func (c *Coordinator) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/leases", c.leasesHandler)
+	mux.HandleFunc("/leases/", c.leaseHandler)
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, c.Status())
+	})
+	return mux
+}
+
+type leaseRequest struct {
+	Worker string `json:"worker"`
+	Max    int    `json:"max"`
+}
+
+type completeRequest struct {
+	Results []Result `json:"results"`
+}
+
+func (c *Coordinator) leasesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	req := leaseRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	l, done := c.Lease(req.Worker, req.Max)
+	switch {
+	case done:
+		w.WriteHeader(http.StatusGone)
+	case len(l.URLs) == 0:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeJSON(w, http.StatusOK, l)
+	}
+}
+
+func (c *Coordinator) leaseHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/leases/"), "/")
+	if len(parts) != 2 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	id := parts[0]
+	switch parts[1] {
+	case "renew":
+		l, err := c.Renew(id)
+		if err != nil {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, l)
+	case "complete":
+		req := completeRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if err := c.Complete(id, req.Results); err != nil {
+			logWarn("lease %s completed after it expired", id)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// serves c on port until the crawl is done, then keeps answering for linger
+// so workers learn that it is done
+func Serve(c *Coordinator, port string, linger time.Duration) error {
+	server := &http.Server{Addr: fmt.Sprintf(":%s", port), Handler: c.Handler()}
+	errs := make(chan error, 1)
+	go func() { errs <- server.ListenAndServe() }()
+	logMsg("coordinator listening on :%s", port)
+	select {
+	case err := <-errs:
+		return err
+	case <-c.Done():
+	}
+	time.Sleep(linger)
+	return server.Close()
+}
@@ -0,0 +1,154 @@
+package coordinator
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// coordinator with a clock which only moves when advanced
+func testCoordinator(seeds []string, budget int) (*Coordinator, func(time.Duration)) {
+	c := New(seeds, budget, time.Minute)
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	return c, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestLease(t *testing.T) {
+	c, _ := testCoordinator([]string{"/a", "/b", "/c", "/a"}, -1)
+	t.Run("leases up to max URLs", func(t *testing.T) {
+		l, done := c.Lease("w1", 2)
+		assert.False(t, done)
+		assert.Equal(t, []Item{{"/a", 0}, {"/b", 0}}, l.URLs)
+		l, done = c.Lease("w2", 5)
+		assert.False(t, done)
+		assert.Equal(t, []Item{{"/c", 0}}, l.URLs)
+	})
+	t.Run("has no URLs while others are leased", func(t *testing.T) {
+		l, done := c.Lease("w3", 5)
+		assert.False(t, done)
+		assert.Empty(t, l.URLs)
+		s := c.Status()
+		assert.Equal(t, 3, s.Leased)
+		assert.Equal(t, map[string]int{"w1": 2, "w2": 1}, s.Workers)
+	})
+}
+
+func TestComplete(t *testing.T) {
+	c, _ := testCoordinator([]string{"/a"}, -1)
+	l, _ := c.Lease("w1", 1)
+	err := c.Complete(l.ID, []Result{
+		{URL: "/a", Depth: 0, Discovered: []string{"/b", "/a", "/c", "/b"}, NodesAdded: 2},
+	})
+	assert.Nil(t, err)
+	// discovered links are enqueued once, one level deeper
+	l, _ = c.Lease("w1", 10)
+	assert.Equal(t, []Item{{"/b", 1}, {"/c", 1}}, l.URLs)
+	assert.Nil(t, c.Complete(l.ID, []Result{{URL: "/b", Depth: 1}, {URL: "/c", Depth: 1, Error: "timeout"}}))
+	s := c.Status()
+	assert.Equal(t, 2, s.Completed)
+	assert.Equal(t, 1, s.Failed)
+	assert.Equal(t, 2, s.NodesAdded)
+	assert.Equal(t, 1, s.MaxDepth)
+	// nothing left to crawl
+	assert.True(t, s.Done)
+	_, done := c.Lease("w1", 1)
+	assert.True(t, done)
+	select {
+	case <-c.Done():
+	default:
+		t.Error("expected done channel to be closed")
+	}
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	c, advance := testCoordinator([]string{"/a", "/b"}, -1)
+	dead, _ := c.Lease("dead", 1)
+	alive, _ := c.Lease("alive", 1)
+	t.Run("renewed leases are kept", func(t *testing.T) {
+		advance(45 * time.Second)
+		_, err := c.Renew(alive.ID)
+		assert.Nil(t, err)
+		advance(30 * time.Second)
+		l, _ := c.Lease("new", 5)
+		// only the dead worker's URL is re-issued
+		assert.Equal(t, []Item{{"/a", 0}}, l.URLs)
+		assert.Equal(t, 1, c.Status().Reissued)
+	})
+	t.Run("expired leases cannot be renewed", func(t *testing.T) {
+		_, err := c.Renew(dead.ID)
+		assert.Equal(t, ErrUnknownLease, err)
+	})
+	t.Run("results of expired leases are still recorded", func(t *testing.T) {
+		err := c.Complete(dead.ID, []Result{{URL: "/a", Discovered: []string{"/d"}, NodesAdded: 1}})
+		assert.Equal(t, ErrUnknownLease, err)
+		assert.Equal(t, 1, c.Status().NodesAdded)
+		assert.Equal(t, 1, c.Status().Frontier)
+	})
+}
+
+func TestLimitDepth(t *testing.T) {
+	c, _ := testCoordinator([]string{"/a"}, -1)
+	c.LimitDepth(1)
+	l, _ := c.Lease("w1", 1)
+	assert.Nil(t, c.Complete(l.ID, []Result{{URL: "/a", Depth: 0, Discovered: []string{"/b"}}}))
+	l, _ = c.Lease("w1", 1)
+	assert.Equal(t, []Item{{"/b", 1}}, l.URLs)
+	// links of pages at max depth are not crawled
+	assert.Nil(t, c.Complete(l.ID, []Result{{URL: "/b", Depth: 1, Discovered: []string{"/c"}, NodesAdded: 1}}))
+	s := c.Status()
+	assert.Equal(t, 0, s.Frontier)
+	assert.Equal(t, 1, s.NodesAdded)
+	assert.True(t, s.Done)
+}
+
+func TestBudget(t *testing.T) {
+	c, _ := testCoordinator([]string{"/a"}, 3)
+	l, _ := c.Lease("w1", 1)
+	c.Complete(l.ID, []Result{{URL: "/a", Discovered: []string{"/b", "/c", "/d"}, NodesAdded: 3}})
+	_, done := c.Lease("w1", 1)
+	assert.True(t, done)
+	assert.Equal(t, 3, c.Status().Frontier)
+}
+
+func TestClient(t *testing.T) {
+	c, _ := testCoordinator([]string{"/a", "/b"}, -1)
+	server := httptest.NewServer(c.Handler())
+	defer server.Close()
+	client := NewClient(server.Listener.Addr().String())
+
+	l, done, err := client.Lease("w1", 1)
+	require.Nil(t, err)
+	assert.False(t, done)
+	assert.Equal(t, []Item{{"/a", 0}}, l.URLs)
+	// workers renew by duration, their clocks may differ
+	assert.Equal(t, time.Minute, l.Duration)
+
+	renewed, err := client.Renew(l.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, l.ID, renewed.ID)
+	assert.Equal(t, time.Minute, renewed.Duration)
+	_, err = client.Renew("404")
+	assert.Equal(t, ErrUnknownLease, err)
+
+	l2, _, err := client.Lease("w2", 5)
+	require.Nil(t, err)
+	// nothing left to lease, but not done
+	l3, done, err := client.Lease("w3", 5)
+	assert.Nil(t, err)
+	assert.False(t, done)
+	assert.Empty(t, l3.URLs)
+
+	assert.Nil(t, client.Complete(l.ID, []Result{{URL: "/a"}}))
+	assert.Nil(t, client.Complete(l2.ID, []Result{{URL: "/b"}}))
+	_, done, err = client.Lease("w1", 1)
+	assert.Nil(t, err)
+	assert.True(t, done)
+
+	t.Run("fails on bad address", func(t *testing.T) {
+		_, _, err := NewClient("localhost:1").Lease("w1", 1)
+		assert.Error(t, err)
+	})
+}
@@ -2,23 +2,36 @@ package counties
 
 import (
 	"bufio"
+	"context"
+	"github.com/dgoldstein1/crawler/config"
 	"github.com/dgoldstein1/crawler/db"
 	"github.com/dgoldstein1/crawler/util"
 	"github.com/dgoldstein1/crawler/wikipedia"
 	"github.com/gocolly/colly"
-	log "github.com/sirupsen/logrus"
 	"os"
 	"strings"
 	"time"
 )
 
 // globals
-var logErr = log.Errorf
 var prefix = "/wiki/"
 var baseEndpoint = "https://en.wikipedia.org"
 var timeout = time.Duration(5 * time.Second)
 var counties = make(map[string]bool)
 
+// counties are read from this file, see Configure
+var countiesList = ""
+
+// base URL of the site
+func BaseEndpoint() string {
+	return baseEndpoint
+}
+
+// reads counties from cfg.CountiesList
+func Configure(cfg config.Config) {
+	countiesList = cfg.CountiesList
+}
+
 func IsValidCrawlLink(link string) bool {
 	// countains the word 'county' in format 'NAME_county,_STATE'
 	if !strings.Contains(strings.ToLower(link), "_county,_") {
@@ -31,7 +44,7 @@ func IsValidCrawlLink(link string) bool {
 func stringInFile(link string) bool {
 	// read in counties list if does not exist
 	if len(counties) == 0 {
-		file, err := os.Open(os.Getenv("COUNTIES_LIST"))
+		file, err := os.Open(countiesList)
 		defer file.Close()
 		if err != nil {
 			panic(err)
@@ -48,6 +61,7 @@ func stringInFile(link string) bool {
 func GetRandomNode() (string, error) {
 	return util.ReadRandomLineFromFile(
 		"COUNTIES_LIST",
+		countiesList,
 		baseEndpoint,
 		prefix,
 		false,
@@ -56,7 +70,7 @@ func GetRandomNode() (string, error) {
 
 // decodes and standaridizes URL
 func CleanUrl(link string) string {
-	return wikipedia.CleanUrl(link)
+	return wikipedia.English.CleanUrl(link)
 }
 
 // filters down full page body to elements we want to focus on
@@ -73,6 +87,7 @@ func FilterPage(e *colly.HTMLElement) (*colly.HTMLElement, error) {
 
 // adds edge to DB, returns new neighbors added (to crawl on)
 func AddEdgesIfDoNotExist(
+	ctx context.Context,
 	currentNode string,
 	neighborNodes []string,
 ) (
@@ -80,6 +95,7 @@ func AddEdgesIfDoNotExist(
 	err error,
 ) {
 	return db.AddEdgesIfDoNotExist(
+		ctx,
 		currentNode,
 		neighborNodes,
 		CleanUrl,
@@ -1,12 +1,11 @@
 package counties
 
 import (
-	"fmt"
+	"context"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/gocolly/colly"
 	"github.com/stretchr/testify/assert"
 	"net/http"
-	"os"
 	"testing"
 )
 
@@ -14,8 +13,8 @@ var dbEndpoint = "http://localhost:17474"
 var twoWayEndpoint = "http://localhost:17475"
 
 func TestIsValidCrawlLink(t *testing.T) {
-	os.Setenv("COUNTIES_LIST", "counties.txt")
-	defer os.Unsetenv("COUNTIES_LIST")
+	countiesList = "counties.txt"
+	defer func() { countiesList = "" }()
 	testTable := []struct {
 		name              string
 		input             string
@@ -38,22 +37,13 @@ func TestIsValidCrawlLink(t *testing.T) {
 	// assert panic
 	assert.Panics(t, func() {
 		counties = map[string]bool{}
-		os.Setenv("COUNTIES_LIST", "sdfsdflkj.txt")
+		countiesList = "sdfsdflkj.txt"
 		IsValidCrawlLink("/wiki/Albemarle_County,_Virginia")
 	}, "The code did not panic")
 
 }
 
 func TestGetRandomNode(t *testing.T) {
-	errorsLogged := []string{}
-	logErr = func(format string, args ...interface{}) {
-		if len(args) > 0 {
-			errorsLogged = append(errorsLogged, fmt.Sprintf(format, args))
-		} else {
-			errorsLogged = append(errorsLogged, format)
-		}
-	}
-
 	type Test struct {
 		Name          string
 		ExpectedError string
@@ -66,20 +56,20 @@ func TestGetRandomNode(t *testing.T) {
 			Name:          "COUNTIES_LIST not set",
 			ExpectedError: "COUNTIES_LIST was not set",
 			Before: func() {
-				os.Setenv("COUNTIES_LIST", "")
+				countiesList = ""
 			},
 			After: func() {
-				os.Unsetenv("COUNTIES_LIST")
+				countiesList = ""
 			},
 		},
 		Test{
 			Name:          "does not output same node twice",
 			ExpectedError: "",
 			Before: func() {
-				os.Setenv("COUNTIES_LIST", "counties.txt")
+				countiesList = "counties.txt"
 			},
 			After: func() {
-				os.Unsetenv("COUNTIES_LIST")
+				countiesList = ""
 			},
 		},
 	}
@@ -233,6 +223,6 @@ func TestFilterPage(t *testing.T) {
 func TestAddEdgesIfDoNotExist(t *testing.T) {
 	node := "/synonym/ar/test"
 	neighbors := []string{}
-	added, _ := AddEdgesIfDoNotExist(node, neighbors)
+	added, _ := AddEdgesIfDoNotExist(context.Background(), node, neighbors)
 	assert.Equal(t, added, []string(nil))
 }
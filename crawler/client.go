@@ -0,0 +1,156 @@
+package crawler
+
+import (
+	"context"
+	"github.com/dgoldstein1/crawler/config"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sets user agent and extra headers on every request
+type headerTransport struct {
+	next      http.RoundTripper
+	userAgent string
+	headers   map[string]string
+}
+
+func (h *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// round trippers must not modify the request they were given
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", h.userAgent)
+	for name, value := range h.headers {
+		req.Header.Set(name, value)
+	}
+	return h.next.RoundTrip(req)
+}
+
+// requests in flight to each host, shared by the collector and clients
+// sending requests outside of it so a host gets at most PARALLELISM
+// requests at once from all of them
+type hostLimits struct {
+	sync.Mutex
+	parallelism int
+	delay       time.Duration
+	timeout     time.Duration
+	slots       map[string]chan struct{}
+}
+
+// limits of the crawl, replaced when transports are created with other ones
+var limits = struct {
+	sync.Mutex
+	shared *hostLimits
+}{}
+
+// limits of requests sent with cfg, the same for every transport of a crawl
+func sharedLimits(cfg config.Config) *hostLimits {
+	parallelism := cfg.Parallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	delay := time.Duration(cfg.MsDelay) * time.Millisecond
+	timeout := time.Duration(cfg.RequestTimeoutSec) * time.Second
+	limits.Lock()
+	defer limits.Unlock()
+	l := limits.shared
+	if l == nil || l.parallelism != parallelism || l.delay != delay || l.timeout != timeout {
+		l = &hostLimits{
+			parallelism: parallelism,
+			delay:       delay,
+			timeout:     timeout,
+			slots:       make(map[string]chan struct{}),
+		}
+		limits.shared = l
+	}
+	return l
+}
+
+// slots of requests to host
+func (h *hostLimits) of(host string) chan struct{} {
+	h.Lock()
+	defer h.Unlock()
+	slots, ok := h.slots[host]
+	if !ok {
+		slots = make(chan struct{}, h.parallelism)
+		h.slots[host] = slots
+	}
+	return slots
+}
+
+// sends requests once their host has a free slot, each slot is only freed
+// delay after its response was read, like colly's limit rules. the timeout
+// starts once the request is sent, waiting for a slot does not count
+type limitTransport struct {
+	next   http.RoundTripper
+	limits *hostLimits
+}
+
+func (l *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	slots := l.limits.of(req.URL.Host)
+	select {
+	case slots <- struct{}{}:
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), l.limits.timeout)
+	once := sync.Once{}
+	release := func() {
+		once.Do(func() {
+			cancel()
+			go func() {
+				time.Sleep(l.limits.delay)
+				<-slots
+			}()
+		})
+	}
+	res, err := l.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		release()
+		return res, err
+	}
+	res.Body = &releasedBody{ReadCloser: res.Body, release: release}
+	return res, nil
+}
+
+// body which calls release once it is closed
+type releasedBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasedBody) Close() error {
+	defer b.release()
+	return b.ReadCloser.Close()
+}
+
+// limits next with PARALLELISM, MS_DELAY and REQUEST_TIMEOUT_SEC of cfg,
+// shared with all other transports of cfg
+func newLimitTransport(next http.RoundTripper, cfg config.Config) *limitTransport {
+	return &limitTransport{next: next, limits: sharedLimits(cfg)}
+}
+
+// client for requests sites send outside of the collector, e.g. for further
+// batches of an API response. requests are sent with the user agent,
+// headers, proxies, guards and limits of page requests, responses of
+// contentType are allowed besides ALLOWED_CONTENT_TYPES
+func NewClient(cfg config.Config, contentType string) (*http.Client, error) {
+	headers, err := config.ParseHeaders(cfg.RequestHeaders)
+	if err != nil {
+		return nil, err
+	}
+	transport, err := pageTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+	guard := newGuardTransport(transport, cfg)
+	guard.contentTypes = append(guard.contentTypes, contentType)
+	return &http.Client{
+		Transport: &headerTransport{
+			next:      newLimitTransport(guard, cfg),
+			userAgent: cfg.UserAgent,
+			headers:   headers,
+		},
+		CheckRedirect: redirectLimit(cfg),
+	}, nil
+}
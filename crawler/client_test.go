@@ -0,0 +1,107 @@
+package crawler
+
+import (
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/gocolly/colly"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewClient(t *testing.T) {
+	received := http.Header{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		w.Header().Set("Content-Type", r.URL.Query().Get("type"))
+		w.Write([]byte("{}"))
+	}))
+	defer server.Close()
+
+	t.Run("sends user agent and headers", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.RequestHeaders = "From: ops@example.com"
+		client, err := NewClient(cfg, "application/json")
+		assert.Nil(t, err)
+		res, err := client.Get(server.URL + "?type=application/json")
+		assert.Nil(t, err)
+		body, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		assert.Equal(t, "{}", string(body))
+		assert.Equal(t, config.DefaultUserAgent, received.Get("User-Agent"))
+		assert.Equal(t, "ops@example.com", received.Get("From"))
+	})
+	t.Run("guards responses", func(t *testing.T) {
+		client, err := NewClient(config.Default(), "application/json")
+		assert.Nil(t, err)
+		_, err = client.Get(server.URL + "?type=image/png")
+		assert.Equal(t, "content_type", rejectionReason(err))
+	})
+	t.Run("waits MS_DELAY between requests", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.Parallelism = 1
+		cfg.MsDelay = 100
+		client, err := NewClient(cfg, "application/json")
+		assert.Nil(t, err)
+		start := time.Now()
+		for i := 0; i < 2; i++ {
+			res, err := client.Get(server.URL + "?type=application/json")
+			assert.Nil(t, err)
+			res.Body.Close()
+		}
+		assert.True(t, time.Since(start) >= 100*time.Millisecond)
+	})
+	t.Run("fails on bad headers", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.RequestHeaders = "no colon"
+		_, err := NewClient(cfg, "application/json")
+		assert.Error(t, err)
+	})
+}
+
+func TestSharedLimits(t *testing.T) {
+	mutex := sync.Mutex{}
+	inFlight, maxInFlight := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mutex.Unlock()
+		time.Sleep(600 * time.Millisecond)
+		mutex.Lock()
+		inFlight--
+		mutex.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.Parallelism = 1
+	cfg.MsDelay = 0
+	cfg.RequestTimeoutSec = 1
+	c := colly.NewCollector(colly.Async(true))
+	assert.Nil(t, configureRequests(c, cfg))
+	c.AllowURLRevisit = true
+	var pageErr error
+	c.OnError(func(r *colly.Response, err error) { pageErr = err })
+	client, err := NewClient(cfg, "application/json")
+	assert.Nil(t, err)
+
+	c.Visit(server.URL + "/page")
+	c.Visit(server.URL + "/other")
+	res, err := client.Get(server.URL + "/batch")
+	assert.Nil(t, err)
+	ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	c.Wait()
+	// one request to the host at a time, waiting for a slot does not count
+	// toward the timeout
+	assert.Nil(t, pageErr)
+	assert.Equal(t, 1, maxInFlight)
+}
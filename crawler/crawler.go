@@ -1,125 +1,425 @@
 package crawler
 
 import (
+	"context"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/util"
 	"github.com/gocolly/colly"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
-var logMsg = log.Infof
-var logErr = log.Errorf
-var logWarn = log.Warnf
-var logFatal = log.Fatalf
+// loggers including fields (url, depth, stage) attached to ctx
+var logMsg = func(ctx context.Context, format string, args ...interface{}) {
+	util.Logger(ctx).Infof(format, args...)
+}
+var logErr = func(ctx context.Context, format string, args ...interface{}) {
+	util.Logger(ctx).Errorf(format, args...)
+}
+var logWarn = func(ctx context.Context, format string, args ...interface{}) {
+	util.Logger(ctx).Warnf(format, args...)
+}
+var logFatal = func(ctx context.Context, format string, args ...interface{}) {
+	util.Logger(ctx).Fatalf(format, args...)
+}
 
-// crawls until approximateMaxNodes nodes is reached
+// directory pages are cached in
+var CacheDir = "/tmp/crawlercache"
+
+// crawls until cfg.MaxApproxNodes nodes is reached
 func Run(
-	endpoint string,
+	cfg config.Config,
 	isValidCrawlLink IsValidCrawlLinkFunction,
 	connectToDB ConnectToDBFunction,
 	addEdgesIfDoNotExist AddEdgeFunction,
 	getNewNode GetNewNodeFunction,
 	filterPage FilterPageFunction,
 ) {
+	ctx := context.Background()
 	// first connect to db
 	if err := connectToDB(); err != nil {
-		logFatal("Could not connect do db: %v", err)
+		logFatal(ctx, "Could not connect do db: %v", err)
 	}
-	// get starting link if there isn't one already
-	if endpoint == "" {
-		logMsg("Finding new node..")
-		e, err := getNewNode()
-		if err != nil {
-			logFatal("Could not find new starting node: %v", err)
-		} else {
-			endpoint = e
-		}
-		logMsg("New node found: %s", e)
+	seeds, err := Seeds(cfg, isValidCrawlLink, getNewNode)
+	if err != nil {
+		logFatal(ctx, "%v", err)
+	}
+	// random seeds may belong to other shards, find one of our own
+	shards := newSharder(cfg)
+	if owned, _ := shards.split(seeds); len(owned) == 0 && shards.enabled() && !shards.forwarding() {
+		seeds = append(seeds, ownedSeed(shards, getNewNode)...)
 	}
-	// parse out env
-	maxNodes, _ := strconv.Atoi(os.Getenv("MAX_APPROX_NODES"))
-	parallelism, _ := strconv.Atoi(os.Getenv("PARALLELISM"))
-	msDelay, _ := strconv.Atoi(os.Getenv("MS_DELAY"))
 	Crawl(
-		endpoint,
-		int32(maxNodes),
-		parallelism,
-		msDelay,
+		cfg,
+		seeds,
 		isValidCrawlLink,
 		addEdgesIfDoNotExist,
 		filterPage,
 	)
 }
 
-// crawls a domain and saves relatives links to a db
+// starting links for a crawl: cfg.StartingEndpoint and links from
+// cfg.SeedSitemap, or a new node if neither is set
+func Seeds(
+	cfg config.Config,
+	isValidCrawlLink IsValidCrawlLinkFunction,
+	getNewNode GetNewNodeFunction,
+) ([]string, error) {
+	ctx := context.Background()
+	isValidCrawlLink, err := withLinkRules(cfg, isValidCrawlLink)
+	if err != nil {
+		return nil, err
+	}
+	seeds := []string{}
+	if cfg.StartingEndpoint != "" {
+		seeds = append(seeds, cfg.StartingEndpoint)
+	}
+	// seed from sitemap instead of a random node
+	if cfg.SeedSitemap != "" {
+		logMsg(ctx, "Loading seeds from sitemap %s..", cfg.SeedSitemap)
+		sitemapSeeds, err := LoadSitemap(cfg.SeedSitemap, siteHost, cfg.UserAgent, isValidCrawlLink)
+		if err != nil {
+			return seeds, fmt.Errorf("Could not load sitemap: %v", err)
+		}
+		if len(sitemapSeeds) == 0 {
+			return seeds, fmt.Errorf("Sitemap %s had no valid crawl links", cfg.SeedSitemap)
+		}
+		logMsg(ctx, "Found %d seeds in sitemap", len(sitemapSeeds))
+		seeds = append(seeds, sitemapSeeds...)
+	}
+	// get starting link if there isn't one already
+	if len(seeds) == 0 {
+		logMsg(ctx, "Finding new node..")
+		e, err := getNewNode()
+		if err != nil {
+			return seeds, fmt.Errorf("Could not find new starting node: %v", err)
+		}
+		logMsg(ctx, "New node found: %s", e)
+		seeds = append(seeds, e)
+	}
+	return seeds, nil
+}
+
+// draws new nodes until one is owned by this shard, gives up after a while
+func ownedSeed(shards *sharder, getNewNode GetNewNodeFunction) []string {
+	for i := 0; i < 20*shards.count; i++ {
+		n, err := getNewNode()
+		if err == nil && shards.owns(n) {
+			return []string{n}
+		}
+	}
+	logErr(context.Background(), "Could not find a new node owned by shard %d", shards.index)
+	return []string{}
+}
+
+// crawls a domain from seeds and saves relatives links to a db.
+// only links owned by this shard are visited, others are forwarded.
 func Crawl(
-	endpoint string,
-	approximateMaxNodes int32,
-	parallelism int,
-	msDelay int,
+	cfg config.Config,
+	seeds []string,
 	isValidCrawlLink IsValidCrawlLinkFunction,
 	addEdgesIfDoNotExist AddEdgeFunction,
 	filterPage FilterPageFunction,
 ) {
+	isValidCrawlLink, err := withLinkRules(cfg, isValidCrawlLink)
+	if err != nil {
+		logFatal(context.Background(), "Could not load link rules: %v", err)
+	}
+	if err := openPageStates(cfg.PageStateFile); err != nil {
+		logFatal(context.Background(), "%v", err)
+	}
+	c := newCollector(cfg)
+	shards := newSharder(cfg)
+	// On every page call callback with its links
+	onPage(c, isValidCrawlLink, filterPage, func(r *colly.Response, url string, links map[string][]string) {
+		ctx := pageContext(r.Request)
+		nodesAdded, continuations, _ := handlePage(r, url, links, addEdgesIfDoNotExist)
+		// stopping condition
+		approximateMaxNodes := int32(cfg.MaxApproxNodes)
+		if approximateMaxNodes != -1 && (totalNodesAdded.get() >= approximateMaxNodes) {
+			logMsg(ctx, "Stopping condition reached: %v nodes added >= %v approximateMaxNodes", totalNodesAdded.get(), approximateMaxNodes)
+			finishCrawl(cfg, "budget reached")
+			flushTracing()
+			os.Exit(0)
+			return
+		}
+		// the rest of the page's node is crawled even at max depth
+		visitContinuations(c, r.Request, continuations)
+		// links of pages at max depth are edges, but are neither crawled
+		// nor forwarded
+		depth := pageDepth(r.Request)
+		if cfg.MaxDepth != -1 && depth > cfg.MaxDepth {
+			return
+		}
+		nodesAdded, foreign := shards.split(nodesAdded)
+		shards.forward(ctx, foreign, depth+1)
+		// recurse on new nodes if no stopping condition yet
+		for _, node := range nodesAdded {
+			err := r.Request.Visit(requestURL(node))
+			if err != nil {
+				logWarn(ctx, "Error visiting '%s', %v", node, err)
+			} else {
+				updateFrontier(1)
+			}
+		}
+	})
+	// Start scraping on seeds
+	logMsg(context.Background(), "starting at %s", strings.Join(seeds, ", "))
+	startStatus(seeds, int32(cfg.MaxApproxNodes))
+	startReport(seeds)
+	seeds, foreign := shards.split(seeds)
+	shards.forward(context.Background(), foreign, 1)
+	for _, seed := range seeds {
+		if err := c.Visit(requestURL(seed)); err != nil {
+			logWarn(context.Background(), "Error visiting seed '%s', %v", seed, err)
+		} else {
+			updateFrontier(1)
+		}
+	}
+	if shards.forwarding() {
+		// visit links found by other shards for as long as they are crawling
+		go func() {
+			for link := range inbox {
+				if !shards.owns(link.URL) {
+					continue
+				}
+				if err := visitForwarded(c, link); err == nil {
+					updateFrontier(1)
+				}
+			}
+		}()
+	}
+	// Wait until threads are finished
+	c.Wait()
+	if shards.forwarding() {
+		idle := time.Duration(cfg.ShardIdleSec) * time.Second
+		logMsg(context.Background(), "frontier is empty, waiting up to %s for links from other shards", idle)
+		awaitInbox(c, idle)
+	}
+	finishCrawl(cfg, "frontier empty")
+}
+
+// async collector with limits and request settings from cfg, tracking
+// requests for metrics, /status and tracing
+func newCollector(cfg config.Config) *colly.Collector {
 	// Instantiate default collector
 	c := colly.NewCollector(
 		colly.Async(true),
-		colly.CacheDir("/tmp/crawlercache"),
+		colly.CacheDir(CacheDir),
 	)
-	c.Limit(&colly.LimitRule{
-		DomainGlob:  "*",
-		Parallelism: parallelism,
-		Delay:       time.Duration(msDelay) * time.Millisecond,
+	// requests are limited by their transport, which is shared with
+	// clients sending requests outside of the collector
+	if err := configureRequests(c, cfg); err != nil {
+		logFatal(context.Background(), "Could not configure requests: %v", err)
+	}
+
+	// track requests for /status and tracing
+	c.OnRequest(func(r *colly.Request) {
+		updateFrontier(-1)
+		inFlight.incr(1)
+		startPage(r)
+	})
+	c.OnResponse(func(r *colly.Response) {
+		endFetch(r, nil)
+	})
+	c.OnScraped(func(r *colly.Response) {
+		inFlight.incr(-1)
+		markProgress()
+		endPage(r.Request, nil)
 	})
 
 	c.OnError(func(r *colly.Response, err error) {
-		logErr("Error parsing page %s: %v", r.Request.URL, err)
+		// recrawls are answered with 304 for unchanged pages
+		if r.StatusCode == http.StatusNotModified {
+			err = nil
+		}
+		inFlight.incr(-1)
+		markProgress()
+		endFetch(r, err)
+		endPage(r.Request, err)
+		if err == nil {
+			return
+		}
+		if reason := rejectionReason(err); reason != "" {
+			countRejection(pageContext(r.Request), reason, r.Request.URL.String(), err)
+			return
+		}
+		countError("fetch", r.StatusCode)
+		logErr(util.WithLogFields(pageContext(r.Request), log.Fields{"stage": "fetch"}), "Error parsing page %s: %v", r.Request.URL, err)
 	})
+	return c
+}
 
-	// On every a element which has href attribute call callback
+// calls handle with the URL and valid links by relation of every page c
+// fetches, scraped from its HTML or read from the link API if one is set
+func onPage(
+	c *colly.Collector,
+	isValidCrawlLink IsValidCrawlLinkFunction,
+	filterPage FilterPageFunction,
+	handle func(r *colly.Response, url string, links map[string][]string),
+) {
 	c.OnHTML("html", func(e *colly.HTMLElement) {
-		logMsg("parsing %s", e.Request.URL.String())
-		// find specific portion in page, if needed
-		filteredPage, err := filterPage(e)
+		handle(e.Response, e.Request.URL.String(), extractLinks(e, isValidCrawlLink, filterPage))
+	})
+	if linkAPI == nil {
+		return
+	}
+	c.OnResponse(func(r *colly.Response) {
+		url, links, err := readLinks(r, isValidCrawlLink)
 		if err != nil {
-			logErr("Could not filter page %s, %v", e.Request.URL.String(), err)
-		}
-		// loop through all href attributes adding links
-		validURLs := []string{}
-		filteredPage.ForEach("a[href]", func(_ int, e *colly.HTMLElement) {
-			// add links which match the schema
-			link := e.Attr("href")
-			if isValidCrawlLink(link) {
-				validURLs = append(validURLs, link)
+			countError("fetch", r.StatusCode)
+			logErr(util.WithLogFields(pageContext(r.Request), log.Fields{"stage": "fetch"}), "Could not read links from %s: %v", r.Request.URL, err)
+			return
+		}
+		handle(r, url, links)
+	})
+}
+
+// adds valid links of the page at url as edges. returns the nodes to crawl
+// on, further pages of the node of url, e.g. the next page of a listing,
+// and the number of nodes added to the graph. that is the number of nodes
+// to crawl on unless addEdgesIfDoNotExist counts them with util.CountNodes.
+func handlePage(
+	r *colly.Response,
+	url string,
+	links map[string][]string,
+	addEdgesIfDoNotExist AddEdgeFunction,
+) (nodesAdded []string, continuations []string, newNodes int) {
+	ctx, count := util.WithNodeCount(pageContext(r.Request))
+	// add new nodes to current request URL, once per relation
+	nodesAdded = []string{}
+	continuations = []string{}
+	node := keyOf(url)
+	seen := make(map[string]bool)
+	total, submitted := 0, 0
+	var err error
+	for _, label := range linkLabels() {
+		total += len(links[label])
+		added, addErr := addEdgesIfDoNotExist(util.WithRelation(ctx, label), url, links[label])
+		if addErr != nil {
+			err = addErr
+			continue
+		}
+		submitted += len(links[label])
+		for _, n := range added {
+			if seen[n] {
+				continue
 			}
+			seen[n] = true
+			if keyOf(n) == node {
+				continuations = append(continuations, n)
+			} else {
+				nodesAdded = append(nodesAdded, n)
+			}
+		}
+	}
+	newNodes = len(nodesAdded)
+	if n, counted := count.Get(); counted {
+		newNodes = n
+	}
+	reportPage(r.Request.URL.Host, pageDepth(r.Request), total, submitted, newNodes, err)
+	if err != nil {
+		logErr(ctx, "error adding '%s': %s", url, err.Error())
+	} else {
+		// update metrics
+		UpdateMetrics(newNodes, pageDepth(r.Request))
+		recordPageState(r, url, links)
+	}
+	return nodesAdded, continuations, newNodes
+}
+
+// visits further pages of the node r requested at the depth of r, keeping
+// the context of r
+func visitContinuations(c *colly.Collector, r *colly.Request, links []string) {
+	for _, link := range links {
+		ctx := colly.NewContext()
+		r.Ctx.ForEach(func(k string, v interface{}) interface{} {
+			ctx.Put(k, v)
+			return nil
 		})
-		logMsg("found %v neighbors for %v", len(validURLs), e.Request.URL.String())
-		// add new nodes to current request URL
-		nodesAdded, err := addEdgesIfDoNotExist(e.Request.URL.String(), validURLs)
-		if err != nil {
-			logErr("error adding '%s': %s", e.Request.URL.String(), err.Error())
+		// requests start at depth 1
+		ctx.Put("depthOffset", strconv.Itoa(pageDepth(r)-1))
+		if err := c.Request("GET", r.AbsoluteURL(link), nil, ctx, nil); err != nil {
+			logWarn(pageContext(r), "Error visiting '%s', %v", link, err)
 		} else {
-			// update metrics
-			UpdateMetrics(len(nodesAdded), e.Request.Depth)
+			updateFrontier(1)
 		}
-		// stopping condition
-		if approximateMaxNodes != -1 && (totalNodesAdded.get() >= approximateMaxNodes) {
-			logMsg("Stopping condition reached: %v nodes added >= %v approximateMaxNodes", totalNodesAdded.get(), approximateMaxNodes)
-			os.Exit(0)
-			return
-		}
-		// recurse on new nodes if no stopping condition yet
-		for _, url := range nodesAdded {
-			err = filteredPage.Request.Visit(url)
-			if err != nil {
-				logWarn("Error visiting '%s', %v", url, err)
-			}
+	}
+}
+
+// valid links of page by relation. the page is filtered with filterPage
+// unless relations are set, then links are extracted from each relation's
+// part of the page.
+func extractLinks(
+	e *colly.HTMLElement,
+	isValidCrawlLink IsValidCrawlLinkFunction,
+	filterPage FilterPageFunction,
+) map[string][]string {
+	ctx := pageContext(e.Request)
+	logMsg(ctx, "parsing %s", e.Request.URL.String())
+	pagesVisited.incr(1)
+	filteredPage := e
+	if len(relations) == 0 {
+		// find specific portion in page, if needed
+		_, filterSpan := tracer().Start(ctx, "FilterPage")
+		filterStart := time.Now()
+		var err error
+		filteredPage, err = filterPage(e)
+		filterLatency.WithLabelValues(siteLabel()).Observe(time.Since(filterStart).Seconds())
+		endSpan(filterSpan, err)
+		if err != nil {
+			countError("filter", 0)
+			logErr(util.WithLogFields(ctx, log.Fields{"stage": "filter"}), "Could not filter page %s, %v", e.Request.URL.String(), err)
 		}
+	}
+	// loop through all href attributes adding links
+	_, extractSpan := tracer().Start(ctx, "extract links")
+	links := make(map[string][]string)
+	total := 0
+	for _, r := range relations {
+		links[r.Label] = validLinks(ctx, e.DOM.Find(r.Selector), isValidCrawlLink)
+		total += len(links[r.Label])
+	}
+	if len(relations) == 0 {
+		links[""] = validLinks(ctx, filteredPage.DOM, isValidCrawlLink)
+		total = len(links[""])
+	}
+	extractSpan.SetAttributes(attribute.Int("links", total))
+	extractSpan.End()
+	logMsg(ctx, "found %v neighbors for %v", total, e.Request.URL.String())
+	return links
+}
+
+// links in selection which match the schema
+func validLinks(ctx context.Context, selection *goquery.Selection, isValidCrawlLink IsValidCrawlLinkFunction) []string {
+	links := []string{}
+	selection.Find("a[href]").Each(func(_ int, s *goquery.Selection) {
+		link, _ := s.Attr("href")
+		links = append(links, link)
 	})
-	// Start scraping on endpoint
-	logMsg("starting at %s", endpoint)
-	c.Visit(endpoint)
-	// Wait until threads are finished
-	c.Wait()
+	return filterLinks(ctx, links, isValidCrawlLink)
+}
+
+// links which match the schema. rejections are very frequent, only a
+// sample of them is logged
+func filterLinks(ctx context.Context, links []string, isValidCrawlLink IsValidCrawlLinkFunction) []string {
+	validURLs := []string{}
+	for _, link := range links {
+		if isValidCrawlLink(link) {
+			validURLs = append(validURLs, link)
+			continue
+		}
+		countRejectedLink()
+		if ok, suppressed := util.ShouldLog(siteLabel() + " invalid link"); ok {
+			logErr(util.WithLogFields(ctx, log.Fields{"stage": "filter"}), "invalid link found %s (%d similar messages suppressed)", link, suppressed)
+		}
+	}
+	return validURLs
 }
@@ -1,20 +1,27 @@
 package crawler
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/util"
 	"github.com/gocolly/colly"
 	"github.com/stretchr/testify/assert"
 	"math/rand"
-	"os"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 )
 
 func TestRun(t *testing.T) {
 	// return 'true' if edge already exists
 	nodesAdded := []string{}
-	addEdge := func(currNode string, neighborNodes []string) ([]string, error) {
+	addEdge := func(ctx context.Context, currNode string, neighborNodes []string) ([]string, error) {
 		temp := []string{}
 		for _, v := range neighborNodes {
 			temp = append(temp, "https://en.wikipedia.org"+v)
@@ -30,7 +37,7 @@ func TestRun(t *testing.T) {
 	originLogFatalf := logFatal
 	defer func() { logFatal = originLogFatalf }()
 	logs := []string{}
-	logFatal = func(format string, args ...interface{}) {
+	logFatal = func(ctx context.Context, format string, args ...interface{}) {
 		if len(args) > 0 {
 			logs = append(logs, fmt.Sprintf(format, args))
 		} else {
@@ -38,7 +45,7 @@ func TestRun(t *testing.T) {
 		}
 	}
 
-	logMsg = func(format string, args ...interface{}) {}
+	logMsg = func(ctx context.Context, format string, args ...interface{}) {}
 
 	type Test struct {
 		Name             string
@@ -142,10 +149,11 @@ func TestRun(t *testing.T) {
 	for _, test := range testTable {
 		// run tests
 		t.Run(test.Name, func(t *testing.T) {
-			os.Setenv("MAX_APPROX_NODES", string(test.MaxNodes))
-			defer os.Unsetenv("MAX_APPROX_NODES")
+			cfg := config.Default()
+			cfg.StartingEndpoint = test.StartingEndpoint
+			cfg.MaxApproxNodes = int(test.MaxNodes)
 			Run(
-				test.StartingEndpoint,
+				cfg,
 				isValidCrawlLink,
 				test.ConnectToDB,
 				addEdge,
@@ -173,12 +181,141 @@ func TestRun(t *testing.T) {
 	}
 }
 
+// config for crawling up to maxNodes with one thread and no delay
+func crawlConfig(maxNodes int) config.Config {
+	cfg := config.Default()
+	cfg.MaxApproxNodes = maxNodes
+	cfg.Parallelism = 1
+	cfg.MsDelay = 0
+	return cfg
+}
+
+func TestCrawlMaxDepth(t *testing.T) {
+	// "/n" links to "/n+1"
+	mutex := sync.Mutex{}
+	fetched := []string{}
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mutex.Unlock()
+		n := 0
+		fmt.Sscanf(r.URL.Path, "/%d", &n)
+		fmt.Fprintf(w, `<html><body><a href="/%d">next</a></body></html>`, n+1)
+	}))
+	defer site.Close()
+	edges := []string{}
+	addEdges := func(ctx context.Context, currNode string, neighborNodes []string) ([]string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		added := []string{}
+		for _, n := range neighborNodes {
+			edges = append(edges, strings.TrimPrefix(currNode, site.URL)+"->"+n)
+			added = append(added, site.URL+n)
+		}
+		return added, nil
+	}
+	// other tests expect a shallow max depth
+	defer func(depth int32) { maxDepth = asyncInt(depth) }(maxDepth.get())
+	cfg := crawlConfig(-1)
+	cfg.MaxDepth = 1
+	isValidCrawlLink := func(string) bool { return true }
+	filterPage := func(e *colly.HTMLElement) (*colly.HTMLElement, error) { return e, nil }
+	Crawl(cfg, []string{site.URL + "/0"}, isValidCrawlLink, addEdges, filterPage)
+	sort.Strings(fetched)
+	sort.Strings(edges)
+	// links of the page one link away are edges, but are not crawled
+	assert.Equal(t, []string{"/0", "/1"}, fetched)
+	assert.Equal(t, []string{"/0->/1", "/1->/2"}, edges)
+}
+
+func TestCrawlContinuations(t *testing.T) {
+	// "/0" continues on "/0?page=2", both link to the next node
+	mutex := sync.Mutex{}
+	fetched := []string{}
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		fetched = append(fetched, r.URL.RequestURI())
+		mutex.Unlock()
+		switch r.URL.RequestURI() {
+		case "/0":
+			fmt.Fprint(w, `<html><body><a href="/1">1</a><a href="/0?page=2">more</a></body></html>`)
+		case "/0?page=2":
+			fmt.Fprint(w, `<html><body><a href="/2">2</a></body></html>`)
+		default:
+			fmt.Fprint(w, `<html><body><a href="/3">3</a></body></html>`)
+		}
+	}))
+	defer site.Close()
+	SetNodeKey(func(link string) string {
+		return strings.Split(strings.TrimPrefix(link, site.URL), "?")[0]
+	})
+	defer SetNodeKey(func(link string) string { return link })
+	addEdges := func(ctx context.Context, currNode string, neighborNodes []string) ([]string, error) {
+		added := []string{}
+		for _, n := range neighborNodes {
+			added = append(added, site.URL+n)
+		}
+		return added, nil
+	}
+	defer func(depth int32) { maxDepth = asyncInt(depth) }(maxDepth.get())
+	cfg := crawlConfig(-1)
+	cfg.MaxDepth = 1
+	isValidCrawlLink := func(string) bool { return true }
+	filterPage := func(e *colly.HTMLElement) (*colly.HTMLElement, error) { return e, nil }
+	before := totalNodesAdded.get()
+	Crawl(cfg, []string{site.URL + "/0"}, isValidCrawlLink, addEdges, filterPage)
+	sort.Strings(fetched)
+	// links of further pages are crawled from the depth of the first page
+	assert.Equal(t, []string{"/0", "/0?page=2", "/1", "/2"}, fetched)
+	// "/1", "/2" and "/3" from both, further pages are not new nodes
+	assert.Equal(t, int32(4), totalNodesAdded.get()-before)
+}
+
+func TestHandlePageNodeCount(t *testing.T) {
+	u, _ := url.Parse("http://localhost/a")
+	r := &colly.Response{Request: &colly.Request{URL: u, Depth: 1}}
+	links := map[string][]string{"": []string{"/b", "/c"}}
+	returnAll := func(ctx context.Context, currNode string, neighborNodes []string) ([]string, error) {
+		return neighborNodes, nil
+	}
+	nodesAdded, _, newNodes := handlePage(r, u.String(), links, returnAll)
+	assert.Equal(t, []string{"/b", "/c"}, nodesAdded)
+	assert.Equal(t, 2, newNodes)
+	// nodes to crawl on which are not yet in the graph, e.g. reciprocal edges
+	countNone := func(ctx context.Context, currNode string, neighborNodes []string) ([]string, error) {
+		util.CountNodes(ctx, 0)
+		return neighborNodes, nil
+	}
+	nodesAdded, _, newNodes = handlePage(r, u.String(), links, countNone)
+	assert.Equal(t, []string{"/b", "/c"}, nodesAdded)
+	assert.Equal(t, 0, newNodes)
+}
+
+func TestHandlePageEdgesSubmitted(t *testing.T) {
+	defer func() { report = newReportState() }()
+	defer func() { relations = []Relation{} }()
+	report = newReportState()
+	assert.Nil(t, SetRelations(testRelations, "synonym,antonym"))
+	u, _ := url.Parse("http://localhost/a")
+	r := &colly.Response{Request: &colly.Request{URL: u, Depth: 1}}
+	links := map[string][]string{"synonym": []string{"/b", "/c"}, "antonym": []string{"/d"}}
+	failAntonyms := func(ctx context.Context, currNode string, neighborNodes []string) ([]string, error) {
+		if util.Relation(ctx) == "antonym" {
+			return nil, errors.New("graph is down")
+		}
+		return neighborNodes, nil
+	}
+	handlePage(r, u.String(), links, failAntonyms)
+	// only synonyms were accepted by the db
+	assert.Equal(t, 2, report.edgesSubmitted)
+}
+
 func TestCrawl(t *testing.T) {
 	isValidCrawlLink := func(url string) bool {
 		return strings.HasPrefix(url, "/wiki/") && !strings.Contains(url, ":")
 	}
 	nodesAdded := []string{}
-	addEdges := func(currNode string, neighborNodes []string) ([]string, error) {
+	addEdges := func(ctx context.Context, currNode string, neighborNodes []string) ([]string, error) {
 		nodesAdded = append(nodesAdded, neighborNodes...)
 		return neighborNodes, nil
 	}
@@ -190,12 +327,12 @@ func TestCrawl(t *testing.T) {
 	// mute warnings
 	originLogWarn := logWarn
 	defer func() { logWarn = originLogWarn }()
-	logWarn = func(format string, args ...interface{}) {}
+	logWarn = func(ctx context.Context, format string, args ...interface{}) {}
 
 	originLogMsg := logMsg
 	defer func() { logFatal = originLogMsg }()
 	logs := []string{}
-	logMsg = func(format string, args ...interface{}) {
+	logMsg = func(ctx context.Context, format string, args ...interface{}) {
 		if len(args) > 0 {
 			logs = append(logs, fmt.Sprintf(format, args))
 		} else {
@@ -205,7 +342,7 @@ func TestCrawl(t *testing.T) {
 
 	// keep errors in array
 	errors := []string{}
-	logErr = func(format string, args ...interface{}) {
+	logErr = func(ctx context.Context, format string, args ...interface{}) {
 		if len(args) > 0 {
 			errors = append(errors, fmt.Sprintf(format, args))
 		} else {
@@ -216,7 +353,7 @@ func TestCrawl(t *testing.T) {
 	t.Run("works with isValidCrawlLink", func(t *testing.T) {
 		nodesAdded = []string{}
 		// function doing setup of tests
-		Crawl("https://en.wikipedia.org/wiki/String_cheese", 2, 1, 0, isValidCrawlLink, addEdges, FilterPage)
+		Crawl(crawlConfig(2), []string{"https://en.wikipedia.org/wiki/String_cheese"}, isValidCrawlLink, addEdges, FilterPage)
 		t.Run("only filters on links starting with regex", func(t *testing.T) {
 			errors = []string{}
 			for _, url := range nodesAdded {
@@ -240,12 +377,10 @@ func TestCrawl(t *testing.T) {
 		logs = []string{}
 		errors = []string{}
 		Crawl(
-			endpoint,
-			100,
-			1,
-			0,
+			crawlConfig(100),
+			[]string{endpoint},
 			isValidCrawlLink,
-			func(currNode string, neighborNodes []string) ([]string, error) {
+			func(ctx context.Context, currNode string, neighborNodes []string) ([]string, error) {
 				temp := []string{}
 				for _, v := range neighborNodes {
 					temp = append(temp, "https://en.wikipedia.org"+v)
@@ -268,12 +403,10 @@ func TestCrawl(t *testing.T) {
 		logs = []string{}
 		errors = []string{}
 		Crawl(
-			endpoint,
-			1000,
-			1,
-			0,
+			crawlConfig(1000),
+			[]string{endpoint},
 			isValidCrawlLink,
-			func(currNode string, neighborNodes []string) ([]string, error) {
+			func(ctx context.Context, currNode string, neighborNodes []string) ([]string, error) {
 				temp := []string{}
 				for _, v := range neighborNodes {
 					temp = append(temp, "https://en.wikipedia.org"+v)
@@ -296,12 +429,10 @@ func TestCrawl(t *testing.T) {
 		logs = []string{}
 		errors = []string{}
 		Crawl(
-			endpoint+"/thisisabadendpoint",
-			1000,
-			1,
-			0,
+			crawlConfig(1000),
+			[]string{endpoint + "/thisisabadendpoint"},
 			isValidCrawlLink,
-			func(currNode string, neighborNodes []string) ([]string, error) {
+			func(ctx context.Context, currNode string, neighborNodes []string) ([]string, error) {
 				temp := []string{}
 				for _, v := range neighborNodes {
 					temp = append(temp, "https://skldlfjlskjdflkjsdf.org"+v)
@@ -0,0 +1,159 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/gocolly/colly"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	rejectedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "golang",
+			Name:      "responses_rejected",
+			Help:      "Number of pages rejected by reason (content_type, body_size, redirects, timeout)",
+		}, []string{"site", "reason"})
+
+	// reasons which were already logged, each is only logged once
+	loggedRejections = sync.Map{}
+)
+
+// page which was not parsed because it failed a guard
+type rejection struct {
+	reason string
+	detail string
+}
+
+func (r *rejection) Error() string {
+	return fmt.Sprintf("rejected (%s): %s", r.reason, r.detail)
+}
+
+// reason page was rejected, or "" if err is not a rejection
+func rejectionReason(err error) string {
+	var r *rejection
+	if errors.As(err, &r) {
+		return r.reason
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return "timeout"
+	}
+	return ""
+}
+
+// counts rejected page, logging the first rejection for each reason
+func countRejection(ctx context.Context, reason string, url string, err error) {
+	rejectedCounter.WithLabelValues(siteLabel(), reason).Inc()
+	if _, logged := loggedRejections.LoadOrStore(reason, true); !logged {
+		logWarn(ctx, "%s: %v. further '%s' rejections are only counted in metrics", url, err, reason)
+	}
+}
+
+// rejects responses with disallowed content types or too large bodies
+// before they are read
+type guardTransport struct {
+	next         http.RoundTripper
+	contentTypes []string
+	maxBodyBytes int64
+}
+
+func (g *guardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := g.next.RoundTrip(req)
+	// redirects are followed, not parsed
+	if err != nil || (res.StatusCode >= 300 && res.StatusCode < 400) {
+		return res, err
+	}
+	if ct := res.Header.Get("Content-Type"); !g.allowed(ct) {
+		res.Body.Close()
+		return nil, &rejection{"content_type", fmt.Sprintf("content type '%s' is not allowed", ct)}
+	}
+	if res.ContentLength > g.maxBodyBytes {
+		res.Body.Close()
+		return nil, &rejection{"body_size", fmt.Sprintf("body of %d bytes is larger than %d", res.ContentLength, g.maxBodyBytes)}
+	}
+	// content length may be missing
+	res.Body = &limitedBody{ReadCloser: res.Body, remaining: g.maxBodyBytes, max: g.maxBodyBytes}
+	return res, nil
+}
+
+// true if content type matches one of the allowed types. responses without
+// a content type are allowed, colly only parses those which look like HTML
+func (g *guardTransport) allowed(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range g.contentTypes {
+		if strings.EqualFold(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// body which fails once more than max bytes are read
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	max       int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if b.remaining < 0 {
+		return n, &rejection{"body_size", fmt.Sprintf("body is larger than %d bytes", b.max)}
+	}
+	return n, err
+}
+
+// guards next with ALLOWED_CONTENT_TYPES and MAX_BODY_BYTES of cfg
+func newGuardTransport(next http.RoundTripper, cfg config.Config) *guardTransport {
+	g := &guardTransport{
+		next:         next,
+		maxBodyBytes: int64(cfg.MaxBodyBytes),
+	}
+	for _, t := range strings.Split(cfg.AllowedContentTypes, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			g.contentTypes = append(g.contentTypes, t)
+		}
+	}
+	// responses of the link API are parsed instead of pages
+	if linkAPI != nil {
+		g.contentTypes = append(g.contentTypes, linkAPI.ContentType)
+	}
+	return g
+}
+
+// fails requests redirected more often than MAX_REDIRECTS of cfg
+func redirectLimit(cfg config.Config) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) > cfg.MaxRedirects {
+			return &rejection{"redirects", fmt.Sprintf("more than %d redirects", cfg.MaxRedirects)}
+		}
+		return nil
+	}
+}
+
+// wraps transport with content type and body size guards and the limits
+// of requests to each host and sets redirect limits from cfg
+func configureGuards(c *colly.Collector, transport http.RoundTripper, cfg config.Config) {
+	c.WithTransport(newLimitTransport(newGuardTransport(transport, cfg), cfg))
+	// enforced by the guard, which fails instead of truncating
+	c.MaxBodySize = 0
+	// enforced by the limits once a request is sent
+	c.SetRequestTimeout(0)
+	c.RedirectHandler = redirectLimit(cfg)
+}
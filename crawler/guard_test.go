@@ -0,0 +1,99 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/gocolly/colly"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGuards(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html><body>ok</body></html>"))
+		case "/paper.pdf":
+			w.Header().Set("Content-Type", "application/pdf")
+			w.Write([]byte("%PDF-1.4"))
+		case "/big":
+			w.Header().Set("Content-Type", "text/html")
+			w.Header().Set("Content-Length", "2000")
+			w.Write([]byte(strings.Repeat("a", 2000)))
+		case "/streamed":
+			// no content length
+			w.Header().Set("Content-Type", "text/html")
+			for i := 0; i < 20; i++ {
+				w.Write([]byte(strings.Repeat("a", 100)))
+				w.(http.Flusher).Flush()
+			}
+		case "/slow":
+			time.Sleep(1500 * time.Millisecond)
+			w.Write([]byte("<html></html>"))
+		default:
+			// /redirect/n redirects n more times
+			n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/redirect/"))
+			if n == 0 {
+				http.Redirect(w, r, "/page", http.StatusFound)
+			} else {
+				http.Redirect(w, r, fmt.Sprintf("/redirect/%d", n-1), http.StatusFound)
+			}
+		}
+	}))
+	defer server.Close()
+
+	cfg := config.Default()
+	cfg.MaxBodyBytes = 1000
+	cfg.RequestTimeoutSec = 1
+	cfg.MaxRedirects = 3
+	type Test struct {
+		Path           string
+		ExpectedReason string
+	}
+	testTable := []Test{
+		Test{"/page", ""},
+		Test{"/paper.pdf", "content_type"},
+		Test{"/big", "body_size"},
+		Test{"/streamed", "body_size"},
+		Test{"/slow", "timeout"},
+		Test{"/redirect/2", ""},
+		Test{"/redirect/3", "redirects"},
+	}
+	for _, test := range testTable {
+		t.Run(test.Path, func(t *testing.T) {
+			c := colly.NewCollector()
+			assert.Nil(t, configureRequests(c, cfg))
+			parsed := false
+			c.OnHTML("html", func(e *colly.HTMLElement) { parsed = true })
+			reason := ""
+			c.OnError(func(r *colly.Response, err error) { reason = rejectionReason(err) })
+			c.Visit(server.URL + test.Path)
+			assert.Equal(t, test.ExpectedReason, reason)
+			assert.Equal(t, test.ExpectedReason == "", parsed)
+		})
+	}
+}
+
+func TestCountRejection(t *testing.T) {
+	originalLogWarn := logWarn
+	defer func() { logWarn = originalLogWarn }()
+	logs := []string{}
+	logWarn = func(ctx context.Context, format string, args ...interface{}) {
+		logs = append(logs, fmt.Sprintf(format, args...))
+	}
+	before := testutil.ToFloat64(rejectedCounter.WithLabelValues(siteLabel(), "test"))
+	for i := 0; i < 3; i++ {
+		countRejection(context.Background(), "test", "/wiki/a", &rejection{"test", "too big"})
+	}
+	assert.Equal(t, before+3, testutil.ToFloat64(rejectedCounter.WithLabelValues(siteLabel(), "test")))
+	// logged once per reason
+	assert.Equal(t, []string{"/wiki/a: rejected (test): too big. further 'test' rejections are only counted in metrics"}, logs)
+}
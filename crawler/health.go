@@ -0,0 +1,160 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// checks that a dependency is available
+type ReadinessCheckFunction func() error
+
+// result of the last run of a readiness check
+type checkResult struct {
+	Ok        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+type readiness struct {
+	sync.Mutex
+	checks  map[string]ReadinessCheckFunction
+	results map[string]checkResult
+}
+
+var (
+	ready = &readiness{
+		checks:  make(map[string]ReadinessCheckFunction),
+		results: make(map[string]checkResult),
+	}
+	lastProgress = asyncTime{t: time.Now()}
+	// crawler is unhealthy if it made no progress within this window
+	progressWindow = time.Duration(300 * time.Second)
+	// readiness checks are re-run this often, results older than two
+	// intervals are stale
+	readinessInterval = time.Duration(30 * time.Second)
+	// checks taking longer than this fail
+	readinessTimeout = time.Duration(10 * time.Second)
+)
+
+// time safe for concurrent access
+type asyncTime struct {
+	sync.RWMutex
+	t time.Time
+}
+
+func (a *asyncTime) set(t time.Time) {
+	a.Lock()
+	defer a.Unlock()
+	a.t = t
+}
+
+func (a *asyncTime) get() time.Time {
+	a.RLock()
+	defer a.RUnlock()
+	return a.t
+}
+
+// records that the crawl loop is still making progress
+func markProgress() {
+	lastProgress.set(time.Now())
+}
+
+// registers a check which must pass for the crawler to report ready
+func AddReadinessCheck(name string, check ReadinessCheckFunction) {
+	ready.Lock()
+	defer ready.Unlock()
+	ready.checks[name] = check
+}
+
+// runs all readiness checks once and at the same time, storing their
+// results. checks which hang fail after readinessTimeout.
+func runReadinessChecks() {
+	ready.Lock()
+	checks := make(map[string]ReadinessCheckFunction)
+	for name, check := range ready.checks {
+		checks[name] = check
+	}
+	ready.Unlock()
+	wg := sync.WaitGroup{}
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check ReadinessCheckFunction) {
+			defer wg.Done()
+			res := checkResult{Ok: true, CheckedAt: time.Now()}
+			if err := runWithTimeout(check, readinessTimeout); err != nil {
+				res.Ok = false
+				res.Error = err.Error()
+				logWarn(context.Background(), "readiness check '%s' failed: %v", name, err)
+			}
+			ready.Lock()
+			ready.results[name] = res
+			ready.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+}
+
+// error of check, or an error if it does not return within timeout. a hung
+// check is left running
+func runWithTimeout(check ReadinessCheckFunction, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() { done <- check() }()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+}
+
+// re-runs readiness checks every interval
+func watchReadiness(interval time.Duration) {
+	for {
+		runReadinessChecks()
+		time.Sleep(interval)
+	}
+}
+
+// process is alive and crawl loop has progressed within progressWindow
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	since := time.Since(lastProgress.get())
+	code := http.StatusOK
+	if since > progressWindow {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"ok":           code == http.StatusOK,
+		"lastProgress": lastProgress.get(),
+	})
+}
+
+// all dependencies passed their last readiness check
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	ready.Lock()
+	results := make(map[string]checkResult)
+	code := http.StatusOK
+	for name := range ready.checks {
+		res, ok := ready.results[name]
+		if !ok {
+			res = checkResult{Error: "not checked yet"}
+		} else if res.Ok && time.Since(res.CheckedAt) > 2*readinessInterval {
+			// checks are hanging or stopped, the result cannot be trusted
+			res.Ok = false
+			res.Error = fmt.Sprintf("last checked %v ago", time.Since(res.CheckedAt).Round(time.Second))
+		}
+		if !res.Ok {
+			code = http.StatusServiceUnavailable
+		}
+		results[name] = res
+	}
+	ready.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,110 @@
+package crawler
+
+import (
+	"errors"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/db"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHealthz(t *testing.T) {
+	progressWindow = time.Minute
+	t.Run("healthy when progress was made recently", func(t *testing.T) {
+		markProgress()
+		w := httptest.NewRecorder()
+		healthzHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+	t.Run("unhealthy when crawl loop is stuck", func(t *testing.T) {
+		lastProgress.set(time.Now().Add(-2 * time.Minute))
+		defer markProgress()
+		w := httptest.NewRecorder()
+		healthzHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
+
+func TestReadyz(t *testing.T) {
+	dbErr := errors.New("connection refused")
+	AddReadinessCheck("graph", func() error { return dbErr })
+	defer func() {
+		delete(ready.checks, "graph")
+		delete(ready.results, "graph")
+	}()
+	t.Run("not ready before checks have run", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+	t.Run("not ready when a check fails", func(t *testing.T) {
+		runReadinessChecks()
+		w := httptest.NewRecorder()
+		readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+		assert.Contains(t, w.Body.String(), "connection refused")
+	})
+	t.Run("ready once dependency comes back", func(t *testing.T) {
+		dbErr = nil
+		runReadinessChecks()
+		w := httptest.NewRecorder()
+		readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+}
+
+func TestReadyzUnavailableDependencies(t *testing.T) {
+	defer func(timeout time.Duration) { readinessTimeout = timeout }(readinessTimeout)
+	readinessTimeout = 100 * time.Millisecond
+	graph := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer graph.Close()
+	hang := make(chan struct{})
+	kv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	defer kv.Close()
+	defer close(hang)
+	db.Configure(config.Config{GraphDBEndpoint: graph.URL, TwoWayKVEndpoint: kv.URL})
+	defer db.Configure(config.Config{})
+	AddReadinessCheck("graph", db.ConnectToDB)
+	AddReadinessCheck("twowaykv", db.ConnectToKV)
+	defer func() {
+		for _, name := range []string{"graph", "twowaykv"} {
+			delete(ready.checks, name)
+			delete(ready.results, name)
+		}
+	}()
+
+	runReadinessChecks()
+	w := httptest.NewRecorder()
+	readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "responded with 500")
+	assert.Contains(t, w.Body.String(), "timed out after 100ms")
+}
+
+func TestReadyzStale(t *testing.T) {
+	defer func(interval time.Duration) { readinessInterval = interval }(readinessInterval)
+	readinessInterval = time.Minute
+	AddReadinessCheck("graph", func() error { return nil })
+	defer func() {
+		delete(ready.checks, "graph")
+		delete(ready.results, "graph")
+	}()
+	runReadinessChecks()
+	w := httptest.NewRecorder()
+	readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// checks stopped reporting
+	ready.results["graph"] = checkResult{Ok: true, CheckedAt: time.Now().Add(-3 * time.Minute)}
+	w = httptest.NewRecorder()
+	readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Contains(t, w.Body.String(), "last checked 3m0s ago")
+}
@@ -0,0 +1,38 @@
+package crawler
+
+import (
+	"github.com/gocolly/colly"
+)
+
+// links are read from this API instead of page HTML if it is not nil
+var linkAPI *LinkAPI
+
+// reads links of pages from api instead of their HTML, or from HTML again
+// if api is nil
+func SetLinkAPI(api *LinkAPI) {
+	linkAPI = api
+}
+
+// URL page is fetched from
+func requestURL(page string) string {
+	if linkAPI == nil {
+		return page
+	}
+	return linkAPI.RequestURL(page)
+}
+
+// URL of the page r is an API response for and its valid links
+func readLinks(r *colly.Response, isValidCrawlLink IsValidCrawlLinkFunction) (string, map[string][]string, error) {
+	ctx := pageContext(r.Request)
+	logMsg(ctx, "parsing %s", r.Request.URL.String())
+	pagesVisited.incr(1)
+	_, span := tracer().Start(ctx, "extract links")
+	url, links, err := linkAPI.ParseLinks(ctx, r.Request.URL.String(), r.Body)
+	endSpan(span, err)
+	if err != nil {
+		return url, nil, err
+	}
+	valid := filterLinks(ctx, links, isValidCrawlLink)
+	logMsg(ctx, "found %v neighbors for %v", len(valid), url)
+	return url, map[string][]string{"": valid}, nil
+}
@@ -0,0 +1,73 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/gocolly/colly"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestLinkAPI(t *testing.T) {
+	// pages and their links, served as JSON on /api?page=
+	pages := map[string][]string{
+		"/a": []string{"/b", "/c", "/skipped"},
+		"/b": []string{"/a"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		links, ok := pages[r.URL.Query().Get("page")]
+		if !ok {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"error": "missing"}`))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(links)
+	}))
+	defer server.Close()
+
+	assert.Equal(t, "https://en.wikipedia.org/wiki/Cheese", requestURL("https://en.wikipedia.org/wiki/Cheese"))
+	SetLinkAPI(&LinkAPI{
+		ContentType: "application/json",
+		RequestURL: func(page string) string {
+			return server.URL + "/api?page=" + strings.TrimPrefix(page, server.URL)
+		},
+		ParseLinks: func(ctx context.Context, requestURL string, body []byte) (string, []string, error) {
+			links := []string{}
+			if err := json.Unmarshal(body, &links); err != nil {
+				return "", nil, errors.New("no links")
+			}
+			return server.URL + strings.Split(requestURL, "page=")[1], links, nil
+		},
+	})
+	defer SetLinkAPI(nil)
+	assert.Equal(t, server.URL+"/api?page=/a", requestURL(server.URL+"/a"))
+
+	cfg := config.Default()
+	cfg.MsDelay = 0
+	c := newCollector(cfg)
+	c.CacheDir = ""
+	mutex := sync.Mutex{}
+	visited := []string{}
+	isValidCrawlLink := func(l string) bool { return l != "/skipped" }
+	filterPage := func(e *colly.HTMLElement) (*colly.HTMLElement, error) { return e, nil }
+	onPage(c, isValidCrawlLink, filterPage, func(r *colly.Response, url string, links map[string][]string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		visited = append(visited, strings.TrimPrefix(url, server.URL)+" "+strings.Join(links[""], ","))
+	})
+	// JSON is not rejected by the content type guard
+	for _, page := range []string{"/a", "/b", "/missing"} {
+		assert.Nil(t, c.Visit(requestURL(server.URL+page)))
+	}
+	c.Wait()
+	sort.Strings(visited)
+	assert.Equal(t, []string{"/a /b,/c", "/b /a"}, visited)
+}
@@ -1,68 +1,171 @@
 package crawler
 
 import (
+	"context"
 	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/util"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"net/http"
-	"os"
+	"strconv"
 	"sync/atomic"
+	"time"
 )
 
 // constants
 var (
-	nodesVisitedCounter = prometheus.NewCounter(
+	nodesVisitedCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "golang",
 			Name:      "nodes_visited",
 			Help:      "Number of nodes scraped and succesfully added to the graph",
-		})
+		}, []string{"site"})
 
-	nodesAddedCounter = prometheus.NewCounter(
+	nodesAddedCounter = prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: "golang",
 			Name:      "nodes_added",
 			Help:      "Number of nodes succesfully visited",
-		})
+		}, []string{"site"})
 
-	maxDepthCounter = prometheus.NewCounter(
-		prometheus.CounterOpts{
+	maxDepthGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
 			Namespace: "golang",
 			Name:      "max_depth",
 			Help:      "Max depth in the tree visited nodes",
-		})
+		}, []string{"site"})
+
+	frontierGauge = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "golang",
+			Name:      "frontier_size",
+			Help:      "Number of links queued but not yet requested",
+		}, []string{"site"})
+
+	linksRejectedCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "golang",
+			Name:      "links_rejected",
+			Help:      "Number of links rejected by the site's link validator",
+		}, []string{"site"})
+
+	errorsCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "golang",
+			Name:      "errors",
+			Help:      "Number of errors by crawl stage (fetch, filter, kv, graph) and HTTP status",
+		}, []string{"site", "stage", "status"})
+
+	fetchLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "golang",
+			Name:      "page_fetch_seconds",
+			Help:      "Time from requesting a page to receiving its response",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"site"})
+
+	filterLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "golang",
+			Name:      "filter_page_seconds",
+			Help:      "Time spent in the site's FilterPage function",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}, []string{"site"})
+
+	dbLatency = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "golang",
+			Name:      "db_request_seconds",
+			Help:      "Latency of requests to the twowaykv and graph databases",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"site", "target"})
+
 	totalNodesAdded = asyncInt(0)
 	maxDepth        = asyncInt(0)
 )
 
-// resgisters and serves metrics to HTTP
-func ServeMetrics() {
+// resgisters and serves metrics, status and health checks to HTTP
+func ServeMetrics(cfg config.Config) {
 	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/status", statusHandler)
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("/loglevel", util.LogLevelHandler)
+	http.HandleFunc("/inbox", inboxHandler)
 	// register metrics
 	prometheus.MustRegister(nodesVisitedCounter)
 	prometheus.MustRegister(nodesAddedCounter)
-	prometheus.MustRegister(maxDepthCounter)
-	if os.Getenv("METRICS_PORT") == "" {
-		os.Setenv("METRICS_PORT", os.Getenv("PORT"))
+	prometheus.MustRegister(maxDepthGauge)
+	prometheus.MustRegister(frontierGauge)
+	prometheus.MustRegister(linksRejectedCounter)
+	prometheus.MustRegister(errorsCounter)
+	prometheus.MustRegister(fetchLatency)
+	prometheus.MustRegister(filterLatency)
+	prometheus.MustRegister(dbLatency)
+	prometheus.MustRegister(proxyRequestsCounter)
+	prometheus.MustRegister(shardLinksCounter)
+	prometheus.MustRegister(shardInboxCounter)
+	prometheus.MustRegister(rejectedCounter)
+	prometheus.MustRegister(linksFilteredCounter)
+	prometheus.MustRegister(recrawlPagesCounter)
+	prometheus.MustRegister(recrawlEdgesCounter)
+	// export zero values for this site before first update
+	site := siteLabel()
+	nodesVisitedCounter.WithLabelValues(site)
+	nodesAddedCounter.WithLabelValues(site)
+	maxDepthGauge.WithLabelValues(site)
+	frontierGauge.WithLabelValues(site)
+	linksRejectedCounter.WithLabelValues(site)
+	progressWindow = time.Duration(cfg.HealthProgressWindowSec) * time.Second
+	// keep checking dependencies in the background
+	readinessInterval = time.Duration(cfg.ReadinessIntervalSec) * time.Second
+	if readinessInterval < readinessTimeout {
+		readinessTimeout = readinessInterval
 	}
+	go watchReadiness(readinessInterval)
 	// serve http
 	go func() {
-		logErr("%v", http.ListenAndServe(fmt.Sprintf(":%s", os.Getenv("METRICS_PORT")), nil))
+		logErr(context.Background(), "%v", http.ListenAndServe(fmt.Sprintf(":%s", cfg.MetricsPort), nil))
 	}()
 }
 
 // updates prometheus and internal metrics
 func UpdateMetrics(numberOfNodesAdded int, currDepth int) {
+	site := siteLabel()
 	// increment number of nodes crawled
-	nodesVisitedCounter.Inc()
+	nodesVisitedCounter.WithLabelValues(site).Inc()
 	// increment number of nodes
 	totalNodesAdded.incr(int32(numberOfNodesAdded))
-	nodesAddedCounter.Add(float64(numberOfNodesAdded))
+	nodesAddedCounter.WithLabelValues(site).Add(float64(numberOfNodesAdded))
 	// set max depth if greater
+	if maxDepth.max(int32(currDepth)) {
+		maxDepthGauge.WithLabelValues(site).Set(float64(currDepth))
+	}
+}
 
-	if int32(currDepth) > maxDepth.get() {
-		maxDepth.incr(1)
-		maxDepthCounter.Inc()
+// counts an error at the given stage, status is 0 if there was no HTTP response
+func countError(stage string, status int) {
+	recordError(stage)
+	reportError(stage)
+	errorsCounter.WithLabelValues(siteLabel(), stage, strconv.Itoa(status)).Inc()
+}
+
+// counts a link rejected by isValidCrawlLink
+func countRejectedLink() {
+	linksRejectedCounter.WithLabelValues(siteLabel()).Inc()
+}
+
+// moves the frontier size by n
+func updateFrontier(n int32) {
+	frontierGauge.WithLabelValues(siteLabel()).Set(float64(frontierSize.incr(n)))
+}
+
+// records latency and errors of a request to one of the databases ("kv" or "graph")
+func ObserveDBRequest(target string, duration time.Duration, status int, err error) {
+	dbLatency.WithLabelValues(siteLabel(), target).Observe(duration.Seconds())
+	if err != nil {
+		countError(target, status)
 	}
 }
 
@@ -75,3 +178,16 @@ func (c *asyncInt) incr(n int32) int32 {
 func (c *asyncInt) get() int32 {
 	return atomic.LoadInt32((*int32)(c))
 }
+
+// sets async int to n if n is greater, returns true if it was set
+func (c *asyncInt) max(n int32) bool {
+	for {
+		curr := c.get()
+		if n <= curr {
+			return false
+		}
+		if atomic.CompareAndSwapInt32((*int32)(c), curr, n) {
+			return true
+		}
+	}
+}
@@ -1,13 +1,16 @@
 package crawler
 
 import (
+	"errors"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"io/ioutil"
 	"net/http"
-	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestAsyncInt(t *testing.T) {
@@ -23,8 +26,9 @@ func TestAsyncInt(t *testing.T) {
 }
 
 func TestServeServiceMetrics(t *testing.T) {
-	os.Setenv("METRICS_PORT", "8002")
-	ServeMetrics()
+	cfg := config.Default()
+	cfg.MetricsPort = "8002"
+	ServeMetrics(cfg)
 	res, err := http.Get("http://localhost:8002/metrics")
 	require.NoError(t, err)
 	assert.Equal(t, 200, res.StatusCode)
@@ -35,6 +39,8 @@ func TestServeServiceMetrics(t *testing.T) {
 	assert.True(t, strings.Contains(bodyAsString, "golang_nodes_added"))
 	assert.True(t, strings.Contains(bodyAsString, "golang_nodes_visited"))
 	assert.True(t, strings.Contains(bodyAsString, "golang_max_depth"))
+	assert.True(t, strings.Contains(bodyAsString, "golang_frontier_size"))
+	assert.True(t, strings.Contains(bodyAsString, "golang_links_rejected"))
 }
 
 func TestUpdateMetrics(t *testing.T) {
@@ -48,3 +54,34 @@ func TestUpdateMetrics(t *testing.T) {
 		assert.Equal(t, int32(1), maxDepth.get())
 	})
 }
+
+func TestAsyncIntMax(t *testing.T) {
+	n := asyncInt(5)
+	assert.False(t, n.max(3))
+	assert.Equal(t, int32(5), n.get())
+	assert.True(t, n.max(9))
+	assert.Equal(t, int32(9), n.get())
+}
+
+func TestMaxDepthGauge(t *testing.T) {
+	UpdateMetrics(0, 7)
+	UpdateMetrics(0, 3)
+	assert.Equal(t, float64(7), testutil.ToFloat64(maxDepthGauge.WithLabelValues(siteLabel())))
+}
+
+func TestObserveDBRequest(t *testing.T) {
+	t.Run("counts errors by target and status", func(t *testing.T) {
+		ObserveDBRequest("graph", time.Millisecond, 500, errors.New("Not Found"))
+		assert.Equal(t, float64(1), testutil.ToFloat64(errorsCounter.WithLabelValues(siteLabel(), "graph", "500")))
+	})
+	t.Run("does not count succesful requests as errors", func(t *testing.T) {
+		ObserveDBRequest("kv", time.Millisecond, 200, nil)
+		assert.Equal(t, float64(0), testutil.ToFloat64(errorsCounter.WithLabelValues(siteLabel(), "kv", "200")))
+	})
+}
+
+func TestCountRejectedLink(t *testing.T) {
+	before := testutil.ToFloat64(linksRejectedCounter.WithLabelValues(siteLabel()))
+	countRejectedLink()
+	assert.Equal(t, before+1, testutil.ToFloat64(linksRejectedCounter.WithLabelValues(siteLabel())))
+}
@@ -0,0 +1,123 @@
+package crawler
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/gocolly/colly"
+	"github.com/prometheus/client_golang/prometheus"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+var proxyRequestsCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "golang",
+		Name:      "proxy_requests",
+		Help:      "Number of page requests by proxy and result (success, failure)",
+	}, []string{"site", "proxy", "result"})
+
+// sends each request through the next proxy, counting results per proxy
+type proxyRotator struct {
+	proxies    []*url.URL
+	transports []*http.Transport
+	next       uint32
+}
+
+// round-robin rotator over proxies, which must be http, https or socks5 URLs
+func newProxyRotator(proxies []string) (*proxyRotator, error) {
+	r := &proxyRotator{}
+	for _, p := range proxies {
+		if err := config.ValidateProxy(p); err != nil {
+			return nil, err
+		}
+		u, _ := url.Parse(p)
+		r.proxies = append(r.proxies, u)
+		r.transports = append(r.transports, &http.Transport{Proxy: http.ProxyURL(u)})
+	}
+	if len(r.proxies) == 0 {
+		return nil, fmt.Errorf("no proxies given")
+	}
+	return r, nil
+}
+
+func (r *proxyRotator) RoundTrip(req *http.Request) (*http.Response, error) {
+	i := (atomic.AddUint32(&r.next, 1) - 1) % uint32(len(r.proxies))
+	res, err := r.transports[i].RoundTrip(req)
+	result := "success"
+	// 5xx responses are counted as failures since proxies report their own errors that way
+	if err != nil || res.StatusCode >= 500 {
+		result = "failure"
+	}
+	proxyRequestsCounter.WithLabelValues(siteLabel(), proxyLabel(r.proxies[i]), result).Inc()
+	return res, err
+}
+
+// proxy without credentials, safe to export
+func proxyLabel(u *url.URL) string {
+	return u.Scheme + "://" + u.Host
+}
+
+// reads entries from path, one per line. blank lines and lines starting with '#' are skipped
+func readList(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	entries := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		entries = append(entries, line)
+	}
+	return entries, scanner.Err()
+}
+
+// sets user agent, extra headers, proxies and response guards from cfg on collector
+func configureRequests(c *colly.Collector, cfg config.Config) error {
+	c.UserAgent = cfg.UserAgent
+	headers, err := config.ParseHeaders(cfg.RequestHeaders)
+	if err != nil {
+		return err
+	}
+	if len(headers) > 0 {
+		c.OnRequest(func(r *colly.Request) {
+			for name, value := range headers {
+				r.Headers.Set(name, value)
+			}
+		})
+	}
+	transport, err := pageTransport(cfg)
+	if err != nil {
+		return err
+	}
+	configureGuards(c, transport, cfg)
+	return nil
+}
+
+// transport pages are requested with, rotating through the proxies of cfg
+// if there are any
+func pageTransport(cfg config.Config) (http.RoundTripper, error) {
+	proxies := []string{}
+	if cfg.Proxy != "" {
+		proxies = append(proxies, cfg.Proxy)
+	}
+	if cfg.ProxyListFile != "" {
+		list, err := readList(cfg.ProxyListFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read PROXY_LIST_FILE: %v", err)
+		}
+		proxies = append(proxies, list...)
+	}
+	if len(proxies) == 0 {
+		return http.DefaultTransport, nil
+	}
+	return newProxyRotator(proxies)
+}
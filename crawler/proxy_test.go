@@ -0,0 +1,95 @@
+package crawler
+
+import (
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/gocolly/colly"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestProxyRotator(t *testing.T) {
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer good.Close()
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer bad.Close()
+
+	t.Run("rejects unsupported schemes", func(t *testing.T) {
+		_, err := newProxyRotator([]string{"ftp://localhost:21"})
+		assert.Error(t, err)
+		_, err = newProxyRotator([]string{})
+		assert.EqualError(t, err, "no proxies given")
+	})
+	t.Run("rotates round-robin and counts results per proxy", func(t *testing.T) {
+		r, err := newProxyRotator([]string{good.URL, "http://user:pass@" + bad.Listener.Addr().String()})
+		assert.Nil(t, err)
+		client := http.Client{Transport: r}
+		statuses := []int{}
+		for i := 0; i < 4; i++ {
+			res, err := client.Get("http://example.com/wiki/test")
+			assert.Nil(t, err)
+			res.Body.Close()
+			statuses = append(statuses, res.StatusCode)
+		}
+		assert.Equal(t, []int{200, 502, 200, 502}, statuses)
+		assert.Equal(t, 2.0, testutil.ToFloat64(proxyRequestsCounter.WithLabelValues(siteLabel(), good.URL, "success")))
+		// credentials are not exported as labels
+		assert.Equal(t, 2.0, testutil.ToFloat64(proxyRequestsCounter.WithLabelValues(siteLabel(), bad.URL, "failure")))
+	})
+}
+
+func TestReadList(t *testing.T) {
+	f, err := ioutil.TempFile("", "proxies")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("# office proxies\nhttp://localhost:3128\n\n  socks5://localhost:1080  \n")
+	f.Close()
+	proxies, err := readList(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"http://localhost:3128", "socks5://localhost:1080"}, proxies)
+	_, err = readList("/does/not/exist")
+	assert.Error(t, err)
+}
+
+func TestConfigureRequests(t *testing.T) {
+	received := http.Header{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		w.Write([]byte("<html></html>"))
+	}))
+	defer server.Close()
+
+	t.Run("sets user agent and headers", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.RequestHeaders = "From: ops@example.com|Accept-Language: en"
+		c := colly.NewCollector()
+		assert.Nil(t, configureRequests(c, cfg))
+		assert.Nil(t, c.Visit(server.URL))
+		assert.Equal(t, config.DefaultUserAgent, received.Get("User-Agent"))
+		assert.Equal(t, "ops@example.com", received.Get("From"))
+		assert.Equal(t, "en", received.Get("Accept-Language"))
+	})
+	t.Run("fails on unreadable proxy list", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.ProxyListFile = "/does/not/exist"
+		err := configureRequests(colly.NewCollector(), cfg)
+		assert.Error(t, err)
+	})
+	t.Run("fails on bad proxy in list", func(t *testing.T) {
+		f, _ := ioutil.TempFile("", "proxies")
+		defer os.Remove(f.Name())
+		f.WriteString("localhost:3128\n")
+		f.Close()
+		cfg := config.Default()
+		cfg.ProxyListFile = f.Name()
+		assert.Error(t, configureRequests(colly.NewCollector(), cfg))
+	})
+}
@@ -0,0 +1,377 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/util"
+	"github.com/gocolly/colly"
+	"github.com/prometheus/client_golang/prometheus"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	recrawlPagesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "golang",
+			Name:      "recrawl_pages",
+			Help:      "Number of pages recrawled by result (not_modified, unchanged, changed, failed)",
+		}, []string{"site", "result"})
+
+	recrawlEdgesCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "golang",
+			Name:      "recrawl_edges",
+			Help:      "Number of edges added or removed by recrawls",
+		}, []string{"site", "change"})
+
+	// appends to PAGE_STATE_FILE, nil if it is not set
+	pageStates *pageStateWriter
+)
+
+// links and cache headers of a page when it was last crawled
+type PageState struct {
+	URL          string    `json:"url"`
+	CrawledAt    time.Time `json:"crawledAt"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+	// untyped links
+	Links []string `json:"links"`
+	// typed links by relation
+	Relations map[string][]string `json:"relations,omitempty"`
+}
+
+// links of the page with relation, "" for untyped links
+func (s PageState) linksOf(relation string) []string {
+	if relation == "" {
+		return s.Links
+	}
+	return s.Relations[relation]
+}
+
+// appends page states as JSON lines
+type pageStateWriter struct {
+	sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+// starts recording page states to path, if it is set
+func openPageStates(path string) error {
+	if path == "" {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open page state file: %v", err)
+	}
+	pageStates = &pageStateWriter{f: f, enc: json.NewEncoder(f)}
+	return nil
+}
+
+// stops recording page states
+func closePageStates() {
+	if pageStates == nil {
+		return
+	}
+	pageStates.Lock()
+	defer pageStates.Unlock()
+	pageStates.f.Close()
+	pageStates = nil
+}
+
+// records links by relation and cache headers of the page at url
+func recordPageState(r *colly.Response, url string, links map[string][]string) {
+	if pageStates == nil {
+		return
+	}
+	s := PageState{
+		URL:       url,
+		CrawledAt: time.Now().UTC(),
+		Links:     links[""],
+	}
+	for relation, l := range links {
+		if relation == "" {
+			continue
+		}
+		if s.Relations == nil {
+			s.Relations = make(map[string][]string)
+		}
+		s.Relations[relation] = l
+	}
+	if r.Headers != nil {
+		s.ETag = r.Headers.Get("ETag")
+		s.LastModified = r.Headers.Get("Last-Modified")
+	}
+	writePageState(s)
+}
+
+// appends s to the page state file
+func writePageState(s PageState) {
+	if pageStates == nil {
+		return
+	}
+	pageStates.Lock()
+	defer pageStates.Unlock()
+	if err := pageStates.enc.Encode(s); err != nil {
+		logErr(context.Background(), "Could not record page state of %s: %v", s.URL, err)
+	}
+}
+
+// reads page states at path by URL, later entries replace earlier ones
+func loadPageStates(path string) (map[string]PageState, error) {
+	states := make(map[string]PageState)
+	f, err := os.Open(path)
+	if err != nil {
+		return states, fmt.Errorf("could not read page state file: %v", err)
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	for {
+		s := PageState{}
+		if err := dec.Decode(&s); err == io.EOF {
+			return states, nil
+		} else if err != nil {
+			return states, fmt.Errorf("could not parse page state file %s: %v", path, err)
+		}
+		states[s.URL] = s
+	}
+}
+
+// rewrites path with only the latest state of each page
+func compactPageStates(path string) error {
+	states, err := loadPageStates(path)
+	if err != nil {
+		return err
+	}
+	urls := []string{}
+	for url := range states {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, url := range urls {
+		if err := enc.Encode(states[url]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// pages crawled before cutoff, oldest first
+func stalePages(states map[string]PageState, cutoff time.Time) []PageState {
+	stale := []PageState{}
+	for _, s := range states {
+		if s.CrawledAt.Before(cutoff) {
+			stale = append(stale, s)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return stale[i].CrawledAt.Before(stale[j].CrawledAt)
+	})
+	return stale
+}
+
+// links in current which are not in previous and the other way around,
+// compared by node key
+func diffLinks(previous []string, current []string) (added []string, removed []string) {
+	previousKeys := make(map[string]bool)
+	for _, l := range previous {
+		previousKeys[keyOf(l)] = true
+	}
+	currentKeys := make(map[string]bool)
+	for _, l := range current {
+		k := keyOf(l)
+		if !previousKeys[k] && !currentKeys[k] {
+			added = append(added, l)
+		}
+		currentKeys[k] = true
+	}
+	for _, l := range previous {
+		k := keyOf(l)
+		if !currentKeys[k] {
+			removed = append(removed, l)
+			// only removed once
+			currentKeys[k] = true
+		}
+	}
+	return added, removed
+}
+
+// revisits pages in cfg.PageStateFile crawled longer than cfg.RecrawlTTLSec
+// ago, adding edges to new links and removing edges to links which are gone.
+// unchanged pages are skipped using their ETag and Last-Modified headers.
+func Recrawl(
+	cfg config.Config,
+	isValidCrawlLink IsValidCrawlLinkFunction,
+	connectToDB ConnectToDBFunction,
+	addEdgesIfDoNotExist AddEdgeFunction,
+	removeEdges RemoveEdgeFunction,
+	filterPage FilterPageFunction,
+) {
+	ctx := context.Background()
+	if cfg.PageStateFile == "" {
+		logFatal(ctx, "PAGE_STATE_FILE must be set to recrawl")
+	}
+	if err := connectToDB(); err != nil {
+		logFatal(ctx, "Could not connect do db: %v", err)
+	}
+	isValidCrawlLink, err := withLinkRules(cfg, isValidCrawlLink)
+	if err != nil {
+		logFatal(ctx, "Could not load link rules: %v", err)
+	}
+	states, err := loadPageStates(cfg.PageStateFile)
+	if err != nil {
+		logFatal(ctx, "%v", err)
+	}
+	ttl := time.Duration(cfg.RecrawlTTLSec) * time.Second
+	stale := stalePages(states, time.Now().Add(-ttl))
+	logMsg(ctx, "recrawling %d of %d pages crawled more than %s ago", len(stale), len(states), ttl)
+	if err := openPageStates(cfg.PageStateFile); err != nil {
+		logFatal(ctx, "%v", err)
+	}
+	pages := pagesByKey(states)
+	c := newCollector(cfg)
+	// pages must be fetched again, not read from the cache
+	c.CacheDir = ""
+	onPage(c, isValidCrawlLink, filterPage, func(r *colly.Response, url string, links map[string][]string) {
+		previous := states[r.Request.Ctx.Get("recrawlURL")]
+		recrawlPage(r, url, links, previous, pages, addEdgesIfDoNotExist, removeEdges)
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		result := "failed"
+		if r.StatusCode == http.StatusNotModified {
+			result = "not_modified"
+			s := states[r.Ctx.Get("recrawlURL")]
+			s.CrawledAt = time.Now().UTC()
+			writePageState(s)
+		}
+		recrawlPagesCounter.WithLabelValues(siteLabel(), result).Inc()
+	})
+	startStatus([]string{}, -1)
+	startReport([]string{})
+	for _, s := range stale {
+		rctx := colly.NewContext()
+		rctx.Put("recrawlURL", s.URL)
+		hdr := http.Header{}
+		if s.ETag != "" {
+			hdr.Set("If-None-Match", s.ETag)
+		}
+		if s.LastModified != "" {
+			hdr.Set("If-Modified-Since", s.LastModified)
+		}
+		updateFrontier(1)
+		if err := c.Request("GET", requestURL(s.URL), nil, rctx, hdr); err != nil {
+			updateFrontier(-1)
+			logWarn(ctx, "Error visiting '%s', %v", s.URL, err)
+		}
+	}
+	c.Wait()
+	closePageStates()
+	if err := compactPageStates(cfg.PageStateFile); err != nil {
+		logErr(ctx, "Could not compact page state file: %v", err)
+	}
+	finishCrawl(cfg, "recrawl done")
+}
+
+// page states by node key
+func pagesByKey(states map[string]PageState) map[string]PageState {
+	pages := make(map[string]PageState)
+	for _, s := range states {
+		pages[keyOf(s.URL)] = s
+	}
+	return pages
+}
+
+// links of lost which linked back to the page at url with relation when
+// they were last crawled, as pages by node key
+func linkedBack(pages map[string]PageState, url string, relation string, lost []string) []string {
+	key := keyOf(url)
+	back := []string{}
+	for _, l := range lost {
+		for _, b := range pages[keyOf(l)].linksOf(relation) {
+			if keyOf(b) == key {
+				back = append(back, l)
+				break
+			}
+		}
+	}
+	return back
+}
+
+// adds edges to links the page at url gained and removes those it lost
+// since it was crawled as previous. pages by node key tell which lost links
+// still link back
+func recrawlPage(
+	r *colly.Response,
+	url string,
+	links map[string][]string,
+	previous PageState,
+	pages map[string]PageState,
+	addEdgesIfDoNotExist AddEdgeFunction,
+	removeEdges RemoveEdgeFunction,
+) {
+	ctx := pageContext(r.Request)
+	site := siteLabel()
+	// pages which redirect somewhere else now keep the edges and state of
+	// the URL they were crawled at
+	if previous.URL != "" {
+		url = previous.URL
+	}
+	total, added, removed, nodesAdded := 0, 0, 0, 0
+	var err error
+	for _, label := range linkLabels() {
+		total += len(links[label])
+		gained, lost := diffLinks(previous.linksOf(label), links[label])
+		rctx := util.WithRelation(ctx, label)
+		if len(gained) > 0 {
+			nodes, addErr := addEdgesIfDoNotExist(rctx, url, gained)
+			if addErr != nil {
+				err = addErr
+				continue
+			}
+			added += len(gained)
+			nodesAdded += len(nodes)
+		}
+		if len(lost) > 0 {
+			backCtx := util.WithLinkedBack(rctx, linkedBack(pages, url, label, lost))
+			if removeErr := removeEdges(backCtx, url, lost); removeErr != nil {
+				err = removeErr
+				continue
+			}
+			removed += len(lost)
+		}
+	}
+	recrawlEdgesCounter.WithLabelValues(site, "added").Add(float64(added))
+	recrawlEdgesCounter.WithLabelValues(site, "removed").Add(float64(removed))
+	UpdateMetrics(nodesAdded, r.Request.Depth)
+	reportPage(r.Request.URL.Host, r.Request.Depth, total, added, nodesAdded, err)
+	if err != nil {
+		// state is kept so the page is retried on the next recrawl
+		logErr(ctx, "error updating edges of '%s': %v", url, err)
+		recrawlPagesCounter.WithLabelValues(site, "failed").Inc()
+		return
+	}
+	if added == 0 && removed == 0 {
+		recrawlPagesCounter.WithLabelValues(site, "unchanged").Inc()
+	} else {
+		logMsg(ctx, "%s gained %d and lost %d links", url, added, removed)
+		recrawlPagesCounter.WithLabelValues(site, "changed").Inc()
+	}
+	recordPageState(r, url, links)
+}
@@ -0,0 +1,187 @@
+package crawler
+
+import (
+	"context"
+	"errors"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/gocolly/colly"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDiffLinks(t *testing.T) {
+	SetNodeKey(strings.ToLower)
+	defer SetNodeKey(func(link string) string { return link })
+	type Test struct {
+		Name            string
+		Previous        []string
+		Current         []string
+		ExpectedAdded   []string
+		ExpectedRemoved []string
+	}
+	testTable := []Test{
+		Test{"unchanged", []string{"/a", "/b"}, []string{"/b", "/a"}, nil, nil},
+		Test{"compares keys", []string{"/A"}, []string{"/a"}, nil, nil},
+		Test{"added and removed", []string{"/a", "/b", "/b"}, []string{"/a", "/c", "/c"}, []string{"/c"}, []string{"/b"}},
+		Test{"new page", nil, []string{"/a"}, []string{"/a"}, nil},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			added, removed := diffLinks(test.Previous, test.Current)
+			assert.Equal(t, test.ExpectedAdded, added)
+			assert.Equal(t, test.ExpectedRemoved, removed)
+		})
+	}
+}
+
+func TestLinkedBack(t *testing.T) {
+	SetNodeKey(func(link string) string { return strings.TrimPrefix(strings.ToLower(link), "http://a.com") })
+	defer SetNodeKey(func(link string) string { return link })
+	pages := pagesByKey(map[string]PageState{
+		"http://a.com/b": PageState{URL: "http://a.com/b", Links: []string{"/A", "/d"}},
+		"http://a.com/c": PageState{URL: "http://a.com/c", Links: []string{"/d"}, Relations: map[string][]string{"antonym": []string{"/a"}}},
+	})
+	// d was never crawled, c only links back as an antonym
+	assert.Equal(t, []string{"/b"}, linkedBack(pages, "http://a.com/a", "", []string{"/b", "/c", "/d"}))
+	assert.Equal(t, []string{"/c"}, linkedBack(pages, "http://a.com/a", "antonym", []string{"/b", "/c"}))
+}
+
+func TestPageStates(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "pagestate")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "pages.jsonl")
+	old := time.Now().Add(-time.Hour).UTC()
+
+	require.Nil(t, openPageStates(path))
+	writePageState(PageState{URL: "/a", CrawledAt: old, Links: []string{"/b"}})
+	writePageState(PageState{URL: "/b", CrawledAt: old.Add(-time.Hour), ETag: `"1"`})
+	writePageState(PageState{URL: "/a", CrawledAt: old.Add(time.Minute), Links: []string{"/c"}})
+	writePageState(PageState{URL: "/c", CrawledAt: time.Now()})
+	closePageStates()
+
+	t.Run("later states replace earlier ones", func(t *testing.T) {
+		states, err := loadPageStates(path)
+		require.Nil(t, err)
+		assert.Equal(t, 3, len(states))
+		assert.Equal(t, []string{"/c"}, states["/a"].Links)
+	})
+	t.Run("finds stale pages, oldest first", func(t *testing.T) {
+		states, _ := loadPageStates(path)
+		stale := stalePages(states, time.Now().Add(-time.Minute))
+		assert.Equal(t, 2, len(stale))
+		assert.Equal(t, "/b", stale[0].URL)
+		assert.Equal(t, "/a", stale[1].URL)
+	})
+	t.Run("compacts to latest states", func(t *testing.T) {
+		require.Nil(t, compactPageStates(path))
+		b, _ := ioutil.ReadFile(path)
+		assert.Equal(t, 3, strings.Count(string(b), "\n"))
+		states, _ := loadPageStates(path)
+		assert.Equal(t, []string{"/c"}, states["/a"].Links)
+	})
+	t.Run("fails on bad file", func(t *testing.T) {
+		ioutil.WriteFile(path, []byte("{not json"), 0644)
+		_, err := loadPageStates(path)
+		assert.Error(t, err)
+		_, err = loadPageStates(filepath.Join(dir, "missing.jsonl"))
+		assert.Error(t, err)
+	})
+}
+
+func TestRecrawl(t *testing.T) {
+	// pages and their current links, /unchanged supports ETags
+	links := map[string][]string{
+		"/unchanged": []string{"/a"},
+		"/same":      []string{"/a", "/b"},
+		"/changed":   []string{"/a", "/c"},
+		"/failing":   []string{"/d"},
+		"/target":    []string{"/a", "/f"},
+	}
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/moved" {
+			http.Redirect(w, r, "/target", http.StatusMovedPermanently)
+			return
+		}
+		if r.URL.Path == "/unchanged" {
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+		}
+		body := "<html><body>"
+		for _, l := range links[r.URL.Path] {
+			body += `<a href="` + l + `">` + l + `</a>`
+		}
+		w.Write([]byte(body + "</body></html>"))
+	}))
+	defer site.Close()
+
+	dir, _ := ioutil.TempDir("", "recrawl")
+	defer os.RemoveAll(dir)
+	cfg := config.Default()
+	cfg.MsDelay = 0
+	cfg.PageStateFile = filepath.Join(dir, "pages.jsonl")
+	cfg.RecrawlTTLSec = 60
+	old := time.Now().Add(-time.Hour).UTC()
+	require.Nil(t, openPageStates(cfg.PageStateFile))
+	writePageState(PageState{URL: site.URL + "/unchanged", CrawledAt: old, ETag: `"v1"`, Links: []string{"/a"}})
+	writePageState(PageState{URL: site.URL + "/same", CrawledAt: old, Links: []string{"/b", "/a"}})
+	writePageState(PageState{URL: site.URL + "/changed", CrawledAt: old, Links: []string{"/a", "/b"}})
+	writePageState(PageState{URL: site.URL + "/failing", CrawledAt: old, Links: []string{"/e"}})
+	writePageState(PageState{URL: site.URL + "/moved", CrawledAt: old, Links: []string{"/a", "/b"}})
+	// crawled recently, not revisited
+	writePageState(PageState{URL: site.URL + "/fresh", CrawledAt: time.Now(), Links: []string{"/a"}})
+	closePageStates()
+
+	mutex := sync.Mutex{}
+	changes := []string{}
+	addEdges := func(ctx context.Context, curr string, neighbors []string) ([]string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if strings.HasSuffix(curr, "/failing") {
+			return nil, errors.New("graph is down")
+		}
+		changes = append(changes, strings.TrimPrefix(curr, site.URL)+" +"+strings.Join(neighbors, ","))
+		return neighbors, nil
+	}
+	removeEdges := func(ctx context.Context, curr string, neighbors []string) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+		changes = append(changes, strings.TrimPrefix(curr, site.URL)+" -"+strings.Join(neighbors, ","))
+		return nil
+	}
+	isValidCrawlLink := func(string) bool { return true }
+	filterPage := func(e *colly.HTMLElement) (*colly.HTMLElement, error) { return e, nil }
+	Recrawl(cfg, isValidCrawlLink, func() error { return nil }, addEdges, removeEdges, filterPage)
+
+	sort.Strings(changes)
+	// redirected pages are compared to the links of the URL they were crawled at
+	assert.Equal(t, []string{"/changed +/c", "/changed -/b", "/moved +/f", "/moved -/b"}, changes)
+	states, err := loadPageStates(cfg.PageStateFile)
+	require.Nil(t, err)
+	assert.Equal(t, 6, len(states))
+	assert.Equal(t, []string{"/a", "/c"}, states[site.URL+"/changed"].Links)
+	// and replace its state, it is not stale anymore
+	assert.True(t, states[site.URL+"/moved"].CrawledAt.After(old))
+	assert.Equal(t, []string{"/a", "/f"}, states[site.URL+"/moved"].Links)
+	// unchanged pages are marked as crawled, failed ones are retried next time
+	assert.True(t, states[site.URL+"/unchanged"].CrawledAt.After(old))
+	assert.True(t, states[site.URL+"/same"].CrawledAt.After(old))
+	assert.Equal(t, old, states[site.URL+"/failing"].CrawledAt)
+	assert.Equal(t, []string{"/e"}, states[site.URL+"/failing"].Links)
+	assert.Equal(t, 1.0, testutil.ToFloat64(recrawlPagesCounter.WithLabelValues(siteLabel(), "not_modified")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(recrawlPagesCounter.WithLabelValues(siteLabel(), "changed")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(recrawlEdgesCounter.WithLabelValues(siteLabel(), "removed")))
+}
@@ -0,0 +1,53 @@
+package crawler
+
+import (
+	"fmt"
+	"strings"
+)
+
+// relations links are extracted as, all links of the filtered page are
+// extracted untyped if empty
+var relations = []Relation{}
+
+// selects relations of the site listed in labels, comma separated. links
+// are extracted untyped if labels is empty.
+func SetRelations(available []Relation, labels string) error {
+	selected := []Relation{}
+	for _, label := range strings.Split(labels, ",") {
+		label = strings.TrimSpace(label)
+		if label == "" {
+			continue
+		}
+		found := false
+		for _, r := range available {
+			if r.Label == label {
+				selected = append(selected, r)
+				found = true
+			}
+		}
+		if !found {
+			names := []string{}
+			for _, r := range available {
+				names = append(names, r.Label)
+			}
+			if len(names) == 0 {
+				return fmt.Errorf("site has no relations, but RELATIONS was '%s'", labels)
+			}
+			return fmt.Errorf("unknown relation '%s', site has %s", label, strings.Join(names, ", "))
+		}
+	}
+	relations = selected
+	return nil
+}
+
+// labels of relations links are extracted as, "" for untyped links
+func linkLabels() []string {
+	if len(relations) == 0 {
+		return []string{""}
+	}
+	labels := []string{}
+	for _, r := range relations {
+		labels = append(labels, r.Label)
+	}
+	return labels
+}
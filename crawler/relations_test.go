@@ -0,0 +1,66 @@
+package crawler
+
+import (
+	"github.com/PuerkitoBio/goquery"
+	"github.com/gocolly/colly"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+var testRelations = []Relation{
+	{Label: "synonym", Selector: ".syns"},
+	{Label: "antonym", Selector: ".ants"},
+}
+
+func TestSetRelations(t *testing.T) {
+	defer func() { relations = []Relation{} }()
+	t.Run("links are untyped by default", func(t *testing.T) {
+		assert.Nil(t, SetRelations(testRelations, ""))
+		assert.Equal(t, []string{""}, linkLabels())
+	})
+	t.Run("selects listed relations", func(t *testing.T) {
+		assert.Nil(t, SetRelations(testRelations, "synonym, antonym"))
+		assert.Equal(t, []string{"synonym", "antonym"}, linkLabels())
+		assert.Nil(t, SetRelations(testRelations, "antonym"))
+		assert.Equal(t, []string{"antonym"}, linkLabels())
+	})
+	t.Run("rejects unknown relations", func(t *testing.T) {
+		assert.EqualError(t, SetRelations(testRelations, "synonym,related"), "unknown relation 'related', site has synonym, antonym")
+		assert.EqualError(t, SetRelations(nil, "synonym"), "site has no relations, but RELATIONS was 'synonym'")
+	})
+}
+
+func TestExtractLinksByRelation(t *testing.T) {
+	defer func() { relations = []Relation{} }()
+	body := `<html><body>
+		<div class="syns"><a href="/word/big">big</a><a href="/word/huge">huge</a></div>
+		<div class="ants"><a href="/word/small">small</a><a href="/about">about</a></div>
+		<a href="/word/other">other</a>
+	</body></html>`
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(body))
+	require.Nil(t, err)
+	u, _ := url.Parse("https://www.synonyms.com/word/large")
+	resp := &colly.Response{Request: &colly.Request{URL: u, Ctx: colly.NewContext()}}
+	e := colly.NewHTMLElementFromSelectionNode(resp, doc.Selection, doc.Nodes[0], 0)
+	isValidCrawlLink := func(l string) bool { return strings.HasPrefix(l, "/word/") }
+	filterPage := func(e *colly.HTMLElement) (*colly.HTMLElement, error) {
+		return colly.NewHTMLElementFromSelectionNode(e.Response, e.DOM.Find(".syns"), e.DOM.Find(".syns").Nodes[0], 0), nil
+	}
+
+	t.Run("untyped links come from the filtered page", func(t *testing.T) {
+		relations = []Relation{}
+		links := extractLinks(e, isValidCrawlLink, filterPage)
+		assert.Equal(t, map[string][]string{"": []string{"/word/big", "/word/huge"}}, links)
+	})
+	t.Run("typed links come from each relation's selector", func(t *testing.T) {
+		require.Nil(t, SetRelations(testRelations, "synonym,antonym"))
+		links := extractLinks(e, isValidCrawlLink, filterPage)
+		assert.Equal(t, map[string][]string{
+			"synonym": []string{"/word/big", "/word/huge"},
+			"antonym": []string{"/word/small"},
+		}, links)
+	})
+}
@@ -0,0 +1,301 @@
+package crawler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// number of hosts listed in the report
+var maxReportHosts = 10
+
+// length of each throughput sample in the report
+var throughputInterval = time.Duration(time.Minute)
+
+// pages are bucketed by number of valid links, each bucket holds pages
+// with at least Min links
+var outDegreeBuckets = []int{0, 1, 10, 100, 1000}
+
+// auditable record of a finished crawl
+type Report struct {
+	Site              string             `json:"site"`
+	RunID             string             `json:"runId"`
+	StopReason        string             `json:"stopReason"`
+	Config            map[string]string  `json:"config"`
+	Seeds             []string           `json:"seeds"`
+	StartTime         time.Time          `json:"startTime"`
+	EndTime           time.Time          `json:"endTime"`
+	DurationSec       float64            `json:"durationSec"`
+	PagesFetched      int                `json:"pagesFetched"`
+	NodesAdded        int                `json:"nodesAdded"`
+	EdgesSubmitted    int                `json:"edgesSubmitted"`
+	Errors            map[string]int     `json:"errors"`
+	TopHosts          []HostCount        `json:"topHosts"`
+	DepthDistribution map[int]int        `json:"depthDistribution"`
+	OutDegree         []OutDegreeBucket  `json:"outDegree"`
+	Throughput        []ThroughputSample `json:"throughput"`
+}
+
+// pages fetched from a host
+type HostCount struct {
+	Host  string `json:"host"`
+	Pages int    `json:"pages"`
+}
+
+// pages with at least Min and, unless it is -1, at most Max valid links
+type OutDegreeBucket struct {
+	Min   int `json:"min"`
+	Max   int `json:"max"`
+	Pages int `json:"pages"`
+}
+
+// pages fetched and nodes added in one throughput interval
+type ThroughputSample struct {
+	Minute     float64 `json:"minute"`
+	Pages      int     `json:"pages"`
+	NodesAdded int     `json:"nodesAdded"`
+}
+
+// counts backing the report, kept for the whole crawl
+type reportState struct {
+	sync.Mutex
+	startTime      time.Time
+	seeds          []string
+	pages          int
+	nodesAdded     int
+	edgesSubmitted int
+	errors         map[string]int
+	hosts          map[string]int
+	depths         map[int]int
+	outDegree      []int
+	throughput     []ThroughputSample
+}
+
+var (
+	report = newReportState()
+	// report is written once, even if several pages reach the budget
+	finishOnce = sync.Once{}
+)
+
+func newReportState() *reportState {
+	return &reportState{
+		startTime: time.Now(),
+		errors:    make(map[string]int),
+		hosts:     make(map[string]int),
+		depths:    make(map[int]int),
+		outDegree: make([]int, len(outDegreeBuckets)),
+	}
+}
+
+// marks the beginning of the crawl in the report
+func startReport(seeds []string) {
+	report.Lock()
+	defer report.Unlock()
+	report.startTime = time.Now()
+	report.seeds = append(report.seeds, seeds...)
+}
+
+// adds an error at stage to the report
+func reportError(stage string) {
+	report.Lock()
+	defer report.Unlock()
+	report.errors[stage]++
+}
+
+// adds a parsed page with the given number of valid links to the report.
+// edgesSubmitted are the links the graph db accepted whether or not they
+// were new edges, e.g. those of relations it did not fail on. nodesAdded
+// are only counted if the db did not fail
+func reportPage(host string, depth int, links int, edgesSubmitted int, nodesAdded int, dbErr error) {
+	report.Lock()
+	defer report.Unlock()
+	report.pages++
+	report.hosts[host]++
+	report.depths[depth]++
+	for i := len(outDegreeBuckets) - 1; i >= 0; i-- {
+		if links >= outDegreeBuckets[i] {
+			report.outDegree[i]++
+			break
+		}
+	}
+	report.edgesSubmitted += edgesSubmitted
+	if dbErr != nil {
+		return
+	}
+	report.nodesAdded += nodesAdded
+	// one sample per interval since the start, including empty ones
+	i := int(time.Since(report.startTime) / throughputInterval)
+	for len(report.throughput) <= i {
+		minute := (time.Duration(len(report.throughput)) * throughputInterval).Minutes()
+		report.throughput = append(report.throughput, ThroughputSample{Minute: minute})
+	}
+	report.throughput[i].Pages++
+	report.throughput[i].NodesAdded += nodesAdded
+}
+
+// snapshot of the crawl so far
+func buildReport(cfg config.Config, stopReason string) Report {
+	report.Lock()
+	defer report.Unlock()
+	end := time.Now()
+	r := Report{
+		Site:              siteLabel(),
+		RunID:             cfg.RunID,
+		StopReason:        stopReason,
+		Config:            make(map[string]string),
+		Seeds:             append([]string{}, report.seeds...),
+		StartTime:         report.startTime,
+		EndTime:           end,
+		DurationSec:       end.Sub(report.startTime).Seconds(),
+		PagesFetched:      report.pages,
+		NodesAdded:        report.nodesAdded,
+		EdgesSubmitted:    report.edgesSubmitted,
+		Errors:            make(map[string]int),
+		TopHosts:          []HostCount{},
+		DepthDistribution: make(map[int]int),
+		OutDegree:         []OutDegreeBucket{},
+		Throughput:        append([]ThroughputSample{}, report.throughput...),
+	}
+	redacted := cfg.Redacted()
+	for _, f := range redacted.Fields() {
+		r.Config[f.Env] = f.String()
+	}
+	for stage, n := range report.errors {
+		r.Errors[stage] = n
+	}
+	for host, n := range report.hosts {
+		r.TopHosts = append(r.TopHosts, HostCount{host, n})
+	}
+	sort.Slice(r.TopHosts, func(i, j int) bool {
+		if r.TopHosts[i].Pages != r.TopHosts[j].Pages {
+			return r.TopHosts[i].Pages > r.TopHosts[j].Pages
+		}
+		return r.TopHosts[i].Host < r.TopHosts[j].Host
+	})
+	if len(r.TopHosts) > maxReportHosts {
+		r.TopHosts = r.TopHosts[:maxReportHosts]
+	}
+	for depth, n := range report.depths {
+		r.DepthDistribution[depth] = n
+	}
+	for i, min := range outDegreeBuckets {
+		max := -1
+		if i+1 < len(outDegreeBuckets) {
+			max = outDegreeBuckets[i+1] - 1
+		}
+		r.OutDegree = append(r.OutDegree, OutDegreeBucket{min, max, report.outDegree[i]})
+	}
+	return r
+}
+
+// writes report to cfg.ReportFile and cfg.ReportMarkdownFile, if set
+func writeReport(cfg config.Config, stopReason string) error {
+	if cfg.ReportFile == "" && cfg.ReportMarkdownFile == "" {
+		return nil
+	}
+	r := buildReport(cfg, stopReason)
+	if cfg.ReportFile != "" {
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(cfg.ReportFile, append(b, '\n'), 0644); err != nil {
+			return fmt.Errorf("could not write report: %v", err)
+		}
+	}
+	if cfg.ReportMarkdownFile != "" {
+		f, err := os.Create(cfg.ReportMarkdownFile)
+		if err != nil {
+			return fmt.Errorf("could not write report: %v", err)
+		}
+		defer f.Close()
+		r.Markdown(f)
+	}
+	return nil
+}
+
+// logs why the crawl stopped and writes its report, only the first call
+// has an effect
+func finishCrawl(cfg config.Config, stopReason string) {
+	finishOnce.Do(func() {
+		ctx := context.Background()
+		logMsg(ctx, "Crawl finished: %s", stopReason)
+		if err := writeReport(cfg, stopReason); err != nil {
+			logErr(ctx, "%v", err)
+		}
+	})
+}
+
+// writes report as Markdown
+func (r Report) Markdown(w io.Writer) {
+	fmt.Fprintf(w, "# Crawl report: %s\n\n", r.Site)
+	fmt.Fprintf(w, "| | |\n|---|---|\n")
+	fmt.Fprintf(w, "| run ID | %s |\n", r.RunID)
+	fmt.Fprintf(w, "| stop reason | %s |\n", r.StopReason)
+	fmt.Fprintf(w, "| start | %s |\n", r.StartTime.Format(time.RFC3339))
+	fmt.Fprintf(w, "| end | %s |\n", r.EndTime.Format(time.RFC3339))
+	fmt.Fprintf(w, "| duration | %s |\n", time.Duration(r.DurationSec*float64(time.Second)).Round(time.Second))
+	fmt.Fprintf(w, "| pages fetched | %d |\n", r.PagesFetched)
+	fmt.Fprintf(w, "| nodes added | %d |\n", r.NodesAdded)
+	fmt.Fprintf(w, "| edges submitted | %d |\n", r.EdgesSubmitted)
+	fmt.Fprintf(w, "| seeds | %s |\n", strings.Join(r.Seeds, ", "))
+
+	fmt.Fprintf(w, "\n## Errors\n\n| stage | errors |\n|---|---|\n")
+	stages := []string{}
+	for stage := range r.Errors {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+	for _, stage := range stages {
+		fmt.Fprintf(w, "| %s | %d |\n", stage, r.Errors[stage])
+	}
+
+	fmt.Fprintf(w, "\n## Top hosts\n\n| host | pages |\n|---|---|\n")
+	for _, h := range r.TopHosts {
+		fmt.Fprintf(w, "| %s | %d |\n", h.Host, h.Pages)
+	}
+
+	fmt.Fprintf(w, "\n## Depth\n\n| depth | pages |\n|---|---|\n")
+	depths := []int{}
+	for depth := range r.DepthDistribution {
+		depths = append(depths, depth)
+	}
+	sort.Ints(depths)
+	for _, depth := range depths {
+		fmt.Fprintf(w, "| %d | %d |\n", depth, r.DepthDistribution[depth])
+	}
+
+	fmt.Fprintf(w, "\n## Out-degree\n\n| links | pages |\n|---|---|\n")
+	for _, b := range r.OutDegree {
+		links := fmt.Sprintf("%d+", b.Min)
+		if b.Max == b.Min {
+			links = fmt.Sprintf("%d", b.Min)
+		} else if b.Max != -1 {
+			links = fmt.Sprintf("%d-%d", b.Min, b.Max)
+		}
+		fmt.Fprintf(w, "| %s | %d |\n", links, b.Pages)
+	}
+
+	fmt.Fprintf(w, "\n## Throughput\n\n| minute | pages | nodes added |\n|---|---|---|\n")
+	for _, s := range r.Throughput {
+		fmt.Fprintf(w, "| %g | %d | %d |\n", s.Minute, s.Pages, s.NodesAdded)
+	}
+
+	fmt.Fprintf(w, "\n## Config\n\n| setting | value |\n|---|---|\n")
+	keys := []string{}
+	for k := range r.Config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "| %s | %s |\n", k, r.Config[k])
+	}
+}
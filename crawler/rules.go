@@ -0,0 +1,97 @@
+package crawler
+
+import (
+	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"regexp"
+	"strings"
+)
+
+var linksFilteredCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "golang",
+		Name:      "links_filtered",
+		Help:      "Number of links rejected by configured rules (deny_pattern, allow_pattern, deny_list)",
+	}, []string{"site", "rule"})
+
+// allow / deny rules from config, layered on top of the site's validator
+type linkRules struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+	// denied node keys
+	denied map[string]bool
+}
+
+func newLinkRules(cfg config.Config) (*linkRules, error) {
+	r := &linkRules{denied: make(map[string]bool)}
+	var err error
+	if r.allow, err = config.ParsePatterns(cfg.AllowPatterns); err != nil {
+		return nil, err
+	}
+	if r.deny, err = config.ParsePatterns(cfg.DenyPatterns); err != nil {
+		return nil, err
+	}
+	for _, path := range strings.Split(cfg.DenyListFiles, ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		keys, err := readList(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read deny list: %v", err)
+		}
+		for _, k := range keys {
+			r.denied[keyOf(k)] = true
+		}
+	}
+	return r, nil
+}
+
+// true if no rules are configured
+func (r *linkRules) empty() bool {
+	return len(r.allow) == 0 && len(r.deny) == 0 && len(r.denied) == 0
+}
+
+// site validator with deny patterns applied before it, and allow patterns
+// and deny lists applied to links it accepts
+func (r *linkRules) wrap(isValidCrawlLink IsValidCrawlLinkFunction) IsValidCrawlLinkFunction {
+	if r.empty() {
+		return isValidCrawlLink
+	}
+	return func(link string) bool {
+		if matchesAny(r.deny, link) {
+			linksFilteredCounter.WithLabelValues(siteLabel(), "deny_pattern").Inc()
+			return false
+		}
+		if !isValidCrawlLink(link) {
+			return false
+		}
+		if len(r.allow) > 0 && !matchesAny(r.allow, link) {
+			linksFilteredCounter.WithLabelValues(siteLabel(), "allow_pattern").Inc()
+			return false
+		}
+		if r.denied[keyOf(link)] {
+			linksFilteredCounter.WithLabelValues(siteLabel(), "deny_list").Inc()
+			return false
+		}
+		return true
+	}
+}
+
+func matchesAny(patterns []*regexp.Regexp, link string) bool {
+	for _, p := range patterns {
+		if p.MatchString(link) {
+			return true
+		}
+	}
+	return false
+}
+
+// site validator with rules from cfg applied
+func withLinkRules(cfg config.Config, isValidCrawlLink IsValidCrawlLinkFunction) (IsValidCrawlLinkFunction, error) {
+	rules, err := newLinkRules(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return rules.wrap(isValidCrawlLink), nil
+}
@@ -0,0 +1,77 @@
+package crawler
+
+import (
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLinkRules(t *testing.T) {
+	denyList, _ := ioutil.TempFile("", "deny")
+	defer os.Remove(denyList.Name())
+	denyList.WriteString("# not worth crawling\n/wiki/Cheddar_Cheese\n")
+	denyList.Close()
+	SetNodeKey(func(link string) string {
+		return strings.ToLower(strings.TrimPrefix(link, "https://en.wikipedia.org"))
+	})
+	defer SetNodeKey(func(link string) string { return link })
+
+	siteValidated := []string{}
+	isValidCrawlLink := func(link string) bool {
+		siteValidated = append(siteValidated, link)
+		return strings.HasPrefix(link, "/wiki/") && link != "/wiki/Main_Page"
+	}
+	cfg := config.Default()
+	cfg.DenyPatterns = `^/wiki/List_of_ ^/wiki/\d{4}$`
+	cfg.AllowPatterns = `Cheese$ ^/wiki/[A-Z]`
+	cfg.DenyListFiles = "," + denyList.Name()
+	isValid, err := withLinkRules(cfg, isValidCrawlLink)
+	assert.Nil(t, err)
+
+	type Test struct {
+		Link         string
+		Valid        bool
+		Rule         string
+		SiteValidate bool
+	}
+	testTable := []Test{
+		Test{"/wiki/String_cheese", true, "", true},
+		Test{"/wiki/List_of_cheeses", false, "deny_pattern", false},
+		Test{"/wiki/1999", false, "deny_pattern", false},
+		Test{"/wiki/Main_Page", false, "", true},
+		Test{"/wiki/l_cheese", false, "allow_pattern", true},
+		Test{"/wiki/blue_Cheese", true, "", true},
+		Test{"https://en.wikipedia.org/wiki/cheddar_cheese", false, "", true},
+		Test{"/wiki/Cheddar_cheese", false, "deny_list", true},
+	}
+	for _, test := range testTable {
+		t.Run(test.Link, func(t *testing.T) {
+			siteValidated = []string{}
+			before := 0.0
+			if test.Rule != "" {
+				before = testutil.ToFloat64(linksFilteredCounter.WithLabelValues(siteLabel(), test.Rule))
+			}
+			assert.Equal(t, test.Valid, isValid(test.Link))
+			assert.Equal(t, test.SiteValidate, len(siteValidated) == 1)
+			if test.Rule != "" {
+				assert.Equal(t, before+1, testutil.ToFloat64(linksFilteredCounter.WithLabelValues(siteLabel(), test.Rule)))
+			}
+		})
+	}
+
+	t.Run("returns site validator without rules", func(t *testing.T) {
+		rules, err := newLinkRules(config.Default())
+		assert.Nil(t, err)
+		assert.True(t, rules.empty())
+	})
+	t.Run("fails on missing deny list", func(t *testing.T) {
+		cfg := config.Default()
+		cfg.DenyListFiles = "/does/not/exist"
+		_, err := withLinkRules(cfg, isValidCrawlLink)
+		assert.Error(t, err)
+	})
+}
@@ -1,12 +1,16 @@
 package crawler
 
 import (
+	"context"
 	"github.com/gocolly/colly"
 )
 
 // add edge to graph in DB
 // return 'true' if edge already exists
-type AddEdgeFunction func(string, []string) ([]string, error)
+type AddEdgeFunction func(context.Context, string, []string) ([]string, error)
+
+// removes edges from a page to links it no longer has
+type RemoveEdgeFunction func(context.Context, string, []string) error
 
 // establishes initial connection to DB
 type ConnectToDBFunction func() error
@@ -22,3 +26,23 @@ type asyncInt int32
 
 // filters page down to more specific element
 type FilterPageFunction func(e *colly.HTMLElement) (*colly.HTMLElement, error)
+
+// standardizes a link to the key it is stored under
+type CleanUrlFunction func(string) string
+
+// links under Selector are edges of type Label, e.g. ".ants" -> "antonym"
+type Relation struct {
+	Label    string
+	Selector string
+}
+
+// reads links of pages from an API instead of scraping their HTML
+type LinkAPI struct {
+	// media type of API responses, e.g. "application/json"
+	ContentType string
+	// URL the links of page are requested from
+	RequestURL func(page string) string
+	// URL of the page a response to requestURL is for and its links. links
+	// listed in several responses are fetched here.
+	ParseLinks func(ctx context.Context, requestURL string, body []byte) (string, []string, error)
+}
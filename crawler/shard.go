@@ -0,0 +1,240 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/gocolly/colly"
+	"github.com/prometheus/client_golang/prometheus"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	shardLinksCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "golang",
+			Name:      "shard_links",
+			Help:      "Number of discovered links owned by other shards, by result (forwarded, failed, edge_only)",
+		}, []string{"site", "result"})
+
+	shardInboxCounter = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "golang",
+			Name:      "shard_inbox",
+			Help:      "Number of links received from other shards",
+		}, []string{"site"})
+
+	// links forwarded to this shard, waiting to be visited
+	inbox = make(chan forwardedLink, 10000)
+	// when the inbox last received links
+	lastInbox = asyncTime{}
+	// how often a shard waiting for links checks whether it is done
+	inboxPollInterval = time.Duration(time.Second)
+	// standardizes links to node keys, set with SetNodeKey
+	nodeKey = struct {
+		sync.RWMutex
+		clean CleanUrlFunction
+	}{clean: func(link string) string { return link }}
+)
+
+// sets function which standardizes links to the key of their node, so all
+// forms of a link are owned by the same shard and match the same deny lists
+func SetNodeKey(clean CleanUrlFunction) {
+	nodeKey.Lock()
+	defer nodeKey.Unlock()
+	nodeKey.clean = clean
+}
+
+// node key of link
+func keyOf(link string) string {
+	nodeKey.RLock()
+	defer nodeKey.RUnlock()
+	return nodeKey.clean(link)
+}
+
+// splits links between SHARD_COUNT instances
+type sharder struct {
+	index int
+	count int
+	// metrics server addresses of all shards, by index
+	peers  []string
+	client http.Client
+}
+
+func newSharder(cfg config.Config) *sharder {
+	s := &sharder{
+		index:  cfg.ShardIndex,
+		count:  cfg.ShardCount,
+		client: http.Client{Timeout: 5 * time.Second},
+	}
+	if s.count < 1 {
+		s.count = 1
+	}
+	if cfg.ShardPeers != "" {
+		for _, p := range strings.Split(cfg.ShardPeers, ",") {
+			s.peers = append(s.peers, strings.TrimSpace(p))
+		}
+	}
+	return s
+}
+
+// true if there is more than one shard
+func (s *sharder) enabled() bool {
+	return s.count > 1
+}
+
+// true if links owned by other shards are forwarded to them
+func (s *sharder) forwarding() bool {
+	return s.enabled() && len(s.peers) == s.count
+}
+
+// shard which owns link
+func (s *sharder) owner(link string) int {
+	h := fnv.New32a()
+	h.Write([]byte(keyOf(link)))
+	return int(h.Sum32() % uint32(s.count))
+}
+
+func (s *sharder) owns(link string) bool {
+	return !s.enabled() || s.owner(link) == s.index
+}
+
+// splits links into those owned by this shard and those owned by others
+func (s *sharder) split(links []string) (owned []string, foreign []string) {
+	for _, l := range links {
+		if s.owns(l) {
+			owned = append(owned, l)
+		} else {
+			foreign = append(foreign, l)
+		}
+	}
+	return owned, foreign
+}
+
+// sends links to the inbox of the shards which own them, to be visited at
+// depth. links which cannot be forwarded are still in the graph as edges of
+// the page they were found on
+func (s *sharder) forward(ctx context.Context, links []string, depth int) {
+	if len(links) == 0 {
+		return
+	}
+	if !s.forwarding() {
+		shardLinksCounter.WithLabelValues(siteLabel(), "edge_only").Add(float64(len(links)))
+		return
+	}
+	byOwner := make(map[int][]string)
+	for _, l := range links {
+		o := s.owner(l)
+		byOwner[o] = append(byOwner[o], l)
+	}
+	for o, owned := range byOwner {
+		result := "forwarded"
+		if err := s.send(s.peers[o], owned, depth); err != nil {
+			result = "failed"
+			logWarn(ctx, "Could not forward %d links to shard %d: %v", len(owned), o, err)
+		}
+		shardLinksCounter.WithLabelValues(siteLabel(), result).Add(float64(len(owned)))
+	}
+}
+
+// posts links to the inbox of the shard at addr
+func (s *sharder) send(addr string, links []string, depth int) error {
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		addr = "http://" + addr
+	}
+	req := inboxRequest{}
+	for _, l := range links {
+		req.Links = append(req.Links, forwardedLink{l, depth})
+	}
+	b, _ := json.Marshal(req)
+	res, err := s.client.Post(strings.TrimSuffix(addr, "/")+"/inbox", "application/json", bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("inbox responded with %d", res.StatusCode)
+	}
+	return nil
+}
+
+// link found by another shard, with the depth it is visited at
+type forwardedLink struct {
+	URL   string `json:"url"`
+	Depth int    `json:"depth"`
+}
+
+type inboxRequest struct {
+	Links []forwardedLink `json:"links"`
+}
+
+// accepts links forwarded by other shards
+func inboxHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	req := inboxRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(inbox)+len(req.Links) > cap(inbox) {
+		http.Error(w, "inbox is full", http.StatusServiceUnavailable)
+		return
+	}
+	for _, l := range req.Links {
+		// never block the handler, concurrent requests may have filled the inbox
+		select {
+		case inbox <- l:
+		default:
+			logWarn(r.Context(), "inbox is full, dropped %s", l.URL)
+		}
+	}
+	lastInbox.set(time.Now())
+	shardInboxCounter.WithLabelValues(siteLabel()).Add(float64(len(req.Links)))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// visits link forwarded by another shard at its depth. colly starts every
+// request at depth 1, the difference is kept in the request context.
+func visitForwarded(c *colly.Collector, link forwardedLink) error {
+	ctx := colly.NewContext()
+	ctx.Put("depthOffset", strconv.Itoa(link.Depth-1))
+	return c.Request("GET", requestURL(link.URL), nil, ctx, nil)
+}
+
+// depth of the page requested by r, counting depth forwarded with its seed
+func pageDepth(r *colly.Request) int {
+	if r.Ctx == nil {
+		return r.Depth
+	}
+	offset, _ := strconv.Atoi(r.Ctx.Get("depthOffset"))
+	return r.Depth + offset
+}
+
+// waits until no links were forwarded to this shard for idle and all pages
+// are done. progress is marked meanwhile, an idle shard is still healthy.
+func awaitInbox(c *colly.Collector, idle time.Duration) {
+	start := time.Now()
+	ticker := time.NewTicker(inboxPollInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		markProgress()
+		last := lastInbox.get()
+		if last.Before(start) {
+			last = start
+		}
+		if time.Since(last) >= idle && frontierSize.get() <= 0 && inFlight.get() <= 0 && len(inbox) == 0 {
+			c.Wait()
+			return
+		}
+	}
+}
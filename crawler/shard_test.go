@@ -0,0 +1,225 @@
+package crawler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/gocolly/colly"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func shardConfig(index int, count int, peers ...string) config.Config {
+	cfg := config.Default()
+	cfg.ShardIndex = index
+	cfg.ShardCount = count
+	cfg.ShardPeers = strings.Join(peers, ",")
+	cfg.MaxApproxNodes = -1
+	cfg.MsDelay = 0
+	return cfg
+}
+
+func TestSharder(t *testing.T) {
+	t.Run("single shard owns everything", func(t *testing.T) {
+		s := newSharder(config.Default())
+		assert.False(t, s.enabled())
+		assert.True(t, s.owns("/wiki/Cheese"))
+	})
+	t.Run("every link has exactly one owner", func(t *testing.T) {
+		shards := []*sharder{newSharder(shardConfig(0, 3)), newSharder(shardConfig(1, 3)), newSharder(shardConfig(2, 3))}
+		perShard := []int{0, 0, 0}
+		for i := 0; i < 300; i++ {
+			link := "/wiki/" + strings.Repeat("a", i)
+			owners := 0
+			for j, s := range shards {
+				if s.owns(link) {
+					owners++
+					perShard[j]++
+				}
+			}
+			assert.Equal(t, 1, owners)
+		}
+		for _, n := range perShard {
+			assert.Greater(t, n, 50)
+		}
+	})
+	t.Run("uses cleaned key", func(t *testing.T) {
+		SetNodeKey(func(link string) string {
+			return strings.ToLower(strings.TrimPrefix(link, "https://en.wikipedia.org"))
+		})
+		defer SetNodeKey(func(link string) string { return link })
+		s := newSharder(shardConfig(0, 7))
+		assert.Equal(t, s.owner("/wiki/cheese"), s.owner("https://en.wikipedia.org/wiki/Cheese"))
+	})
+	t.Run("splits links by owner", func(t *testing.T) {
+		s := newSharder(shardConfig(1, 2))
+		links := []string{"/a", "/b", "/c", "/d", "/e", "/f"}
+		owned, foreign := s.split(links)
+		assert.Equal(t, len(links), len(owned)+len(foreign))
+		for _, l := range owned {
+			assert.Equal(t, 1, s.owner(l))
+		}
+		for _, l := range foreign {
+			assert.Equal(t, 0, s.owner(l))
+		}
+	})
+}
+
+func TestForward(t *testing.T) {
+	mutex := sync.Mutex{}
+	received := map[int][]string{}
+	depths := map[int]bool{}
+	peer := func(i int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/inbox", r.URL.Path)
+			req := inboxRequest{}
+			json.NewDecoder(r.Body).Decode(&req)
+			mutex.Lock()
+			for _, l := range req.Links {
+				received[i] = append(received[i], l.URL)
+				depths[l.Depth] = true
+			}
+			mutex.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+		}))
+	}
+	p0, p1, p2 := peer(0), peer(1), peer(2)
+	defer p0.Close()
+	defer p1.Close()
+	defer p2.Close()
+	links := []string{"/a", "/b", "/c", "/d", "/e", "/f", "/g", "/h"}
+
+	t.Run("sends links to their owners", func(t *testing.T) {
+		s := newSharder(shardConfig(0, 3, p0.URL, p1.URL, p2.URL))
+		assert.True(t, s.forwarding())
+		s.forward(context.Background(), links, 3)
+		total := 0
+		for i, urls := range received {
+			for _, u := range urls {
+				assert.Equal(t, i, s.owner(u))
+			}
+			total += len(urls)
+		}
+		assert.Equal(t, len(links), total)
+		// links are visited as deep as they were found
+		assert.Equal(t, map[int]bool{3: true}, depths)
+	})
+	t.Run("counts links which could not be forwarded", func(t *testing.T) {
+		before := testutil.ToFloat64(shardLinksCounter.WithLabelValues(siteLabel(), "failed"))
+		s := newSharder(shardConfig(0, 2, "localhost:1", "localhost:1"))
+		s.forward(context.Background(), links, 2)
+		assert.Equal(t, before+float64(len(links)), testutil.ToFloat64(shardLinksCounter.WithLabelValues(siteLabel(), "failed")))
+	})
+	t.Run("keeps links as edges only without peers", func(t *testing.T) {
+		before := testutil.ToFloat64(shardLinksCounter.WithLabelValues(siteLabel(), "edge_only"))
+		s := newSharder(shardConfig(0, 2))
+		assert.False(t, s.forwarding())
+		s.forward(context.Background(), links, 2)
+		assert.Equal(t, before+float64(len(links)), testutil.ToFloat64(shardLinksCounter.WithLabelValues(siteLabel(), "edge_only")))
+	})
+}
+
+func TestInboxHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(inboxHandler))
+	defer server.Close()
+	t.Run("queues links", func(t *testing.T) {
+		res, err := http.Post(server.URL, "application/json", bytes.NewBufferString(`{"links": [{"url": "/wiki/a", "depth": 2}, {"url": "/wiki/b", "depth": 3}]}`))
+		assert.Nil(t, err)
+		assert.Equal(t, http.StatusAccepted, res.StatusCode)
+		assert.Equal(t, forwardedLink{"/wiki/a", 2}, <-inbox)
+		assert.Equal(t, forwardedLink{"/wiki/b", 3}, <-inbox)
+		assert.True(t, time.Since(lastInbox.get()) < time.Minute)
+	})
+	t.Run("rejects bad requests", func(t *testing.T) {
+		res, _ := http.Post(server.URL, "application/json", bytes.NewBufferString(`urls`))
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+		res, _ = http.Get(server.URL)
+		assert.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+	})
+}
+
+func TestShardedCrawl(t *testing.T) {
+	pages := []string{"/a", "/b", "/c", "/d", "/e", "/f", "/g", "/h"}
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := "<html><body>"
+		for _, p := range pages {
+			body += `<a href="` + p + `">` + p + `</a>`
+		}
+		w.Write([]byte(body + "</body></html>"))
+	}))
+	defer site.Close()
+	cfg := shardConfig(1, 2)
+	shards := newSharder(cfg)
+	seed := ""
+	for _, p := range pages {
+		if shards.owns(site.URL + p) {
+			seed = site.URL + p
+			break
+		}
+	}
+	mutex := sync.Mutex{}
+	crawled := []string{}
+	addEdges := func(ctx context.Context, curr string, neighbors []string) ([]string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		crawled = append(crawled, curr)
+		added := []string{}
+		for _, n := range neighbors {
+			added = append(added, site.URL+n)
+		}
+		return added, nil
+	}
+	Crawl(
+		cfg,
+		[]string{seed},
+		func(string) bool { return true },
+		addEdges,
+		func(e *colly.HTMLElement) (*colly.HTMLElement, error) { return e, nil },
+	)
+	expected := []string{}
+	for _, p := range pages {
+		if shards.owns(site.URL + p) {
+			expected = append(expected, site.URL+p)
+		}
+	}
+	sort.Strings(crawled)
+	// only pages of this shard are fetched
+	assert.Equal(t, expected, crawled)
+	assert.Less(t, len(crawled), len(pages))
+}
+
+func TestVisitForwarded(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<html><body><a href="/next">next</a></body></html>`))
+	}))
+	defer site.Close()
+	c := colly.NewCollector()
+	depths := map[string]int{}
+	c.OnHTML("a[href]", func(e *colly.HTMLElement) {
+		depths[e.Request.URL.Path] = pageDepth(e.Request)
+		e.Request.Visit(e.Attr("href"))
+	})
+	c.MaxDepth = 2
+	assert.Nil(t, visitForwarded(c, forwardedLink{site.URL + "/first", 4}))
+	// pages linked from forwarded ones are one deeper
+	assert.Equal(t, map[string]int{"/first": 4, "/next": 5}, depths)
+}
+
+func TestAwaitInbox(t *testing.T) {
+	inboxPollInterval = 10 * time.Millisecond
+	defer func() { inboxPollInterval = time.Second }()
+	lastProgress.set(time.Time{})
+	start := time.Now()
+	awaitInbox(colly.NewCollector(), 50*time.Millisecond)
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	// waiting shards are still making progress
+	assert.True(t, lastProgress.get().After(start))
+}
@@ -0,0 +1,169 @@
+package crawler
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// sitemap indexes may point to other indexes, stop following them past this depth
+var maxSitemapDepth = 5
+
+var sitemapTimeout = time.Duration(30 * time.Second)
+
+// <urlset> or <sitemapindex> document
+type sitemapDoc struct {
+	XMLName  xml.Name
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// host of the site being crawled, see SetSiteHost
+var siteHost = ""
+
+// only seeds sitemap entries on the host of baseEndpoint, e.g.
+// "https://en.wikipedia.org". entries on any host are seeded if empty
+func SetSiteHost(baseEndpoint string) {
+	siteHost = ""
+	if u, err := url.Parse(baseEndpoint); err == nil {
+		siteHost = u.Hostname()
+	}
+}
+
+// reads seeds from the sitemap or sitemap index at location (a URL or file
+// path, optionally gzipped). entries on other hosts than host (if not empty)
+// are skipped, the rest are filtered through isValidCrawlLink by their path,
+// as they would appear as links on a page.
+func LoadSitemap(location string, host string, userAgent string, isValidCrawlLink IsValidCrawlLinkFunction) ([]string, error) {
+	l := &sitemapLoader{
+		host:      host,
+		userAgent: userAgent,
+		isValid:   isValidCrawlLink,
+		visited:   make(map[string]bool),
+		seen:      make(map[string]bool),
+	}
+	if err := l.load(location, 0); err != nil {
+		return nil, err
+	}
+	return l.seeds, nil
+}
+
+type sitemapLoader struct {
+	host      string
+	userAgent string
+	isValid   IsValidCrawlLinkFunction
+	// sitemaps already loaded
+	visited map[string]bool
+	// seeds already added
+	seen  map[string]bool
+	seeds []string
+}
+
+func (l *sitemapLoader) load(location string, depth int) error {
+	if l.visited[location] {
+		return nil
+	}
+	l.visited[location] = true
+	body, err := l.open(location)
+	if err != nil {
+		return fmt.Errorf("could not read sitemap %s: %v", location, err)
+	}
+	defer body.Close()
+	doc, err := parseSitemap(body)
+	if err != nil {
+		return fmt.Errorf("could not parse sitemap %s: %v", location, err)
+	}
+	switch doc.XMLName.Local {
+	case "urlset":
+		for _, u := range doc.URLs {
+			l.add(strings.TrimSpace(u.Loc))
+		}
+	case "sitemapindex":
+		if depth >= maxSitemapDepth {
+			return fmt.Errorf("sitemap index %s is nested more than %d deep", location, maxSitemapDepth)
+		}
+		for _, s := range doc.Sitemaps {
+			if err := l.load(strings.TrimSpace(s.Loc), depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("%s is not a sitemap, found <%s>", location, doc.XMLName.Local)
+	}
+	return nil
+}
+
+// adds loc as seed if it is a valid crawl link on the site's host
+func (l *sitemapLoader) add(loc string) {
+	u, err := url.Parse(loc)
+	if err != nil || l.seen[loc] {
+		return
+	}
+	if l.host != "" && !strings.EqualFold(u.Hostname(), l.host) {
+		countRejectedLink()
+		return
+	}
+	link := u.EscapedPath()
+	if u.RawQuery != "" {
+		link += "?" + u.RawQuery
+	}
+	if !l.isValid(link) {
+		countRejectedLink()
+		return
+	}
+	l.seen[loc] = true
+	l.seeds = append(l.seeds, loc)
+}
+
+// opens URL or file at location
+func (l *sitemapLoader) open(location string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return os.Open(location)
+	}
+	req, err := http.NewRequest("GET", location, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", l.userAgent)
+	client := http.Client{
+		Timeout: sitemapTimeout,
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		res.Body.Close()
+		return nil, fmt.Errorf("unexpected status %d", res.StatusCode)
+	}
+	return res.Body, nil
+}
+
+// decodes sitemap XML, gunzipping it first if needed
+func parseSitemap(r io.Reader) (doc sitemapDoc, err error) {
+	br := bufio.NewReader(r)
+	// gzip magic number, servers don't reliably set Content-Encoding for .xml.gz
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return doc, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+	err = xml.NewDecoder(r).Decode(&doc)
+	return doc, err
+}
@@ -0,0 +1,124 @@
+package crawler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadSitemap(t *testing.T) {
+	isValidCrawlLink := func(link string) bool {
+		return strings.HasPrefix(link, "/wiki/") && !strings.Contains(link, ":")
+	}
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>https://en.wikipedia.org/wiki/String_cheese</loc></url>
+  <url><loc> https://en.wikipedia.org/wiki/Cheddar </loc></url>
+  <url><loc>https://en.wikipedia.org/wiki/Special:Random</loc></url>
+  <url><loc>https://en.wikipedia.org/about</loc></url>
+  <url><loc>https://cheese.example.com/wiki/Brie</loc></url>
+  <url><loc>https://en.wikipedia.org/wiki/String_cheese</loc></url>
+</urlset>`))
+		case "/sitemap-index.xml":
+			w.Write([]byte(`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>` + server.URL + `/sitemap.xml</loc></sitemap>
+  <sitemap><loc>` + server.URL + `/sitemap-2.xml.gz</loc></sitemap>
+  <sitemap><loc>` + server.URL + `/sitemap.xml</loc></sitemap>
+</sitemapindex>`))
+		case "/sitemap-2.xml.gz":
+			b := &bytes.Buffer{}
+			gz := gzip.NewWriter(b)
+			gz.Write([]byte(`<urlset><url><loc>https://en.wikipedia.org/wiki/Gouda</loc></url></urlset>`))
+			gz.Close()
+			w.Write(b.Bytes())
+		case "/loop.xml":
+			// points to a new, deeper index every time
+			w.Write([]byte(`<sitemapindex><sitemap><loc>` + server.URL + `/loop.xml?n=` + r.URL.Query().Get("n") + `1</loc></sitemap></sitemapindex>`))
+		case "/page.html":
+			w.Write([]byte(`<html><body></body></html>`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	type Test struct {
+		Name          string
+		Location      string
+		ExpectedSeeds []string
+		ExpectedError string
+	}
+	testTable := []Test{
+		Test{
+			Name:     "filters, dedupes and skips other hosts' urlset entries",
+			Location: server.URL + "/sitemap.xml",
+			ExpectedSeeds: []string{
+				"https://en.wikipedia.org/wiki/String_cheese",
+				"https://en.wikipedia.org/wiki/Cheddar",
+			},
+		},
+		Test{
+			Name:     "follows sitemap index and gzipped sitemaps",
+			Location: server.URL + "/sitemap-index.xml",
+			ExpectedSeeds: []string{
+				"https://en.wikipedia.org/wiki/String_cheese",
+				"https://en.wikipedia.org/wiki/Cheddar",
+				"https://en.wikipedia.org/wiki/Gouda",
+			},
+		},
+		Test{
+			Name:          "fails on bad status",
+			Location:      server.URL + "/missing.xml",
+			ExpectedError: "could not read sitemap " + server.URL + "/missing.xml: unexpected status 404",
+		},
+		Test{
+			Name:          "fails on documents which are not sitemaps",
+			Location:      server.URL + "/page.html",
+			ExpectedError: server.URL + "/page.html is not a sitemap, found <html>",
+		},
+		Test{
+			Name:          "stops following deeply nested indexes",
+			Location:      server.URL + "/loop.xml",
+			ExpectedError: "sitemap index " + server.URL + "/loop.xml?n=11111 is nested more than 5 deep",
+		},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			seeds, err := LoadSitemap(test.Location, "en.wikipedia.org", "test-agent", isValidCrawlLink)
+			if test.ExpectedError != "" {
+				assert.EqualError(t, err, test.ExpectedError)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, test.ExpectedSeeds, seeds)
+			}
+		})
+	}
+
+	t.Run("reads from file", func(t *testing.T) {
+		f, _ := ioutil.TempFile("", "sitemap.xml")
+		defer os.Remove(f.Name())
+		f.WriteString(`<urlset><url><loc>https://en.wikipedia.org/wiki/Brie</loc></url></urlset>`)
+		f.Close()
+		seeds, err := LoadSitemap(f.Name(), "", "test-agent", isValidCrawlLink)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"https://en.wikipedia.org/wiki/Brie"}, seeds)
+	})
+}
+
+func TestSetSiteHost(t *testing.T) {
+	defer SetSiteHost("")
+	SetSiteHost("https://fr.wikipedia.org")
+	assert.Equal(t, "fr.wikipedia.org", siteHost)
+	SetSiteHost("")
+	assert.Equal(t, "", siteHost)
+}
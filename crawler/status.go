@@ -0,0 +1,186 @@
+package crawler
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// at most this many seeds are listed in /status
+var maxStatusSeeds = 10
+
+// window in which errors are counted as "recent"
+var recentErrorWindow = time.Duration(5 * time.Minute)
+
+// live crawl progress, served on /status
+type Status struct {
+	Site              string         `json:"site"`
+	RunID             string         `json:"runId"`
+	StartTime         time.Time      `json:"startTime"`
+	Seeds             []string       `json:"seeds"`
+	SeedCount         int            `json:"seedCount"`
+	PagesVisited      int32          `json:"pagesVisited"`
+	NodesAdded        int32          `json:"nodesAdded"`
+	NodesBudget       int32          `json:"nodesBudget"`
+	FrontierSize      int32          `json:"frontierSize"`
+	InFlightRequests  int32          `json:"inFlightRequests"`
+	MaxDepth          int32          `json:"maxDepth"`
+	RecentErrors      map[string]int `json:"recentErrors"`
+	EstimatedTimeLeft string         `json:"estimatedTimeLeft"`
+}
+
+// internal state backing /status
+type crawlState struct {
+	sync.Mutex
+	site      string
+	runID     string
+	startTime time.Time
+	seeds     []string
+	seedCount int
+	budget    int32
+	errors    map[string][]time.Time
+}
+
+var (
+	state = &crawlState{
+		errors: make(map[string][]time.Time),
+	}
+	pagesVisited = asyncInt(0)
+	frontierSize = asyncInt(0)
+	inFlight     = asyncInt(0)
+)
+
+// sets the name of the site being crawled
+func SetSite(site string) {
+	state.Lock()
+	defer state.Unlock()
+	state.site = site
+}
+
+// sets the ID of the current crawl
+func SetRunID(id string) {
+	state.Lock()
+	defer state.Unlock()
+	state.runID = id
+}
+
+// new ID for a crawl, sortable by the time it started
+func NewRunID() string {
+	b := make([]byte, 3)
+	rand.Read(b)
+	return fmt.Sprintf("%s-%x", time.Now().UTC().Format("20060102T150405Z"), b)
+}
+
+// name of the site being crawled, used as metrics label
+func siteLabel() string {
+	state.Lock()
+	defer state.Unlock()
+	return state.site
+}
+
+// marks the beginning of a crawl
+func startStatus(seeds []string, budget int32) {
+	state.Lock()
+	defer state.Unlock()
+	state.startTime = time.Now()
+	for _, seed := range seeds {
+		if len(state.seeds) < maxStatusSeeds {
+			state.seeds = append(state.seeds, seed)
+		}
+	}
+	state.seedCount += len(seeds)
+	state.budget = budget
+}
+
+// records an error for the given stage (fetch, filter, kv, graph)
+func recordError(stage string) {
+	state.Lock()
+	defer state.Unlock()
+	state.errors[stage] = append(pruneErrors(state.errors[stage]), time.Now())
+}
+
+// drops error timestamps outside of the recent window
+func pruneErrors(times []time.Time) []time.Time {
+	cutoff := time.Now().Add(-recentErrorWindow)
+	i := 0
+	for i < len(times) && times[i].Before(cutoff) {
+		i++
+	}
+	return times[i:]
+}
+
+// builds a snapshot of the current crawl progress
+func GetStatus() Status {
+	state.Lock()
+	defer state.Unlock()
+	s := Status{
+		Site:             state.site,
+		RunID:            state.runID,
+		StartTime:        state.startTime,
+		Seeds:            append([]string{}, state.seeds...),
+		SeedCount:        state.seedCount,
+		PagesVisited:     pagesVisited.get(),
+		NodesAdded:       totalNodesAdded.get(),
+		NodesBudget:      state.budget,
+		FrontierSize:     frontierSize.get(),
+		InFlightRequests: inFlight.get(),
+		MaxDepth:         maxDepth.get(),
+		RecentErrors:     make(map[string]int),
+	}
+	for stage, times := range state.errors {
+		state.errors[stage] = pruneErrors(times)
+		s.RecentErrors[stage] = len(state.errors[stage])
+	}
+	s.EstimatedTimeLeft = estimateTimeLeft(s.NodesAdded, s.NodesBudget, time.Since(s.StartTime))
+	return s
+}
+
+// estimates time until budget is reached from the current rate
+func estimateTimeLeft(added int32, budget int32, elapsed time.Duration) string {
+	if budget == -1 {
+		return "unlimited"
+	}
+	if added >= budget {
+		return "0s"
+	}
+	if added == 0 || elapsed <= 0 {
+		return "unknown"
+	}
+	perNode := elapsed / time.Duration(added)
+	return (perNode * time.Duration(budget-added)).Round(time.Second).String()
+}
+
+// serves current status as JSON
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(GetStatus())
+}
+
+// retrieves status from a running crawler at addr
+func FetchStatus(addr string) (s Status, err error) {
+	if !strings.HasPrefix(addr, "http") {
+		addr = "http://" + addr
+	}
+	client := http.Client{
+		Timeout: time.Duration(5 * time.Second),
+	}
+	res, err := client.Get(strings.TrimSuffix(addr, "/") + "/status")
+	if err != nil {
+		return s, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return s, err
+	}
+	if res.StatusCode != 200 {
+		return s, fmt.Errorf("unexpected status code %d: %s", res.StatusCode, string(body))
+	}
+	err = json.Unmarshal(body, &s)
+	return s, err
+}
@@ -0,0 +1,82 @@
+package crawler
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestEstimateTimeLeft(t *testing.T) {
+	type Test struct {
+		Name     string
+		Added    int32
+		Budget   int32
+		Elapsed  time.Duration
+		Expected string
+	}
+	testTable := []Test{
+		Test{"unlimited budget", 10, -1, time.Second, "unlimited"},
+		Test{"budget reached", 10, 10, time.Second, "0s"},
+		Test{"nothing added yet", 0, 10, time.Second, "unknown"},
+		Test{"linear estimate", 10, 30, 10 * time.Second, "20s"},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expected, estimateTimeLeft(test.Added, test.Budget, test.Elapsed))
+		})
+	}
+}
+
+func TestRecordError(t *testing.T) {
+	t.Run("counts recent errors by stage", func(t *testing.T) {
+		recordError("filter")
+		recordError("filter")
+		assert.Equal(t, 2, GetStatus().RecentErrors["filter"])
+	})
+	t.Run("drops errors outside of window", func(t *testing.T) {
+		state.errors["db"] = []time.Time{time.Now().Add(-2 * recentErrorWindow)}
+		assert.Equal(t, 0, GetStatus().RecentErrors["db"])
+	})
+}
+
+func TestNewRunID(t *testing.T) {
+	id := NewRunID()
+	assert.Regexp(t, `^\d{8}T\d{6}Z-[0-9a-f]{6}$`, id)
+	assert.NotEqual(t, id, NewRunID())
+}
+
+func TestFetchStatus(t *testing.T) {
+	SetSite("wikipedia")
+	SetRunID("20201010T101010Z-abcdef")
+	startStatus([]string{"https://en.wikipedia.org/wiki/String_cheese"}, 100)
+	server := httptest.NewServer(http.HandlerFunc(statusHandler))
+	defer server.Close()
+
+	t.Run("retrieves status from server", func(t *testing.T) {
+		s, err := FetchStatus(server.URL)
+		require.NoError(t, err)
+		assert.Equal(t, "wikipedia", s.Site)
+		assert.Equal(t, "20201010T101010Z-abcdef", s.RunID)
+		assert.Contains(t, s.Seeds, "https://en.wikipedia.org/wiki/String_cheese")
+		assert.Equal(t, int32(100), s.NodesBudget)
+	})
+	t.Run("lists only the first seeds", func(t *testing.T) {
+		seeds := []string{}
+		for i := 0; i < 20; i++ {
+			seeds = append(seeds, "https://en.wikipedia.org/wiki/Cheese_"+strconv.Itoa(i))
+		}
+		before := GetStatus().SeedCount
+		startStatus(seeds, 100)
+		s := GetStatus()
+		assert.Equal(t, maxStatusSeeds, len(s.Seeds))
+		assert.Equal(t, before+20, s.SeedCount)
+	})
+	t.Run("fails on bad address", func(t *testing.T) {
+		_, err := FetchStatus("localhost:1")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,145 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/util"
+	"github.com/gocolly/colly"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"sync"
+	"time"
+)
+
+// tracer from the current global provider
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/dgoldstein1/crawler/crawler")
+}
+
+// spans and timings of a single page request
+type pageTrace struct {
+	start     time.Time
+	ctx       context.Context
+	pageSpan  trace.Span
+	fetchSpan trace.Span
+}
+
+// in flight pages by colly request ID. colly shares Request.Ctx between
+// a page and the links visited from it, so it cannot hold per-request state
+var pages = sync.Map{}
+
+// flushes remaining spans before exiting, set by InitTracing
+var flushTracing = func() {}
+
+// configures the global tracer provider from cfg.TracingExporter:
+// "otlp" sends spans to OTEL_EXPORTER_OTLP_ENDPOINT (default localhost:4318),
+// "file" writes them as JSON to cfg.TracingFile. Tracing is off when empty.
+// Returns a function which flushes remaining spans.
+func InitTracing(cfg config.Config) (shutdown func(), err error) {
+	var exporter sdktrace.SpanExporter
+	switch cfg.TracingExporter {
+	case "":
+		return func() {}, nil
+	case "otlp":
+		exporter, err = otlptracehttp.New(context.Background())
+	case "file":
+		if cfg.TracingFile == "" {
+			return nil, fmt.Errorf("TRACING_FILE was not set")
+		}
+		var f *os.File
+		if f, err = os.Create(cfg.TracingFile); err != nil {
+			return nil, err
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(f))
+	default:
+		return nil, fmt.Errorf("unknown TRACING_EXPORTER '%s', expected 'otlp' or 'file'", cfg.TracingExporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String("crawler"),
+			attribute.String("site", siteLabel()),
+		)),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	flushTracing = func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			logErr(context.Background(), "Could not flush traces: %v", err)
+		}
+	}
+	return flushTracing, nil
+}
+
+// starts page and fetch spans for a request
+func startPage(r *colly.Request) {
+	ctx, pageSpan := tracer().Start(context.Background(), "page", trace.WithAttributes(
+		attribute.String("url", r.URL.String()),
+		attribute.Int("depth", pageDepth(r)),
+	))
+	_, fetchSpan := tracer().Start(ctx, "fetch")
+	ctx = util.WithLogFields(ctx, log.Fields{
+		"url":   r.URL.String(),
+		"depth": pageDepth(r),
+	})
+	pages.Store(r.ID, &pageTrace{
+		start:     time.Now(),
+		ctx:       ctx,
+		pageSpan:  pageSpan,
+		fetchSpan: fetchSpan,
+	})
+}
+
+// ends fetch span and observes fetch latency, err is nil on succesful fetch
+func endFetch(r *colly.Response, err error) {
+	p, ok := pages.Load(r.Request.ID)
+	if !ok {
+		return
+	}
+	t := p.(*pageTrace)
+	fetchLatency.WithLabelValues(siteLabel()).Observe(time.Since(t.start).Seconds())
+	t.fetchSpan.SetAttributes(attribute.Int("http.status_code", r.StatusCode))
+	endSpan(t.fetchSpan, err)
+}
+
+// context of the page span for a request
+func pageContext(r *colly.Request) context.Context {
+	if p, ok := pages.Load(r.ID); ok {
+		return p.(*pageTrace).ctx
+	}
+	return context.Background()
+}
+
+// ends page span and forgets the request
+func endPage(r *colly.Request, err error) {
+	p, ok := pages.Load(r.ID)
+	if !ok {
+		return
+	}
+	pages.Delete(r.ID)
+	endSpan(p.(*pageTrace).pageSpan, err)
+}
+
+// records error on span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,70 @@
+package crawler
+
+import (
+	"errors"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/gocolly/colly"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"testing"
+)
+
+func TestInitTracing(t *testing.T) {
+	cfg := config.Default()
+	t.Run("tracing is off by default", func(t *testing.T) {
+		shutdown, err := InitTracing(cfg)
+		require.NoError(t, err)
+		shutdown()
+	})
+	t.Run("fails on unknown exporter", func(t *testing.T) {
+		cfg.TracingExporter = "zipkin"
+		_, err := InitTracing(cfg)
+		assert.EqualError(t, err, "unknown TRACING_EXPORTER 'zipkin', expected 'otlp' or 'file'")
+	})
+	t.Run("file exporter requires TRACING_FILE", func(t *testing.T) {
+		cfg.TracingExporter = "file"
+		_, err := InitTracing(cfg)
+		assert.EqualError(t, err, "TRACING_FILE was not set")
+	})
+	t.Run("file exporter writes spans", func(t *testing.T) {
+		f, _ := ioutil.TempFile("", "traces")
+		defer os.Remove(f.Name())
+		cfg.TracingExporter = "file"
+		cfg.TracingFile = f.Name()
+		shutdown, err := InitTracing(cfg)
+		require.NoError(t, err)
+		_, span := tracer().Start(pageContext(&colly.Request{ID: 1}), "test span")
+		span.End()
+		shutdown()
+		b, _ := ioutil.ReadFile(f.Name())
+		assert.Contains(t, string(b), "test span")
+	})
+}
+
+func TestPageSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	orig := otel.GetTracerProvider()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(orig)
+
+	u, _ := url.Parse("https://en.wikipedia.org/wiki/String_cheese")
+	r := &colly.Request{ID: 42, URL: u, Depth: 2}
+	startPage(r)
+	endFetch(&colly.Response{Request: r, StatusCode: 404}, errors.New("Not Found"))
+	endPage(r, errors.New("Not Found"))
+
+	spans := recorder.Ended()
+	require.Equal(t, 2, len(spans))
+	assert.Equal(t, "fetch", spans[0].Name())
+	assert.Equal(t, "page", spans[1].Name())
+	assert.Equal(t, spans[1].SpanContext().TraceID(), spans[0].SpanContext().TraceID())
+	// request is forgotten once page ends
+	_, ok := pages.Load(r.ID)
+	assert.False(t, ok)
+}
@@ -0,0 +1,191 @@
+package crawler
+
+import (
+	"context"
+	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/coordinator"
+	"github.com/gocolly/colly"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// wait between lease requests when there is no work or the coordinator is unavailable
+var workerBackoff = time.Duration(2 * time.Second)
+
+// crawls URLs leased from the coordinator at cfg.CoordinatorAddr until it is done
+func RunWorker(
+	cfg config.Config,
+	isValidCrawlLink IsValidCrawlLinkFunction,
+	connectToDB ConnectToDBFunction,
+	addEdgesIfDoNotExist AddEdgeFunction,
+	filterPage FilterPageFunction,
+) {
+	ctx := context.Background()
+	if err := connectToDB(); err != nil {
+		logFatal(ctx, "Could not connect do db: %v", err)
+	}
+	isValidCrawlLink, err := withLinkRules(cfg, isValidCrawlLink)
+	if err != nil {
+		logFatal(ctx, "Could not load link rules: %v", err)
+	}
+	if err := openPageStates(cfg.PageStateFile); err != nil {
+		logFatal(ctx, "%v", err)
+	}
+	client := coordinator.NewClient(cfg.CoordinatorAddr)
+	hostname, _ := os.Hostname()
+	worker := fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	logMsg(ctx, "working for coordinator %s as %s", cfg.CoordinatorAddr, worker)
+	startStatus([]string{}, int32(cfg.MaxApproxNodes))
+	startReport([]string{})
+	c, results := newLeaseCollector(cfg, isValidCrawlLink, addEdgesIfDoNotExist, filterPage)
+	for {
+		lease, done, err := client.Lease(worker, cfg.LeaseSize)
+		if err != nil {
+			logWarn(ctx, "Could not get lease: %v", err)
+			time.Sleep(workerBackoff)
+			continue
+		}
+		if done {
+			logMsg(ctx, "coordinator is done, stopping")
+			finishCrawl(cfg, "coordinator done")
+			return
+		}
+		if len(lease.URLs) == 0 {
+			markProgress()
+			time.Sleep(workerBackoff)
+			continue
+		}
+		list := crawlLease(c, results, client, lease)
+		if err := completeLease(client, lease.ID, list); err != nil {
+			logErr(ctx, "Could not complete lease %s: %v", lease.ID, err)
+		}
+	}
+}
+
+// results of the lease being crawled by leased URL, pages may be
+// redirected to another URL
+type leaseResults struct {
+	sync.Mutex
+	results map[string]*coordinator.Result
+}
+
+// calls update with the result of leaseURL
+func (l *leaseResults) update(leaseURL string, update func(r *coordinator.Result)) {
+	l.Lock()
+	defer l.Unlock()
+	if r, ok := l.results[leaseURL]; ok {
+		update(r)
+	}
+}
+
+// collector for all leases of a worker, recording results of the lease
+// being crawled
+func newLeaseCollector(
+	cfg config.Config,
+	isValidCrawlLink IsValidCrawlLinkFunction,
+	addEdgesIfDoNotExist AddEdgeFunction,
+	filterPage FilterPageFunction,
+) (*colly.Collector, *leaseResults) {
+	results := &leaseResults{}
+	c := newCollector(cfg)
+	// the coordinator decides what is crawled, URLs of expired leases may
+	// be leased to the same worker again
+	c.AllowURLRevisit = true
+	onPage(c, isValidCrawlLink, filterPage, func(res *colly.Response, url string, links map[string][]string) {
+		nodesAdded, continuations, newNodes := handlePage(res, url, links, addEdgesIfDoNotExist)
+		visitContinuations(c, res.Request, continuations)
+		results.update(res.Request.Ctx.Get("leaseURL"), func(r *coordinator.Result) {
+			r.Discovered = append(r.Discovered, nodesAdded...)
+			r.NodesAdded += newNodes
+		})
+	})
+	c.OnError(func(res *colly.Response, err error) {
+		// unchanged pages are not failures
+		if res.StatusCode == http.StatusNotModified {
+			return
+		}
+		results.update(res.Request.Ctx.Get("leaseURL"), func(r *coordinator.Result) {
+			r.Error = err.Error()
+		})
+	})
+	return c, results
+}
+
+// crawls URLs in lease with c, renewing it until done
+func crawlLease(
+	c *colly.Collector,
+	results *leaseResults,
+	client *coordinator.Client,
+	lease coordinator.Lease,
+) []coordinator.Result {
+	stop := make(chan struct{})
+	defer close(stop)
+	go renewLease(client, lease, stop)
+
+	// fetches start right away, every result must exist before the first
+	results.Lock()
+	results.results = make(map[string]*coordinator.Result)
+	for _, item := range lease.URLs {
+		results.results[item.URL] = &coordinator.Result{URL: item.URL, Depth: item.Depth}
+	}
+	results.Unlock()
+	for _, item := range lease.URLs {
+		// colly starts every request at depth 1, pages are reported at
+		// the depth the coordinator leased them at
+		ctx := colly.NewContext()
+		ctx.Put("leaseURL", item.URL)
+		ctx.Put("depthOffset", strconv.Itoa(item.Depth-1))
+		updateFrontier(1)
+		if err := c.Request("GET", requestURL(item.URL), nil, ctx, nil); err != nil {
+			updateFrontier(-1)
+			results.update(item.URL, func(r *coordinator.Result) {
+				r.Error = err.Error()
+			})
+		}
+	}
+	c.Wait()
+	results.Lock()
+	defer results.Unlock()
+	list := []coordinator.Result{}
+	for _, item := range lease.URLs {
+		list = append(list, *results.results[item.URL])
+	}
+	return list
+}
+
+// renews lease a few times per lease duration until stop is closed
+func renewLease(client *coordinator.Client, lease coordinator.Lease, stop chan struct{}) {
+	duration := lease.Duration
+	for {
+		interval := duration / 3
+		if interval <= 0 {
+			interval = time.Second
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+			renewed, err := client.Renew(lease.ID)
+			if err != nil {
+				logWarn(context.Background(), "Could not renew lease %s: %v", lease.ID, err)
+				continue
+			}
+			duration = renewed.Duration
+		}
+	}
+}
+
+// reports results, retrying so discovered links are not lost
+func completeLease(client *coordinator.Client, id string, results []coordinator.Result) (err error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		if err = client.Complete(id, results); err == nil {
+			return nil
+		}
+		time.Sleep(workerBackoff)
+	}
+	return err
+}
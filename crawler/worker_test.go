@@ -0,0 +1,161 @@
+package crawler
+
+import (
+	"context"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/coordinator"
+	"github.com/gocolly/colly"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunWorker(t *testing.T) {
+	workerBackoff = 10 * time.Millisecond
+	defer func() { workerBackoff = 2 * time.Second }()
+	// small site where every page links to the next ones
+	links := map[string][]string{
+		"/a": []string{"/b", "/c"},
+		"/b": []string{"/c", "/d"},
+		"/c": []string{"/d"},
+		"/d": []string{"/a"},
+	}
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := "<html><body>"
+		for _, l := range links[r.URL.Path] {
+			body += `<a href="` + l + `">` + l + `</a>`
+		}
+		w.Write([]byte(body + "</body></html>"))
+	}))
+	defer site.Close()
+
+	mutex := sync.Mutex{}
+	crawled := []string{}
+	addEdges := func(ctx context.Context, curr string, neighbors []string) ([]string, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		crawled = append(crawled, curr)
+		added := []string{}
+		for _, n := range neighbors {
+			added = append(added, site.URL+n)
+		}
+		return added, nil
+	}
+	isValidCrawlLink := func(string) bool { return true }
+	filterPage := func(e *colly.HTMLElement) (*colly.HTMLElement, error) { return e, nil }
+
+	coord := coordinator.New([]string{site.URL + "/a"}, -1, 200*time.Millisecond)
+	server := httptest.NewServer(coord.Handler())
+	defer server.Close()
+	// a worker which leases the seed and dies
+	dead := coordinator.NewClient(server.URL)
+	l, _, err := dead.Lease("dead", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(l.URLs))
+
+	cfg := config.Default()
+	cfg.MsDelay = 0
+	cfg.LeaseSize = 1
+	cfg.CoordinatorAddr = server.URL
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			RunWorker(cfg, isValidCrawlLink, func() error { return nil }, addEdges, filterPage)
+		}()
+	}
+	wg.Wait()
+
+	sort.Strings(crawled)
+	// every page is crawled once, including the one leased by the dead worker
+	assert.Equal(t, []string{site.URL + "/a", site.URL + "/b", site.URL + "/c", site.URL + "/d"}, crawled)
+	s := coord.Status()
+	assert.True(t, s.Done)
+	assert.Equal(t, 4, s.Completed)
+	assert.Equal(t, 1, s.Reissued)
+	assert.Equal(t, 2, s.MaxDepth)
+}
+
+func TestCrawlLeaseNotModified(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+	defer site.Close()
+	coord := coordinator.New([]string{site.URL + "/a"}, -1, time.Minute)
+	server := httptest.NewServer(coord.Handler())
+	defer server.Close()
+	client := coordinator.NewClient(server.URL)
+	l, _, err := client.Lease("worker", 1)
+	assert.Nil(t, err)
+
+	cfg := config.Default()
+	cfg.MsDelay = 0
+	addEdges := func(ctx context.Context, curr string, neighbors []string) ([]string, error) {
+		return neighbors, nil
+	}
+	isValidCrawlLink := func(string) bool { return true }
+	filterPage := func(e *colly.HTMLElement) (*colly.HTMLElement, error) { return e, nil }
+	c, results := newLeaseCollector(cfg, isValidCrawlLink, addEdges, filterPage)
+	// unchanged pages are not failures
+	assert.Equal(t, []coordinator.Result{coordinator.Result{URL: site.URL + "/a"}}, crawlLease(c, results, client, l))
+	// the collector is reused, leases may contain URLs crawled before
+	assert.Equal(t, []coordinator.Result{coordinator.Result{URL: site.URL + "/a"}}, crawlLease(c, results, client, l))
+}
+
+func TestCrawlLeaseDepth(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("<html><body></body></html>"))
+	}))
+	defer site.Close()
+	coord := coordinator.New([]string{}, -1, time.Minute)
+	server := httptest.NewServer(coord.Handler())
+	defer server.Close()
+	client := coordinator.NewClient(server.URL)
+	l := coordinator.Lease{
+		ID:       "lease",
+		URLs:     []coordinator.Item{coordinator.Item{URL: site.URL + "/a", Depth: 3}},
+		Duration: time.Minute,
+	}
+
+	cfg := config.Default()
+	cfg.MsDelay = 0
+	addEdges := func(ctx context.Context, curr string, neighbors []string) ([]string, error) {
+		return neighbors, nil
+	}
+	isValidCrawlLink := func(string) bool { return true }
+	mutex := sync.Mutex{}
+	depths := []int{}
+	filterPage := func(e *colly.HTMLElement) (*colly.HTMLElement, error) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		depths = append(depths, pageDepth(e.Request))
+		return e, nil
+	}
+	c, results := newLeaseCollector(cfg, isValidCrawlLink, addEdges, filterPage)
+	crawlLease(c, results, client, l)
+	assert.Equal(t, []int{3}, depths)
+}
+
+func TestRenewLease(t *testing.T) {
+	coord := coordinator.New([]string{"/a"}, -1, 90*time.Millisecond)
+	server := httptest.NewServer(coord.Handler())
+	defer server.Close()
+	client := coordinator.NewClient(server.URL)
+	l, _, err := client.Lease("worker", 1)
+	assert.Nil(t, err)
+	// an expiry far in the past, as a worker with a skewed clock sees it
+	l.ExpiresAt = time.Time{}
+	stop := make(chan struct{})
+	go renewLease(client, l, stop)
+	time.Sleep(200 * time.Millisecond)
+	close(stop)
+	// the lease was renewed in time, nothing is re-issued
+	_, err = client.Renew(l.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, coord.Status().Reissued)
+}
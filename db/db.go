@@ -2,28 +2,77 @@ package db
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/util"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	"io/ioutil"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
-var logErr = log.Errorf
+// databases and edges written to them, see Configure
+var settings = struct {
+	graphDBEndpoint   string
+	twoWayKVEndpoint  string
+	relationEndpoints string
+	edgeMode          string
+	edgeProvenance    bool
+	runID             string
+}{}
+
+// writes to the databases of cfg, with its EDGE_MODE and, if
+// EDGE_PROVENANCE is set, the provenance of edges
+func Configure(cfg config.Config) {
+	settings.graphDBEndpoint = cfg.GraphDBEndpoint
+	settings.twoWayKVEndpoint = cfg.TwoWayKVEndpoint
+	settings.relationEndpoints = cfg.RelationEndpoints
+	settings.edgeMode = cfg.EdgeMode
+	settings.edgeProvenance = cfg.EdgeProvenance
+	settings.runID = cfg.RunID
+}
+
+// logs error with fields attached to ctx
+var logErr = func(ctx context.Context, format string, args ...interface{}) {
+	util.Logger(ctx).Errorf(format, args...)
+}
 var timeout = time.Duration(5 * time.Second)
 
-// posts possible new edges to GRAPH_DB_ENDPOINT
-func AddNeighbors(curr int, neighborIds []int) (resp GraphResponseSuccess, err error) {
+// called after every request to the graph ("graph", or "graph_reverse" for
+// edges written back from neighbors) or two way kv ("kv") databases
+var ObserveRequest = func(target string, duration time.Duration, status int, err error) {}
+
+// posts possible new edges to the graph of their relation in ctx, with
+// their attributes and those of curr in ctx and their provenance if it is
+// not nil
+func AddNeighbors(ctx context.Context, curr int, neighborIds []int, provenance *Provenance) (resp GraphResponseSuccess, err error) {
+	ctx, span := tracer().Start(ctx, "AddNeighbors")
+	defer func() { endSpan(span, err) }()
+	start := time.Now()
+	status := 0
+	defer func() { ObserveRequest(graphTarget(ctx), time.Since(start), status, err) }()
 	// POST new neighbors to db
-	jsonValue, _ := json.Marshal(map[string][]int{
-		"neighbors": neighborIds,
-	})
-	url := os.Getenv("GRAPH_DB_ENDPOINT") + "/edges"
-	req, _ := http.NewRequest("POST", url, bytes.NewBuffer(jsonValue))
+	relation := util.Relation(ctx)
+	jsonValue, _ := json.Marshal(struct {
+		Neighbors      []int             `json:"neighbors"`
+		Relation       string            `json:"relation,omitempty"`
+		Attributes     map[string]string `json:"attributes,omitempty"`
+		NodeAttributes map[string]string `json:"nodeAttributes,omitempty"`
+		Provenance     *Provenance       `json:"provenance,omitempty"`
+	}{neighborIds, relation, util.EdgeAttributes(ctx), util.NodeAttributes(ctx), provenance})
+	url := graphEndpoint(relation) + "/edges"
+	req, _ := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonValue))
 	req.Header.Set("Content-Type", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 	q := req.URL.Query()
 	q.Add("node", strconv.Itoa(curr))
 	req.URL.RawQuery = q.Encode()
@@ -36,6 +85,7 @@ func AddNeighbors(curr int, neighborIds []int) (resp GraphResponseSuccess, err e
 	if err != nil {
 		return resp, err
 	}
+	status = res.StatusCode
 	// assert response is 200
 	if res.StatusCode != 200 {
 		body, err := ioutil.ReadAll(res.Body)
@@ -61,15 +111,63 @@ func AddNeighbors(curr int, neighborIds []int) (resp GraphResponseSuccess, err e
 	return resp, err
 }
 
+// deletes edges from curr to neighborIds in the graph of their relation in ctx
+func DeleteEdges(ctx context.Context, curr int, neighborIds []int) (err error) {
+	ctx, span := tracer().Start(ctx, "DeleteEdges")
+	defer func() { endSpan(span, err) }()
+	start := time.Now()
+	status := 0
+	defer func() { ObserveRequest(graphTarget(ctx), time.Since(start), status, err) }()
+	relation := util.Relation(ctx)
+	jsonValue, _ := json.Marshal(struct {
+		Neighbors []int  `json:"neighbors"`
+		Relation  string `json:"relation,omitempty"`
+	}{neighborIds, relation})
+	url := graphEndpoint(relation) + "/edges"
+	req, _ := http.NewRequestWithContext(ctx, "DELETE", url, bytes.NewBuffer(jsonValue))
+	req.Header.Set("Content-Type", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	q := req.URL.Query()
+	q.Add("node", strconv.Itoa(curr))
+	req.URL.RawQuery = q.Encode()
+	client := http.Client{
+		Timeout: timeout,
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	status = res.StatusCode
+	if res.StatusCode != 200 {
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return err
+		}
+		errResp := GraphResponseError{}
+		if err := json.Unmarshal(body, &errResp); err != nil {
+			return fmt.Errorf("graph db responded with %d: %s", res.StatusCode, string(body))
+		}
+		return errors.New(errResp.Error)
+	}
+	return nil
+}
+
 // gets wikipedia int id from article url
-func GetArticleIds(articles []string) (resp TwoWayResponse, err error) {
+func GetArticleIds(ctx context.Context, articles []string) (resp TwoWayResponse, err error) {
+	ctx, span := tracer().Start(ctx, "GetArticleIds")
+	defer func() { endSpan(span, err) }()
+	start := time.Now()
+	status := 0
+	defer func() { ObserveRequest("kv", time.Since(start), status, err) }()
 	// create array of entries
 	b := new(bytes.Buffer)
 	json.NewEncoder(b).Encode(articles)
 	// post to endpoint
-	url := os.Getenv("TWO_WAY_KV_ENDPOINT") + "/entries"
-	req, _ := http.NewRequest("POST", url, b)
+	url := settings.twoWayKVEndpoint + "/entries"
+	req, _ := http.NewRequestWithContext(ctx, "POST", url, b)
 	req.Header.Set("Content-Type", "application/json")
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 	q := req.URL.Query()
 	q.Add("muteAlreadyExistsError", "true")
 	req.URL.RawQuery = q.Encode()
@@ -80,6 +178,7 @@ func GetArticleIds(articles []string) (resp TwoWayResponse, err error) {
 	if err != nil {
 		return resp, err
 	}
+	status = res.StatusCode
 	// read out response
 	if res.StatusCode != 200 {
 		body, err := ioutil.ReadAll(res.Body)
@@ -106,17 +205,88 @@ func GetArticleIds(articles []string) (resp TwoWayResponse, err error) {
 
 // connects to given databse and initializes scraper
 func ConnectToDB() error {
-	resp, err := http.Get(os.Getenv("GRAPH_DB_ENDPOINT"))
+	return checkEndpoint(settings.graphDBEndpoint)
+}
+
+// checks that endpoint responds with 2xx within timeout
+func checkEndpoint(endpoint string) error {
+	client := http.Client{
+		Timeout: timeout,
+	}
+	resp, err := client.Get(endpoint)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
-	_, err = ioutil.ReadAll(resp.Body)
-	return err
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with %d", endpoint, resp.StatusCode)
+	}
+	return nil
+}
+
+// tracer from the current global provider
+func tracer() trace.Tracer {
+	return otel.Tracer("github.com/dgoldstein1/crawler/db")
+}
+
+// records error on span, if any, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// checks that the two way k:v store is reachable
+func ConnectToKV() error {
+	return checkEndpoint(settings.twoWayKVEndpoint)
+}
+
+// checks that the graph db serves edges and responds in the expected format
+func CheckGraph() error {
+	client := http.Client{
+		Timeout: timeout,
+	}
+	res, err := client.Get(graphEndpoint("") + "/edges?node=1")
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode == http.StatusOK {
+		neighbors := GraphResponseNeighbors{}
+		if err := json.Unmarshal(body, &neighbors); err != nil || neighbors.Neighbors == nil {
+			return fmt.Errorf("unexpected edges response: %s", string(body))
+		}
+		return nil
+	}
+	// node 1 may not exist, which is answered with an error in the same format
+	errResp := GraphResponseError{}
+	if res.StatusCode >= 500 || json.Unmarshal(body, &errResp) != nil || errResp.Error == "" {
+		return fmt.Errorf("graph db responded with %d: %s", res.StatusCode, string(body))
+	}
+	return nil
+}
+
+// checks that the two way kv accepts lookups and responds in the expected format
+func CheckKV() error {
+	_, err := GetArticleIds(context.Background(), []string{})
+	if err != nil {
+		return fmt.Errorf("lookup failed: %v", err)
+	}
+	return nil
 }
 
 // adds edge to DB, returns new neighbors added (to crawl on)
 func AddEdgesIfDoNotExist(
+	ctx context.Context,
 	currentNode string,
 	neighborNodes []string,
 	cleanUrl func(string) string,
@@ -125,23 +295,31 @@ func AddEdgesIfDoNotExist(
 	neighborsAdded []string,
 	err error,
 ) {
+	provenance := edgeProvenance(currentNode)
 	// trim current node if needed
 	currentNode = cleanUrl(currentNode)
 	// get IDs from page keys
 	// make big map of  cleanName : originalName for later
 	nodes := make(map[string]string)
-	for i, n := range neighborNodes {
-		neighborNodes[i] = cleanUrl(n)
-		nodes[cleanUrl(n)] = n
+	keys := []string{}
+	for _, n := range neighborNodes {
+		key := cleanKey(ctx, n, cleanUrl)
+		if key == "" {
+			continue
+		}
+		keys = append(keys, key)
+		nodes[key] = n
 	}
-	twoWayResp, err := GetArticleIds(append(neighborNodes, currentNode))
+	neighborNodes = keys
+	kvCtx := util.WithLogFields(ctx, log.Fields{"stage": "kv"})
+	twoWayResp, err := GetArticleIds(ctx, append(neighborNodes, currentNode))
 	if err != nil {
-		logErr("Could not get neighbor Ids %v", err)
+		logErr(kvCtx, "Could not get neighbor Ids %v", err)
 		return neighborsAdded, err
 	}
 	// log out errors, if any
 	for _, e := range twoWayResp.Errors {
-		logErr("Error getting article ID: %s", e)
+		logErr(kvCtx, "Error getting article ID: %s", e)
 	}
 	// map string => id (int)
 	currentNodeId := -1
@@ -155,13 +333,13 @@ func AddEdgesIfDoNotExist(
 	}
 	// current cannot be -1
 	if currentNodeId == -1 {
-		logErr("Could not find reverse string => int lookup from \n resp: %v, \n currentNode: %s, \n neighbors : %v", twoWayResp.Entries, currentNode, neighborNodes)
+		logErr(kvCtx, "Could not find reverse string => int lookup from \n resp: %v, \n currentNode: %s, \n neighbors : %v", twoWayResp.Entries, currentNode, neighborNodes)
 		return neighborsAdded, errors.New("Could not find node on reverse lookup")
 	}
 	// post IDs to graph db
-	graphResp, err := AddNeighbors(currentNodeId, neighborNodesIds)
+	graphResp, err := writeEdges(ctx, currentNodeId, neighborNodesIds, provenance)
 	if err != nil {
-		logErr("Could not POST to graph DB")
+		logErr(util.WithLogFields(ctx, log.Fields{"stage": "graph"}), "Could not POST to graph DB: %v", err)
 		return neighborsAdded, err
 	}
 	// map id => string
@@ -176,3 +354,91 @@ func AddEdgesIfDoNotExist(
 	}
 	return neighborsAdded, err
 }
+
+// key of link, "" and logged if the site cannot clean it
+func cleanKey(ctx context.Context, link string, cleanUrl func(string) string) string {
+	key := cleanUrl(link)
+	if key == "" {
+		logErr(util.WithLogFields(ctx, log.Fields{"stage": "clean", "link": link}), "Could not clean link %s", link)
+	}
+	return key
+}
+
+// provenance of edges found on source, written now. nil unless enabled
+func edgeProvenance(source string) *Provenance {
+	if !settings.edgeProvenance {
+		return nil
+	}
+	now := time.Now().UTC()
+	return &Provenance{
+		RunID:     settings.runID,
+		FirstSeen: now,
+		WrittenAt: now,
+		Source:    source,
+	}
+}
+
+// removes edges from currentNode to neighborNodes, which no longer link to
+// each other, according to EDGE_MODE. in undirected mode, edges back from
+// neighbors in util.LinkedBack(ctx) are kept.
+func RemoveEdges(
+	ctx context.Context,
+	currentNode string,
+	neighborNodes []string,
+	cleanUrl func(string) string,
+) error {
+	currentNode = cleanUrl(currentNode)
+	keys := []string{}
+	for _, n := range neighborNodes {
+		if key := cleanKey(ctx, n, cleanUrl); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	kvCtx := util.WithLogFields(ctx, log.Fields{"stage": "kv"})
+	twoWayResp, err := GetArticleIds(ctx, append(keys, currentNode))
+	if err != nil {
+		logErr(kvCtx, "Could not get neighbor Ids %v", err)
+		return err
+	}
+	currentNodeId := -1
+	neighborNodesIds := []int{}
+	for _, entry := range twoWayResp.Entries {
+		if entry.Key == currentNode {
+			currentNodeId = entry.Value
+		} else {
+			neighborNodesIds = append(neighborNodesIds, entry.Value)
+		}
+	}
+	if currentNodeId == -1 {
+		return errors.New("Could not find node on reverse lookup")
+	}
+	// neighbors which still link back keep their own edges
+	linkedBack := make(map[int]bool)
+	for _, l := range util.LinkedBack(ctx) {
+		key := cleanUrl(l)
+		for _, entry := range twoWayResp.Entries {
+			if entry.Key == key {
+				linkedBack[entry.Value] = true
+			}
+		}
+	}
+	if err := deleteEdges(ctx, currentNodeId, neighborNodesIds, linkedBack); err != nil {
+		logErr(util.WithLogFields(ctx, log.Fields{"stage": "graph"}), "Could not DELETE from graph DB: %v", err)
+		return err
+	}
+	return nil
+}
+
+// graph edges of relation are written to, its entry in RELATION_ENDPOINTS
+// or GRAPH_DB_ENDPOINT
+func graphEndpoint(relation string) string {
+	if relation != "" {
+		for _, entry := range strings.Split(settings.relationEndpoints, ",") {
+			parts := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+			if len(parts) == 2 && parts[0] == relation {
+				return parts[1]
+			}
+		}
+	}
+	return settings.graphDBEndpoint
+}
@@ -1,21 +1,29 @@
 package db
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/util"
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 	"testing"
+	"time"
 )
 
 var dbEndpoint = "http://localhost:17474"
 var twoWayEndpoint = "http://localhost:17475"
 
 func TestAddNeighbors(t *testing.T) {
-	os.Setenv("GRAPH_DB_ENDPOINT", dbEndpoint)
+	settings.graphDBEndpoint = dbEndpoint
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
 	type Test struct {
@@ -68,10 +76,17 @@ func TestAddNeighbors(t *testing.T) {
 		},
 	}
 
+	observed := []int{}
+	ObserveRequest = func(target string, duration time.Duration, status int, err error) {
+		assert.Equal(t, "graph", target)
+		observed = append(observed, status)
+	}
+	defer func() { ObserveRequest = func(string, time.Duration, int, error) {} }()
+
 	for _, test := range testTable {
 		t.Run(test.Name, func(t *testing.T) {
 			test.Setup()
-			resp, err := AddNeighbors(test.CurrNode, test.NeighborIds)
+			resp, err := AddNeighbors(context.Background(), test.CurrNode, test.NeighborIds, nil)
 			if err != nil && test.ExpectedError != nil {
 				assert.Equal(t, test.ExpectedError.Error(), err.Error())
 			} else {
@@ -81,11 +96,212 @@ func TestAddNeighbors(t *testing.T) {
 			httpmock.Reset()
 		})
 	}
+	// observes status of every request
+	assert.Equal(t, []int{200, 500, 0}, observed)
+}
+
+func TestEdgeProvenance(t *testing.T) {
+	settings.graphDBEndpoint = dbEndpoint
+	defer func() { settings.edgeProvenance, settings.runID = false, "" }()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	bodies := []map[string]interface{}{}
+	httpmock.RegisterResponder("POST", dbEndpoint+"/edges?node=1",
+		func(req *http.Request) (*http.Response, error) {
+			body := map[string]interface{}{}
+			json.NewDecoder(req.Body).Decode(&body)
+			bodies = append(bodies, body)
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"neighborsAdded": []string{}})
+		},
+	)
 
+	t.Run("is not sent by default", func(t *testing.T) {
+		assert.Nil(t, edgeProvenance("https://en.wikipedia.org/wiki/Cheese"))
+		_, err := AddNeighbors(context.Background(), 1, []int{2}, nil)
+		assert.Nil(t, err)
+		assert.NotContains(t, bodies[0], "provenance")
+	})
+	t.Run("is sent with edges when enabled", func(t *testing.T) {
+		Configure(config.Config{GraphDBEndpoint: dbEndpoint, EdgeProvenance: true, RunID: "20201010T101010Z-abcdef"})
+		p := edgeProvenance("https://en.wikipedia.org/wiki/Cheese")
+		assert.Equal(t, "20201010T101010Z-abcdef", p.RunID)
+		assert.Equal(t, "https://en.wikipedia.org/wiki/Cheese", p.Source)
+		assert.WithinDuration(t, time.Now(), p.WrittenAt, time.Minute)
+		assert.Equal(t, p.WrittenAt, p.FirstSeen)
+		_, err := AddNeighbors(context.Background(), 1, []int{2}, p)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{
+			"runId":     "20201010T101010Z-abcdef",
+			"firstSeen": p.FirstSeen.Format(time.RFC3339Nano),
+			"writtenAt": p.WrittenAt.Format(time.RFC3339Nano),
+			"source":    "https://en.wikipedia.org/wiki/Cheese",
+		}, bodies[1]["provenance"])
+		assert.Equal(t, []interface{}{float64(2)}, bodies[1]["neighbors"])
+	})
+}
+
+func TestDeleteEdges(t *testing.T) {
+	settings.graphDBEndpoint = dbEndpoint
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	t.Run("deletes edges", func(t *testing.T) {
+		body := map[string][]int{}
+		httpmock.RegisterResponder("DELETE", dbEndpoint+"/edges?node=1",
+			func(req *http.Request) (*http.Response, error) {
+				json.NewDecoder(req.Body).Decode(&body)
+				return httpmock.NewJsonResponse(200, map[string]interface{}{})
+			},
+		)
+		assert.Nil(t, DeleteEdges(context.Background(), 1, []int{2, 3}))
+		assert.Equal(t, map[string][]int{"neighbors": []int{2, 3}}, body)
+	})
+	t.Run("returns error from db", func(t *testing.T) {
+		httpmock.RegisterResponder("DELETE", dbEndpoint+"/edges?node=1",
+			httpmock.NewStringResponder(404, `{"error": "node not found", "code": 404}`))
+		assert.EqualError(t, DeleteEdges(context.Background(), 1, []int{2}), "node not found")
+	})
+	t.Run("returns unexpected responses", func(t *testing.T) {
+		httpmock.RegisterResponder("DELETE", dbEndpoint+"/edges?node=1",
+			httpmock.NewStringResponder(405, `method not allowed`))
+		assert.EqualError(t, DeleteEdges(context.Background(), 1, []int{2}), "graph db responded with 405: method not allowed")
+	})
+}
+
+func TestRelationEndpoints(t *testing.T) {
+	settings.graphDBEndpoint = dbEndpoint
+	settings.relationEndpoints = "antonym=http://localhost:17476, related=http://localhost:17477"
+	defer func() { settings.relationEndpoints = "" }()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	t.Run("picks endpoint of relation", func(t *testing.T) {
+		assert.Equal(t, dbEndpoint, graphEndpoint(""))
+		assert.Equal(t, dbEndpoint, graphEndpoint("synonym"))
+		assert.Equal(t, "http://localhost:17476", graphEndpoint("antonym"))
+		assert.Equal(t, "http://localhost:17477", graphEndpoint("related"))
+	})
+	t.Run("sends relation with edges", func(t *testing.T) {
+		bodies := map[string]map[string]interface{}{}
+		for _, endpoint := range []string{dbEndpoint, "http://localhost:17476"} {
+			endpoint := endpoint
+			httpmock.RegisterResponder("POST", endpoint+"/edges?node=1",
+				func(req *http.Request) (*http.Response, error) {
+					body := map[string]interface{}{}
+					json.NewDecoder(req.Body).Decode(&body)
+					bodies[endpoint] = body
+					return httpmock.NewJsonResponse(200, map[string]interface{}{"neighborsAdded": []string{}})
+				},
+			)
+		}
+		_, err := AddNeighbors(util.WithRelation(context.Background(), "synonym"), 1, []int{2}, nil)
+		assert.Nil(t, err)
+		_, err = AddNeighbors(util.WithRelation(context.Background(), "antonym"), 1, []int{3}, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, map[string]interface{}{"neighbors": []interface{}{float64(2)}, "relation": "synonym"}, bodies[dbEndpoint])
+		assert.Equal(t, map[string]interface{}{"neighbors": []interface{}{float64(3)}, "relation": "antonym"}, bodies["http://localhost:17476"])
+	})
+	t.Run("untyped edges have no relation", func(t *testing.T) {
+		body := map[string]interface{}{}
+		httpmock.RegisterResponder("DELETE", dbEndpoint+"/edges?node=1",
+			func(req *http.Request) (*http.Response, error) {
+				json.NewDecoder(req.Body).Decode(&body)
+				return httpmock.NewJsonResponse(200, map[string]interface{}{})
+			},
+		)
+		assert.Nil(t, DeleteEdges(context.Background(), 1, []int{2}))
+		assert.NotContains(t, body, "relation")
+	})
+}
+
+func TestEdgeAttributes(t *testing.T) {
+	settings.graphDBEndpoint = dbEndpoint
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	bodies := []map[string]interface{}{}
+	httpmock.RegisterResponder("POST", dbEndpoint+"/edges?node=1",
+		func(req *http.Request) (*http.Response, error) {
+			body := map[string]interface{}{}
+			json.NewDecoder(req.Body).Decode(&body)
+			bodies = append(bodies, body)
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"neighborsAdded": []string{}})
+		},
+	)
+	_, err := AddNeighbors(context.Background(), 1, []int{2}, nil)
+	assert.Nil(t, err)
+	assert.NotContains(t, bodies[0], "attributes")
+	_, err = AddNeighbors(util.WithEdgeAttribute(context.Background(), "zone", "lead"), 1, []int{2}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"zone": "lead"}, bodies[1]["attributes"])
+	assert.NotContains(t, bodies[1], "nodeAttributes")
+	_, err = AddNeighbors(util.WithNodeAttribute(context.Background(), "disambiguation", "true"), 1, []int{2}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]interface{}{"disambiguation": "true"}, bodies[2]["nodeAttributes"])
+}
+
+func TestRemoveEdges(t *testing.T) {
+	settings.twoWayKVEndpoint = twoWayEndpoint
+	settings.graphDBEndpoint = dbEndpoint
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	cleanUrl := func(s string) string { return strings.TrimPrefix(s, "/wiki/") }
+	httpmock.RegisterResponder("POST", twoWayEndpoint+"/entries",
+		httpmock.NewStringResponder(200, `{"errors": [], "entries": [{"key": "test", "value": 1}, {"key": "test1", "value": 2}]}`))
+	deleted := map[string][]int{}
+	httpmock.RegisterResponder("DELETE", dbEndpoint+"/edges?node=1",
+		func(req *http.Request) (*http.Response, error) {
+			json.NewDecoder(req.Body).Decode(&deleted)
+			return httpmock.NewJsonResponse(200, map[string]interface{}{})
+		},
+	)
+	t.Run("removes edges by id", func(t *testing.T) {
+		assert.Nil(t, RemoveEdges(context.Background(), "/wiki/test", []string{"/wiki/test1"}, cleanUrl))
+		assert.Equal(t, []int{2}, deleted["neighbors"])
+	})
+	t.Run("keeps undirected edges of neighbors linking back", func(t *testing.T) {
+		defer func() { settings.edgeMode = "" }()
+		settings.edgeMode = Undirected
+		reverse := 0
+		httpmock.RegisterResponder("DELETE", dbEndpoint+"/edges?node=2",
+			func(req *http.Request) (*http.Response, error) {
+				reverse++
+				return httpmock.NewJsonResponse(200, map[string]interface{}{})
+			},
+		)
+		ctx := util.WithLinkedBack(context.Background(), []string{"/wiki/test1"})
+		assert.Nil(t, RemoveEdges(ctx, "/wiki/test", []string{"/wiki/test1"}, cleanUrl))
+		assert.Equal(t, 0, reverse)
+		assert.Nil(t, RemoveEdges(context.Background(), "/wiki/test", []string{"/wiki/test1"}, cleanUrl))
+		assert.Equal(t, 1, reverse)
+	})
+	t.Run("skips links which cannot be cleaned", func(t *testing.T) {
+		defer func(l func(context.Context, string, ...interface{})) { logErr = l }(logErr)
+		logged := []string{}
+		logErr = func(ctx context.Context, format string, args ...interface{}) {
+			logged = append(logged, fmt.Sprintf(format, args...))
+		}
+		var requested []string
+		httpmock.RegisterResponder("POST", twoWayEndpoint+"/entries",
+			func(req *http.Request) (*http.Response, error) {
+				json.NewDecoder(req.Body).Decode(&requested)
+				return httpmock.NewStringResponse(200, `{"errors": [], "entries": [{"key": "test", "value": 1}, {"key": "test1", "value": 2}]}`), nil
+			},
+		)
+		assert.Nil(t, RemoveEdges(context.Background(), "/wiki/test", []string{"/wiki/test1", "%zz"}, func(s string) string {
+			if strings.HasPrefix(s, "%") {
+				return ""
+			}
+			return cleanUrl(s)
+		}))
+		assert.Equal(t, []string{"test1", "test"}, requested)
+		assert.Equal(t, []string{"Could not clean link %zz"}, logged)
+	})
+	t.Run("fails when node is not found", func(t *testing.T) {
+		err := RemoveEdges(context.Background(), "/wiki/other", []string{"/wiki/test1"}, cleanUrl)
+		assert.EqualError(t, err, "Could not find node on reverse lookup")
+	})
 }
 
 func TestGetArticleIds(t *testing.T) {
-	os.Setenv("TWO_WAY_KV_ENDPOINT", twoWayEndpoint)
+	settings.twoWayKVEndpoint = twoWayEndpoint
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
 	type Test struct {
@@ -148,7 +364,7 @@ func TestGetArticleIds(t *testing.T) {
 	for _, test := range testTable {
 		t.Run(test.Name, func(t *testing.T) {
 			test.Setup()
-			resp, err := GetArticleIds(test.Articles)
+			resp, err := GetArticleIds(context.Background(), test.Articles)
 			if err != nil && test.ExpectedError != nil {
 				assert.Equal(t, test.ExpectedError.Error(), err.Error())
 			} else {
@@ -162,7 +378,7 @@ func TestGetArticleIds(t *testing.T) {
 
 func TestConnectToDB(t *testing.T) {
 	dbEndpoint := "http://localhost:17474"
-	os.Setenv("GRAPH_DB_ENDPOINT", dbEndpoint)
+	settings.graphDBEndpoint = dbEndpoint
 	t.Run("fails when db not found", func(t *testing.T) {
 		err := ConnectToDB()
 		assert.EqualError(t, err, "Get \"http://localhost:17474\": dial tcp [::1]:17474: connect: connection refused")
@@ -178,12 +394,88 @@ func TestConnectToDB(t *testing.T) {
 		err := ConnectToDB()
 		assert.Nil(t, err)
 	})
+	t.Run("fails when db responds with an error", func(t *testing.T) {
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", dbEndpoint,
+			httpmock.NewStringResponder(500, `down`))
+		assert.EqualError(t, ConnectToDB(), dbEndpoint+" responded with 500")
+	})
+}
+
+func TestConnectToKV(t *testing.T) {
+	settings.twoWayKVEndpoint = twoWayEndpoint
+	t.Run("fails when kv not found", func(t *testing.T) {
+		assert.Error(t, ConnectToKV())
+	})
+	t.Run("succeed when server exists", func(t *testing.T) {
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", twoWayEndpoint,
+			httpmock.NewStringResponder(200, `TEST`))
+		assert.Nil(t, ConnectToKV())
+	})
+}
+
+func TestCheckGraph(t *testing.T) {
+	settings.graphDBEndpoint = dbEndpoint
+	type Test struct {
+		Name          string
+		Status        int
+		Body          string
+		ExpectedError string
+	}
+	testTable := []Test{
+		Test{"succeeds on edges response", 200, `{"neighbors":[2,3]}`, ""},
+		Test{"succeeds on missing node", 404, `{"code":404,"error":"node 1 not found"}`, ""},
+		Test{"fails on 500 level code", 503, `{"code":503,"error":"unavailable"}`, `graph db responded with 503: {"code":503,"error":"unavailable"}`},
+		Test{"fails on other services", 404, `<html>not found</html>`, "graph db responded with 404: <html>not found</html>"},
+		Test{"fails on unexpected response", 200, `{"status":"ok"}`, `unexpected edges response: {"status":"ok"}`},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			httpmock.Activate()
+			defer httpmock.DeactivateAndReset()
+			httpmock.RegisterResponder("GET", dbEndpoint+"/edges?node=1",
+				httpmock.NewStringResponder(test.Status, test.Body))
+			err := CheckGraph()
+			if test.ExpectedError == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, test.ExpectedError)
+			}
+		})
+	}
+	t.Run("fails when graph db not found", func(t *testing.T) {
+		assert.Error(t, CheckGraph())
+	})
+}
+
+func TestCheckKV(t *testing.T) {
+	settings.twoWayKVEndpoint = twoWayEndpoint
+	t.Run("fails when kv not found", func(t *testing.T) {
+		assert.Error(t, CheckKV())
+	})
+	t.Run("fails on unexpected response", func(t *testing.T) {
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("POST", twoWayEndpoint+"/entries",
+			httpmock.NewStringResponder(200, `<html></html>`))
+		assert.Error(t, CheckKV())
+	})
+	t.Run("succeeds on lookup response", func(t *testing.T) {
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("POST", twoWayEndpoint+"/entries",
+			httpmock.NewStringResponder(200, `{"errors":[],"entries":[]}`))
+		assert.Nil(t, CheckKV())
+	})
 }
 
 func TestAddEdgesIfDoNotExist(t *testing.T) {
 	var baseEndpoint = "https://en.wikipedia.org"
-	os.Setenv("TWO_WAY_KV_ENDPOINT", twoWayEndpoint)
-	os.Setenv("GRAPH_DB_ENDPOINT", dbEndpoint)
+	settings.twoWayKVEndpoint = twoWayEndpoint
+	settings.graphDBEndpoint = dbEndpoint
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
 	type Test struct {
@@ -379,7 +671,7 @@ func TestAddEdgesIfDoNotExist(t *testing.T) {
 		// decode string
 		link, err := url.QueryUnescape(link)
 		if err != nil {
-			logErr("Could not decode string %s: %v", link, err)
+			logErr(context.Background(), "Could not decode string %s: %v", link, err)
 		}
 		return link
 	}
@@ -387,7 +679,7 @@ func TestAddEdgesIfDoNotExist(t *testing.T) {
 	for _, test := range testTable {
 		t.Run(test.Name, func(t *testing.T) {
 			test.Setup()
-			resp, err := AddEdgesIfDoNotExist(test.CurrNode, test.NeighborNodes, CleanUrl, baseEndpoint)
+			resp, err := AddEdgesIfDoNotExist(context.Background(), test.CurrNode, test.NeighborNodes, CleanUrl, baseEndpoint)
 			if err != nil && test.ExpectedError != nil {
 				assert.Equal(t, test.ExpectedError.Error(), err.Error())
 			} else {
@@ -398,3 +690,28 @@ func TestAddEdgesIfDoNotExist(t *testing.T) {
 		})
 	}
 }
+
+func TestTracePropagation(t *testing.T) {
+	settings.graphDBEndpoint = dbEndpoint
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	origProvider := otel.GetTracerProvider()
+	origPropagator := otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(origProvider)
+	defer otel.SetTextMapPropagator(origPropagator)
+
+	traceparent := ""
+	httpmock.RegisterResponder("POST", dbEndpoint+"/edges?node=1",
+		func(req *http.Request) (*http.Response, error) {
+			traceparent = req.Header.Get("traceparent")
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"neighborsAdded": []string{}})
+		},
+	)
+	ctx, span := otel.Tracer("test").Start(context.Background(), "page")
+	defer span.End()
+	_, err := AddNeighbors(ctx, 1, []int{2}, nil)
+	assert.NoError(t, err)
+	assert.Contains(t, traceparent, span.SpanContext().TraceID().String())
+}
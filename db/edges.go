@@ -0,0 +1,215 @@
+package db
+
+import (
+	"context"
+	"github.com/dgoldstein1/crawler/util"
+	"strconv"
+	"sync"
+)
+
+// ways edges can be written, set with EDGE_MODE
+const (
+	// only from a page to its links
+	Directed = "directed"
+	// from a page to its links and back
+	Undirected = "undirected"
+	// both ways, once both pages link to each other
+	Reciprocal = "reciprocal"
+)
+
+// marks requests for edges back from neighbors
+type reverseKey struct{}
+
+// target requests to the graph with ctx are observed as
+func graphTarget(ctx context.Context) string {
+	if reverse, _ := ctx.Value(reverseKey{}).(bool); reverse {
+		return "graph_reverse"
+	}
+	return "graph"
+}
+
+// claims and seen nodes kept at most, an arbitrary one is forgotten for
+// every one beyond that. forgotten claims are confirmed once both pages are
+// crawled again, forgotten nodes may be crawled again
+var maxClaims = 1000000
+
+// edge seen on only one of its pages
+type claimKey struct {
+	relation string
+	from     int
+	to       int
+}
+
+// edges seen on only one of their pages, by node ID
+type claimSet struct {
+	sync.Mutex
+	claims map[claimKey]bool
+	seen   map[int]bool
+}
+
+var claims = newClaimSet()
+
+func newClaimSet() *claimSet {
+	return &claimSet{
+		claims: make(map[claimKey]bool),
+		seen:   make(map[int]bool),
+	}
+}
+
+// records that from links to nodes with relation. returns the nodes which
+// link back to from, confirming the edge, and the nodes which were not seen
+// before. confirmed claims are kept until their edges are written.
+func (c *claimSet) claim(relation string, from int, to []int) (confirmed []int, unseen []int) {
+	c.Lock()
+	defer c.Unlock()
+	c.see(from)
+	for _, n := range to {
+		if c.claims[claimKey{relation, n, from}] {
+			confirmed = append(confirmed, n)
+		} else if n != from {
+			key := claimKey{relation, from, n}
+			if !c.claims[key] && len(c.claims) >= maxClaims {
+				for k := range c.claims {
+					delete(c.claims, k)
+					break
+				}
+			}
+			c.claims[key] = true
+		}
+		if !c.seen[n] {
+			unseen = append(unseen, n)
+			c.see(n)
+		}
+	}
+	return confirmed, unseen
+}
+
+// records that n was seen, keeping at most maxClaims nodes
+func (c *claimSet) see(n int) {
+	if !c.seen[n] && len(c.seen) >= maxClaims {
+		for k := range c.seen {
+			delete(c.seen, k)
+			break
+		}
+	}
+	c.seen[n] = true
+}
+
+// forgets claims of from to nodes with relation, which from no longer
+// links to
+func (c *claimSet) unclaim(relation string, from int, nodes []int) {
+	c.Lock()
+	defer c.Unlock()
+	for _, n := range nodes {
+		delete(c.claims, claimKey{relation, from, n})
+	}
+}
+
+// forgets claims of nodes to from with relation, once their edges are
+// written
+func (c *claimSet) confirm(relation string, from int, nodes []int) {
+	c.Lock()
+	defer c.Unlock()
+	for _, n := range nodes {
+		delete(c.claims, claimKey{relation, n, from})
+	}
+}
+
+// writes edges from curr to neighborIds according to EDGE_MODE. returns the
+// neighbors which are new to the graph, or in reciprocal mode, new to
+// this crawl. those are crawled to confirm their edges, the nodes new to
+// the graph are counted in ctx instead.
+func writeEdges(ctx context.Context, curr int, neighborIds []int, provenance *Provenance) (GraphResponseSuccess, error) {
+	switch settings.edgeMode {
+	case Undirected:
+		resp, err := AddNeighbors(ctx, curr, neighborIds, provenance)
+		if err != nil {
+			return resp, err
+		}
+		return resp, addReverseEdges(ctx, curr, neighborIds, provenance)
+	case Reciprocal:
+		resp := GraphResponseSuccess{NeighborsAdded: []string{}}
+		relation := util.Relation(ctx)
+		confirmed, unseen := claims.claim(relation, curr, neighborIds)
+		util.CountNodes(ctx, 0)
+		if len(confirmed) > 0 {
+			added, err := AddNeighbors(ctx, curr, confirmed, provenance)
+			if err != nil {
+				return resp, err
+			}
+			if err := addReverseEdges(ctx, curr, confirmed, provenance); err != nil {
+				return resp, err
+			}
+			// claims of failed writes are confirmed again on the next crawl
+			claims.confirm(relation, curr, confirmed)
+			util.CountNodes(ctx, len(added.NeighborsAdded))
+		}
+		// unconfirmed nodes still need to be crawled to confirm their edges
+		for _, n := range unseen {
+			resp.NeighborsAdded = append(resp.NeighborsAdded, strconv.Itoa(n))
+		}
+		return resp, nil
+	default:
+		return AddNeighbors(ctx, curr, neighborIds, provenance)
+	}
+}
+
+// deletes edges from curr to neighborIds according to EDGE_MODE. reciprocal
+// edges are deleted both ways, as they need both links. undirected edges
+// back from neighbors are deleted unless the neighbor is in linkedBack,
+// still linking to curr itself.
+func deleteEdges(ctx context.Context, curr int, neighborIds []int, linkedBack map[int]bool) error {
+	mode := settings.edgeMode
+	if mode == Reciprocal {
+		// unconfirmed edges were never written, only claimed
+		claims.unclaim(util.Relation(ctx), curr, neighborIds)
+	}
+	if err := DeleteEdges(ctx, curr, neighborIds); err != nil {
+		return err
+	}
+	switch mode {
+	case Reciprocal:
+		return deleteReverseEdges(ctx, curr, neighborIds)
+	case Undirected:
+		reverse := []int{}
+		for _, n := range neighborIds {
+			if !linkedBack[n] {
+				reverse = append(reverse, n)
+			}
+		}
+		return deleteReverseEdges(ctx, curr, reverse)
+	}
+	return nil
+}
+
+// deletes edges from each of neighborIds back to curr. the graph takes
+// edges of one node per request, so this costs a request per neighbor,
+// observed as "graph_reverse"
+func deleteReverseEdges(ctx context.Context, curr int, neighborIds []int) error {
+	ctx = context.WithValue(ctx, reverseKey{}, true)
+	for _, n := range neighborIds {
+		if n == curr {
+			continue
+		}
+		if err := DeleteEdges(ctx, n, []int{curr}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// adds edges from each of neighborIds back to curr, a request per neighbor
+// like deleteReverseEdges
+func addReverseEdges(ctx context.Context, curr int, neighborIds []int, provenance *Provenance) error {
+	// attributes of curr are not those of its neighbors
+	ctx = context.WithValue(util.WithoutNodeAttributes(ctx), reverseKey{}, true)
+	for _, n := range neighborIds {
+		if n == curr {
+			continue
+		}
+		if _, err := AddNeighbors(ctx, n, []int{curr}, provenance); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,201 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dgoldstein1/crawler/util"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestClaim(t *testing.T) {
+	c := newClaimSet()
+	confirmed, unseen := c.claim("", 1, []int{2, 3})
+	assert.Empty(t, confirmed)
+	assert.Equal(t, []int{2, 3}, unseen)
+	// 2 links back to 1, 3 is new
+	confirmed, unseen = c.claim("", 2, []int{1, 4, 2})
+	assert.Equal(t, []int{1}, confirmed)
+	assert.Equal(t, []int{4}, unseen)
+	// claims are kept until confirmed, then only confirmed once
+	confirmed, _ = c.claim("", 2, []int{1})
+	assert.Equal(t, []int{1}, confirmed)
+	c.confirm("", 2, confirmed)
+	confirmed, _ = c.claim("", 2, []int{1})
+	assert.Empty(t, confirmed)
+	confirmed, _ = c.claim("", 3, []int{1})
+	assert.Equal(t, []int{1}, confirmed)
+	// relations are confirmed separately
+	c.claim("antonym", 5, []int{6})
+	confirmed, _ = c.claim("synonym", 6, []int{5})
+	assert.Empty(t, confirmed)
+	confirmed, _ = c.claim("antonym", 6, []int{5})
+	assert.Equal(t, []int{5}, confirmed)
+}
+
+func TestClaimLimit(t *testing.T) {
+	defer func(max int) { maxClaims = max }(maxClaims)
+	maxClaims = 3
+	c := newClaimSet()
+	c.claim("", 1, []int{2, 3, 4, 5})
+	assert.Equal(t, 3, len(c.claims))
+	assert.Equal(t, 3, len(c.seen))
+}
+
+func TestWriteEdges(t *testing.T) {
+	settings.graphDBEndpoint = dbEndpoint
+	defer func() { settings.edgeMode = "" }()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	written := []string{}
+	httpmock.RegisterResponder("POST", `=~^`+dbEndpoint+`/edges`,
+		func(req *http.Request) (*http.Response, error) {
+			body := map[string][]int{}
+			json.NewDecoder(req.Body).Decode(&body)
+			for _, n := range body["neighbors"] {
+				written = append(written, fmt.Sprintf("%s->%d", req.URL.Query().Get("node"), n))
+			}
+			added := []string{}
+			for _, n := range body["neighbors"] {
+				added = append(added, fmt.Sprint(n))
+			}
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"neighborsAdded": added})
+		},
+	)
+
+	type Test struct {
+		Name          string
+		Mode          string
+		Pages         map[int][]int
+		ExpectedEdges []string
+	}
+	testTable := []Test{
+		Test{
+			Name:          "directed writes edges from page",
+			Mode:          "directed",
+			Pages:         map[int][]int{1: []int{2, 3}},
+			ExpectedEdges: []string{"1->2", "1->3"},
+		},
+		Test{
+			Name:          "undirected writes edges both ways",
+			Mode:          "undirected",
+			Pages:         map[int][]int{1: []int{2, 3}},
+			ExpectedEdges: []string{"1->2", "1->3", "2->1", "3->1"},
+		},
+		Test{
+			Name:          "reciprocal writes edges both pages confirm",
+			Mode:          "reciprocal",
+			Pages:         map[int][]int{1: []int{2, 3}, 2: []int{1, 4}},
+			ExpectedEdges: []string{"1->2", "2->1"},
+		},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			settings.edgeMode = test.Mode
+			claims = newClaimSet()
+			written = []string{}
+			for _, page := range []int{1, 2} {
+				if neighbors, ok := test.Pages[page]; ok {
+					_, err := writeEdges(context.Background(), page, neighbors, nil)
+					assert.Nil(t, err)
+				}
+			}
+			sort.Strings(written)
+			assert.Equal(t, test.ExpectedEdges, written)
+		})
+	}
+
+	t.Run("reciprocal returns unseen nodes to crawl", func(t *testing.T) {
+		settings.edgeMode = "reciprocal"
+		claims = newClaimSet()
+		ctx, count := util.WithNodeCount(context.Background())
+		resp, err := writeEdges(ctx, 1, []int{2, 3}, nil)
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"2", "3"}, resp.NeighborsAdded)
+		// unseen nodes are not in the graph yet
+		n, counted := count.Get()
+		assert.True(t, counted)
+		assert.Equal(t, 0, n)
+		resp, _ = writeEdges(ctx, 2, []int{1, 3, 4}, nil)
+		assert.Equal(t, []string{"4"}, resp.NeighborsAdded)
+		n, _ = count.Get()
+		assert.Equal(t, 1, n)
+	})
+
+	t.Run("reciprocal confirms claims again after failed writes", func(t *testing.T) {
+		settings.edgeMode = "reciprocal"
+		claims = newClaimSet()
+		writeEdges(context.Background(), 1, []int{2}, nil)
+		httpmock.RegisterResponder("POST", `=~^`+dbEndpoint+`/edges`,
+			httpmock.NewStringResponder(503, "unavailable"))
+		_, err := writeEdges(context.Background(), 2, []int{1}, nil)
+		assert.NotNil(t, err)
+		confirmed, _ := claims.claim("", 2, []int{1})
+		assert.Equal(t, []int{1}, confirmed)
+	})
+}
+
+func TestDeleteEdgesByMode(t *testing.T) {
+	settings.graphDBEndpoint = dbEndpoint
+	defer func() { settings.edgeMode = "" }()
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	deleted := []string{}
+	httpmock.RegisterResponder("DELETE", `=~^`+dbEndpoint+`/edges`,
+		func(req *http.Request) (*http.Response, error) {
+			body := map[string][]int{}
+			json.NewDecoder(req.Body).Decode(&body)
+			for _, n := range body["neighbors"] {
+				deleted = append(deleted, fmt.Sprintf("%s->%d", req.URL.Query().Get("node"), n))
+			}
+			return httpmock.NewJsonResponse(200, map[string]interface{}{})
+		},
+	)
+
+	type Test struct {
+		Name            string
+		Mode            string
+		LinkedBack      map[int]bool
+		ExpectedDeleted []string
+	}
+	testTable := []Test{
+		Test{"directed deletes edges from page", "directed", nil, []string{"1->2", "1->3"}},
+		Test{"undirected deletes edges both ways", "undirected", nil, []string{"1->2", "1->3", "2->1", "3->1"}},
+		Test{"undirected keeps edges of neighbors linking back", "undirected", map[int]bool{3: true}, []string{"1->2", "1->3", "2->1"}},
+		Test{"reciprocal deletes edges both ways", "reciprocal", map[int]bool{3: true}, []string{"1->2", "1->3", "2->1", "3->1"}},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			settings.edgeMode = test.Mode
+			deleted = []string{}
+			assert.Nil(t, deleteEdges(context.Background(), 1, []int{2, 3}, test.LinkedBack))
+			sort.Strings(deleted)
+			assert.Equal(t, test.ExpectedDeleted, deleted)
+		})
+	}
+
+	t.Run("reciprocal forgets claims of removed links", func(t *testing.T) {
+		settings.edgeMode = "reciprocal"
+		claims = newClaimSet()
+		claims.claim("", 1, []int{2})
+		assert.Nil(t, deleteEdges(context.Background(), 1, []int{2}, nil))
+		confirmed, _ := claims.claim("", 2, []int{1})
+		assert.Empty(t, confirmed)
+	})
+
+	t.Run("observes requests for edges back separately", func(t *testing.T) {
+		defer func(o func(string, time.Duration, int, error)) { ObserveRequest = o }(ObserveRequest)
+		targets := []string{}
+		ObserveRequest = func(target string, duration time.Duration, status int, err error) {
+			targets = append(targets, target)
+		}
+		settings.edgeMode = "undirected"
+		assert.Nil(t, deleteEdges(context.Background(), 1, []int{2, 3}, nil))
+		assert.Equal(t, []string{"graph", "graph_reverse", "graph_reverse"}, targets)
+	})
+}
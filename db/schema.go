@@ -1,5 +1,9 @@
 package db
 
+import (
+	"time"
+)
+
 type GraphResponseError struct {
 	Code  int    `json:"code"`
 	Error string `json:"error"`
@@ -9,6 +13,10 @@ type GraphResponseSuccess struct {
 	NeighborsAdded []string `json:"neighborsAdded"`
 }
 
+type GraphResponseNeighbors struct {
+	Neighbors []int `json:"neighbors"`
+}
+
 type TwoWayEntry struct {
 	Key   string `json:"key"`
 	Value int    `json:"value"`
@@ -18,3 +26,13 @@ type TwoWayResponse struct {
 	Errors  []string      `json:"errors"`
 	Entries []TwoWayEntry `json:"entries"`
 }
+
+// where an edge came from, sent with edges when EDGE_PROVENANCE is set.
+// every write sends the time it was made as both times, the graph db keeps
+// FirstSeen of edges it already has and replaces WrittenAt
+type Provenance struct {
+	RunID     string    `json:"runId"`
+	FirstSeen time.Time `json:"firstSeen"`
+	WrittenAt time.Time `json:"writtenAt"`
+	Source    string    `json:"source"`
+}
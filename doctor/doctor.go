@@ -0,0 +1,137 @@
+package doctor
+
+import (
+	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/db"
+	"github.com/dgoldstein1/crawler/util"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// result of a single preflight check
+type Check struct {
+	Name   string
+	Ok     bool
+	Detail string
+	// how to fix a failing check
+	Hint string
+}
+
+// runs all preflight checks for a crawl. loadErr is the error returned when
+// loading cfg, wordLists are env names of files the site needs. databases
+// are checked as configured with db.Configure.
+func Run(cfg config.Config, loadErr error, wordLists []string, cacheDir string) []Check {
+	checks := []Check{checkConfig(loadErr)}
+	// endpoints can only be checked if they are set
+	if cfg.GraphDBEndpoint != "" {
+		checks = append(checks, check(
+			"graph db reachable",
+			db.CheckGraph(),
+			"is GRAPH_DB_ENDPOINT correct and a graph db running there?",
+		))
+	}
+	if cfg.TwoWayKVEndpoint != "" {
+		checks = append(checks, check(
+			"two way kv reachable",
+			db.CheckKV(),
+			"is TWO_WAY_KV_ENDPOINT correct and twowaykv running?",
+		))
+	}
+	for _, envName := range wordLists {
+		checks = append(checks, check(
+			envName+" readable",
+			util.CheckFile(envName, cfg.Get(envName)),
+			fmt.Sprintf("set %s to a non-empty file with one entry per line", envName),
+		))
+	}
+	if cfg.MetricsPort != "" {
+		checks = append(checks, check(
+			"metrics port free",
+			checkPortFree(cfg.MetricsPort),
+			"stop the process using METRICS_PORT or choose another port",
+		))
+	}
+	checks = append(checks, check(
+		"cache dir writable",
+		checkWritable(cacheDir),
+		fmt.Sprintf("make sure %s is a writable directory", cacheDir),
+	))
+	return checks
+}
+
+// builds check from err
+func check(name string, err error, hint string) Check {
+	if err != nil {
+		return Check{Name: name, Ok: false, Detail: err.Error(), Hint: hint}
+	}
+	return Check{Name: name, Ok: true}
+}
+
+// config loaded without problems
+func checkConfig(loadErr error) Check {
+	c := Check{Name: "config valid", Ok: true}
+	if loadErr == nil {
+		return c
+	}
+	c.Ok = false
+	c.Detail = loadErr.Error()
+	if problems, ok := loadErr.(config.Errors); ok {
+		c.Detail = strings.Join(problems, ", ")
+	}
+	c.Hint = "fix the listed settings, see 'crawler config print'"
+	return c
+}
+
+// port can be listened on
+func checkPortFree(port string) error {
+	l, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		return err
+	}
+	return l.Close()
+}
+
+// dir exists (or can be created) and files can be written to it
+func checkWritable(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, "doctor")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// true if all checks passed
+func Passed(checks []Check) bool {
+	for _, c := range checks {
+		if !c.Ok {
+			return false
+		}
+	}
+	return true
+}
+
+// prints pass / fail table with remediation hints
+func Print(out io.Writer, checks []Check) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "CHECK\tRESULT\tDETAIL\n")
+	for _, c := range checks {
+		result := "PASS"
+		if !c.Ok {
+			result = "FAIL"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, result, c.Detail)
+		if !c.Ok && c.Hint != "" {
+			fmt.Fprintf(w, "\t\thint: %s\n", c.Hint)
+		}
+	}
+	w.Flush()
+}
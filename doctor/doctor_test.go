@@ -0,0 +1,89 @@
+package doctor
+
+import (
+	"bytes"
+	"errors"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	t.Run("passes without load error", func(t *testing.T) {
+		assert.Equal(t, Check{Name: "config valid", Ok: true}, checkConfig(nil))
+	})
+	t.Run("lists all problems", func(t *testing.T) {
+		c := checkConfig(config.Errors{"'GRAPH_DB_ENDPOINT' was not set", "PARALLELISM must be greater than 0 but was '0'"})
+		assert.False(t, c.Ok)
+		assert.Equal(t, "'GRAPH_DB_ENDPOINT' was not set, PARALLELISM must be greater than 0 but was '0'", c.Detail)
+		assert.NotEmpty(t, c.Hint)
+	})
+	t.Run("uses other errors as is", func(t *testing.T) {
+		c := checkConfig(errors.New("bad"))
+		assert.False(t, c.Ok)
+		assert.Equal(t, "bad", c.Detail)
+	})
+}
+
+func TestCheckPortFree(t *testing.T) {
+	l, err := net.Listen("tcp", ":0")
+	assert.Nil(t, err)
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	assert.Error(t, checkPortFree(port))
+	l.Close()
+	assert.Nil(t, checkPortFree(port))
+}
+
+func TestCheckWritable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "doctor")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	t.Run("creates missing dir", func(t *testing.T) {
+		assert.Nil(t, checkWritable(filepath.Join(dir, "cache")))
+		files, _ := ioutil.ReadDir(filepath.Join(dir, "cache"))
+		assert.Empty(t, files)
+	})
+	t.Run("fails when path is a file", func(t *testing.T) {
+		f := filepath.Join(dir, "file")
+		ioutil.WriteFile(f, []byte("x"), 0644)
+		assert.Error(t, checkWritable(f))
+	})
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "doctor")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	checks := Run(config.Config{}, config.Errors{"'GRAPH_DB_ENDPOINT' was not set"}, []string{"COUNTIES_LIST"}, dir)
+	names := []string{}
+	for _, c := range checks {
+		names = append(names, c.Name)
+	}
+	// db and port checks are skipped when not configured
+	assert.Equal(t, []string{"config valid", "COUNTIES_LIST readable", "cache dir writable"}, names)
+	assert.False(t, checks[0].Ok)
+	assert.False(t, checks[1].Ok)
+	assert.True(t, checks[2].Ok)
+	assert.False(t, Passed(checks))
+	assert.True(t, Passed(checks[2:]))
+}
+
+func TestPrint(t *testing.T) {
+	out := &bytes.Buffer{}
+	Print(out, []Check{
+		{Name: "config valid", Ok: true},
+		{Name: "graph db reachable", Ok: false, Detail: "connection refused", Hint: "start it"},
+	})
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, 4, len(lines))
+	assert.True(t, strings.HasPrefix(lines[0], "CHECK"))
+	assert.Contains(t, lines[1], "PASS")
+	assert.Contains(t, lines[2], "FAIL")
+	assert.Contains(t, lines[2], "connection refused")
+	assert.Contains(t, lines[3], "hint: start it")
+}
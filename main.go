@@ -1,71 +1,367 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/dgoldstein1/crawler/ar_synonyms"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/coordinator"
 	"github.com/dgoldstein1/crawler/counties"
 	"github.com/dgoldstein1/crawler/crawler"
 	db "github.com/dgoldstein1/crawler/db"
+	"github.com/dgoldstein1/crawler/doctor"
 	syn "github.com/dgoldstein1/crawler/synonyms"
+	"github.com/dgoldstein1/crawler/util"
 	wiki "github.com/dgoldstein1/crawler/wikipedia"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli"
+	"io"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
 )
 
-// checks environment for required env vars
+// functions and files a site is crawled with
+type site struct {
+	isValidCrawlLink      crawler.IsValidCrawlLinkFunction
+	addEdgeIfDoesNotExist crawler.AddEdgeFunction
+	getNewNode            crawler.GetNewNodeFunction
+	filterPage            crawler.FilterPageFunction
+	cleanUrl              crawler.CleanUrlFunction
+	// base URL of the site, once configured
+	baseEndpoint func() string
+	// env names of files the site needs
+	wordLists []string
+	// relations which can be crawled as typed edges
+	relations []crawler.Relation
+	// applies site specific config before crawling, may be nil
+	configure func(config.Config)
+}
+
+// sites by command name
+var sites = map[string]site{
+	"wikipedia": site{
+		wiki.IsValidCrawlLink,
+		wiki.AddEdgesIfDoNotExist,
+		wiki.GetRandomNode,
+		wiki.FilterPage,
+		wiki.CleanUrl,
+		wiki.BaseEndpoint,
+		nil,
+		nil,
+		wiki.Configure,
+	},
+	"wikipedia-categories": site{
+		wiki.IsValidCategoryLink,
+		wiki.AddCategoryEdges,
+		wiki.GetRandomCategory,
+		wiki.FilterCategoryPage,
+		wiki.CleanCategoryUrl,
+		wiki.BaseEndpoint,
+		nil,
+		nil,
+		wiki.ConfigureCategories,
+	},
+	"synonyms": site{
+		syn.IsValidCrawlLink,
+		syn.AddEdgesIfDoNotExist,
+		syn.GetRandomNode,
+		syn.FilterPage,
+		syn.CleanUrl,
+		syn.BaseEndpoint,
+		[]string{"ENGLISH_WORD_LIST_PATH"},
+		syn.Relations,
+		syn.Configure,
+	},
+	"synonyms-ar": site{
+		ar_synonyms.IsValidCrawlLink,
+		ar_synonyms.AddEdgesIfDoNotExist,
+		ar_synonyms.GetRandomNode,
+		ar_synonyms.FilterPage,
+		ar_synonyms.CleanUrl,
+		ar_synonyms.BaseEndpoint,
+		[]string{"ARABIC_WORD_LIST_PATH"},
+		nil,
+		ar_synonyms.Configure,
+	},
+	"us_counties": site{
+		counties.IsValidCrawlLink,
+		counties.AddEdgesIfDoNotExist,
+		counties.GetRandomNode,
+		counties.FilterPage,
+		counties.CleanUrl,
+		counties.BaseEndpoint,
+		[]string{"COUNTIES_LIST"},
+		nil,
+		counties.Configure,
+	},
+}
+
+// site named by the first argument, which may be an alias
+func siteFromArgs(c *cli.Context) (string, site, error) {
+	var cmd *cli.Command
+	// subcommands run in their own app, sites are commands of the root app
+	for ctx := c; ctx != nil && cmd == nil; ctx = ctx.Parent() {
+		cmd = ctx.App.Command(c.Args().First())
+	}
+	if cmd == nil {
+		return "", site{}, fmt.Errorf("unknown site '%s'", c.Args().First())
+	}
+	s, ok := sites[cmd.Name]
+	if !ok {
+		return "", site{}, fmt.Errorf("'%s' is not a site", cmd.Name)
+	}
+	return cmd.Name, s, nil
+}
+
 var logFatalf = log.Fatalf
 var logMsg = log.Infof
 
-func parseEnv() {
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp: true,
-	})
-	requiredEnvs := []string{
-		"GRAPH_DB_ENDPOINT",
-		"MAX_APPROX_NODES",
-		"TWO_WAY_KV_ENDPOINT",
-	}
-	for _, v := range requiredEnvs {
-		if os.Getenv(v) == "" {
-			logFatalf("'%s' was not set", v)
-		} else {
-			// print out config
-			logMsg("%s=%s", v, os.Getenv(v))
-		}
+// loads and validates config for site, reporting all problems at once
+func loadConfig(path string, site string, flags map[string]string) config.Config {
+	cfg, err := config.LoadForSite(path, site, flags)
+	if problems, ok := err.(config.Errors); ok {
+		logFatalf("Invalid configuration:\n  - %s", strings.Join(problems, "\n  - "))
+		return cfg
 	}
-	numberVars := []string{"MAX_APPROX_NODES", "PARALLELISM", "MS_DELAY"}
-	for _, e := range numberVars {
-		i, err := strconv.Atoi(os.Getenv(e))
-		if err != nil {
-			logFatalf("Could not parse %s for env variable %s. Reccieve: %v", e, os.Getenv(e), err.Error())
-		}
-		if i < 1 && i != -1 {
-			logFatalf("%s must be greater than 1 but was '%i'", e, i)
+	if err := util.ConfigureLogging(
+		cfg.LogLevel,
+		cfg.LogFormat,
+		time.Duration(cfg.LogSampleIntervalSec)*time.Second,
+	); err != nil {
+		logFatalf("Could not configure logging: %v", err)
+	}
+	// print out config
+	redacted := cfg.Redacted()
+	for _, f := range redacted.Fields() {
+		logMsg("%s=%s", f.Env, f.String())
+	}
+	return cfg
+}
+
+// values of config flags explicitly set on the command line, before or
+// after the command
+func setFlags(c *cli.Context) map[string]string {
+	flags := make(map[string]string)
+	cfg := config.Default()
+	for _, f := range cfg.Fields() {
+		if v, ok := flagValue(c, f.Flag); ok {
+			flags[f.Flag] = v
 		}
+	}
+	return flags
+}
 
+// value of flag name given to the command, or to crawler before it
+func flagValue(c *cli.Context, name string) (string, bool) {
+	if c.IsSet(name) {
+		return c.String(name), true
+	}
+	if c.GlobalIsSet(name) {
+		return c.GlobalString(name), true
 	}
+	return "", false
 }
 
-// runs crawler with given functions
-func runCrawler(
-	isValidCrawlLink crawler.IsValidCrawlLinkFunction,
-	addEdgeIfDoesNotExist crawler.AddEdgeFunction,
-	getNewNode crawler.GetNewNodeFunction,
-	filterPage crawler.FilterPageFunction,
-) {
-	// assert environment
-	parseEnv()
-	// crawl with passed args
-	crawler.ServeMetrics()
+// path of the config file given with --config, "" if there is none
+func configPath(c *cli.Context) string {
+	path, _ := flagValue(c, "config")
+	return path
+}
+
+// like setFlags, including options of site commands which are shortcuts
+// for config flags
+func crawlFlags(c *cli.Context) map[string]string {
+	flags := setFlags(c)
+	if c.Bool("antonyms") {
+		flags["relations"] = "synonym,antonym"
+	}
+	if c.IsSet("lang") {
+		flags["wikipedia-lang"] = c.String("lang")
+	}
+	if c.Bool("api") {
+		flags["wikipedia-links"] = "api"
+	}
+	return flags
+}
+
+// flags of a command loading config, or of crawler if there are no
+// commandFlags, followed by flags for every config option
+func configFlags(commandFlags ...cli.Flag) []cli.Flag {
+	flags := append(commandFlags, []cli.Flag{
+		cli.StringFlag{
+			Name:  "config",
+			Usage: "path to JSON config file, overrides env",
+		},
+	}...)
+	cfg := config.Default()
+	for _, f := range cfg.Fields() {
+		flags = append(flags, cli.StringFlag{
+			Name:  f.Flag,
+			Usage: fmt.Sprintf("%s (env %s)", f.Usage, f.Env),
+		})
+	}
+	return flags
+}
+
+// prints effective config with secrets redacted
+func printConfig(out io.Writer, cfg config.Config) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	redacted := cfg.Redacted()
+	for _, f := range redacted.Fields() {
+		fmt.Fprintf(w, "%s\t%s\n", f.Env, f.String())
+	}
+	w.Flush()
+}
+
+// runs crawler for the site named name, as a worker if a coordinator is configured
+func runCrawler(c *cli.Context, name string) {
+	s := sites[name]
+	cfg, flushTracing := startCrawl(c, name)
+	defer flushTracing()
+	if cfg.CoordinatorAddr != "" {
+		crawler.RunWorker(
+			cfg,
+			s.isValidCrawlLink,
+			db.ConnectToDB,
+			s.addEdgeIfDoesNotExist,
+			s.filterPage,
+		)
+		return
+	}
 	crawler.Run(
-		os.Getenv("STARTING_ENDPOINT"),
-		isValidCrawlLink,
+		cfg,
+		s.isValidCrawlLink,
+		db.ConnectToDB,
+		s.addEdgeIfDoesNotExist,
+		s.getNewNode,
+		s.filterPage,
+	)
+}
+
+// revisits stale pages of the site, updating their edges
+func runRecrawl(c *cli.Context) error {
+	name, s, err := siteFromArgs(c)
+	if err != nil {
+		return err
+	}
+	cfg, flushTracing := startCrawl(c, name)
+	defer flushTracing()
+	crawler.Recrawl(
+		cfg,
+		s.isValidCrawlLink,
 		db.ConnectToDB,
-		addEdgeIfDoesNotExist,
-		getNewNode,
-		filterPage,
+		s.addEdgeIfDoesNotExist,
+		func(ctx context.Context, node string, neighbors []string) error {
+			return db.RemoveEdges(ctx, node, neighbors, s.cleanUrl)
+		},
+		s.filterPage,
 	)
+	return nil
+}
+
+// loads config and starts serving metrics and tracing for a crawl of the
+// site named name. returns config and a function flushing traces.
+func startCrawl(c *cli.Context, name string) (config.Config, func()) {
+	s := sites[name]
+	// assert config
+	cfg := loadConfig(configPath(c), name, crawlFlags(c))
+	// every crawl gets an ID, recorded in its report and with its edges
+	if cfg.RunID == "" {
+		cfg.RunID = crawler.NewRunID()
+	}
+	logMsg("run ID: %s", cfg.RunID)
+	// crawl with passed args
+	crawler.SetSite(name)
+	crawler.SetRunID(cfg.RunID)
+	db.Configure(cfg)
+	util.SetLogSite(name)
+	crawler.SetNodeKey(s.cleanUrl)
+	if err := crawler.SetRelations(s.relations, cfg.Relations); err != nil {
+		logFatalf("Invalid configuration: RELATIONS %v", err)
+	}
+	if s.configure != nil {
+		s.configure(cfg)
+	}
+	crawler.SetSiteHost(s.baseEndpoint())
+	db.ObserveRequest = crawler.ObserveDBRequest
+	crawler.AddReadinessCheck("graph", db.ConnectToDB)
+	crawler.AddReadinessCheck("twowaykv", db.ConnectToKV)
+	for _, envName := range s.wordLists {
+		envName := envName
+		crawler.AddReadinessCheck(envName, func() error {
+			return util.CheckFile(envName, cfg.Get(envName))
+		})
+	}
+	crawler.ServeMetrics(cfg)
+	flushTracing, err := crawler.InitTracing(cfg)
+	if err != nil {
+		logFatalf("Could not initialize tracing: %v", err)
+	}
+	return cfg, flushTracing
+}
+
+// writes links of the wikipedia dump files given as options to the graph,
+// with the same keys and edges a crawl of wikipedia would write
+func runImport(c *cli.Context) error {
+	dump := wiki.SQLDump{
+		Page:       c.String("page"),
+		PageLinks:  c.String("pagelinks"),
+		LinkTarget: c.String("linktarget"),
+	}
+	xmlDump := c.String("xml")
+	if xmlDump == "" && (dump.Page == "" || dump.PageLinks == "") {
+		return fmt.Errorf("either --xml or --page and --pagelinks must be set")
+	}
+	cfg := loadConfig(configPath(c), "wikipedia", crawlFlags(c))
+	if cfg.RunID == "" {
+		cfg.RunID = crawler.NewRunID()
+	}
+	logMsg("run ID: %s", cfg.RunID)
+	db.Configure(cfg)
+	util.SetLogSite("wikipedia")
+	wiki.SetLang(cfg.WikipediaLang)
+	if err := db.ConnectToDB(); err != nil {
+		return fmt.Errorf("could not connect to db: %v", err)
+	}
+	ctx := context.Background()
+	var stats wiki.ImportStats
+	var err error
+	if xmlDump != "" {
+		stats, err = wiki.ImportXMLDump(ctx, xmlDump, wiki.AddEdgesIfDoNotExist)
+	} else {
+		stats, err = wiki.ImportSQLDump(ctx, dump, wiki.AddEdgesIfDoNotExist)
+	}
+	logMsg("imported %d pages, submitted %d edges, %d pages failed", stats.Pages, stats.EdgesSubmitted, stats.Failed)
+	return err
+}
+
+// hands out URLs of the site to workers until the crawl is done
+func runCoordinator(c *cli.Context) error {
+	name, s, err := siteFromArgs(c)
+	if err != nil {
+		return err
+	}
+	cfg := loadConfig(configPath(c), name, setFlags(c))
+	util.SetLogSite(name)
+	// seeds are checked against deny lists by node key
+	crawler.SetNodeKey(s.cleanUrl)
+	if s.configure != nil {
+		s.configure(cfg)
+	}
+	crawler.SetSiteHost(s.baseEndpoint())
+	seeds, err := crawler.Seeds(cfg, s.isValidCrawlLink, s.getNewNode)
+	if err != nil {
+		return err
+	}
+	leaseDuration := time.Duration(cfg.LeaseSeconds) * time.Second
+	coord := coordinator.New(seeds, cfg.MaxApproxNodes, leaseDuration)
+	coord.LimitDepth(cfg.MaxDepth)
+	// keep answering after the crawl is done so polling workers stop
+	return coordinator.Serve(coord, cfg.CoordinatorPort, 2*leaseDuration)
 }
 
 func main() {
@@ -74,18 +370,39 @@ func main() {
 	app.Usage = " acustomizable web crawler script for different websites"
 	app.Description = "web crawl different URLs and add similar urls to a graph database"
 	app.Version = "1.4.1"
+	app.Flags = configFlags()
 	app.Commands = []cli.Command{
 		{
 			Name:    "wikipedia",
 			Aliases: []string{"w"},
 			Usage:   "crawl on wikipedia articles",
+			Flags: configFlags(
+				cli.StringFlag{
+					Name:  "lang",
+					Usage: "language of wikipedia to crawl, e.g. 'de' (same as --wikipedia-lang)",
+				},
+				cli.BoolFlag{
+					Name:  "api",
+					Usage: "read links from the MediaWiki API instead of article HTML (same as --wikipedia-links api)",
+				},
+			),
+			Action: func(c *cli.Context) error {
+				runCrawler(c, c.Command.Name)
+				return nil
+			},
+		},
+		{
+			Name:    "wikipedia-categories",
+			Aliases: []string{"categories"},
+			Usage:   "crawl on wikipedia categories, adding subcategory and article edges",
+			Flags: configFlags(
+				cli.StringFlag{
+					Name:  "lang",
+					Usage: "language of wikipedia to crawl, e.g. 'de' (same as --wikipedia-lang)",
+				},
+			),
 			Action: func(c *cli.Context) error {
-				runCrawler(
-					wiki.IsValidCrawlLink,
-					wiki.AddEdgesIfDoNotExist,
-					wiki.GetRandomNode,
-					wiki.FilterPage,
-				)
+				runCrawler(c, c.Command.Name)
 				return nil
 			},
 		},
@@ -93,13 +410,14 @@ func main() {
 			Name:    "synonyms",
 			Aliases: []string{"s"},
 			Usage:   "crawl on synonyms.com",
+			Flags: configFlags(
+				cli.BoolFlag{
+					Name:  "antonyms",
+					Usage: "also crawl antonyms, as typed edges (same as --relations synonym,antonym)",
+				},
+			),
 			Action: func(c *cli.Context) error {
-				runCrawler(
-					syn.IsValidCrawlLink,
-					syn.AddEdgesIfDoNotExist,
-					syn.GetRandomNode,
-					syn.FilterPage,
-				)
+				runCrawler(c, c.Command.Name)
 				return nil
 			},
 		},
@@ -107,13 +425,9 @@ func main() {
 			Name:    "synonyms-ar",
 			Aliases: []string{"ar"},
 			Usage:   "crawl on https://synonyms.reverso.net/synonym/ar/",
+			Flags:   configFlags(),
 			Action: func(c *cli.Context) error {
-				runCrawler(
-					ar_synonyms.IsValidCrawlLink,
-					ar_synonyms.AddEdgesIfDoNotExist,
-					ar_synonyms.GetRandomNode,
-					ar_synonyms.FilterPage,
-				)
+				runCrawler(c, c.Command.Name)
 				return nil
 			},
 		},
@@ -121,13 +435,117 @@ func main() {
 			Name:    "us_counties",
 			Aliases: []string{"counties"},
 			Usage:   "crawl on 'Adjacent counties' from wikipedia",
+			Flags:   configFlags(),
 			Action: func(c *cli.Context) error {
-				runCrawler(
-					counties.IsValidCrawlLink,
-					counties.AddEdgesIfDoNotExist,
-					counties.GetRandomNode,
-					counties.FilterPage,
-				)
+				runCrawler(c, c.Command.Name)
+				return nil
+			},
+		},
+		{
+			Name:  "import-wikipedia-dump",
+			Usage: "write links from a local wikipedia SQL or XML dump to the graph, as a crawl would",
+			Flags: configFlags(
+				cli.StringFlag{
+					Name:  "page",
+					Usage: "page table SQL dump, e.g. enwiki-latest-page.sql.gz",
+				},
+				cli.StringFlag{
+					Name:  "pagelinks",
+					Usage: "pagelinks table SQL dump, e.g. enwiki-latest-pagelinks.sql.gz",
+				},
+				cli.StringFlag{
+					Name:  "linktarget",
+					Usage: "linktarget table SQL dump, needed if pagelinks has no titles",
+				},
+				cli.StringFlag{
+					Name:  "xml",
+					Usage: "pages-articles XML dump, read instead of SQL dumps, e.g. enwiki-latest-pages-articles.xml.bz2",
+				},
+				cli.StringFlag{
+					Name:  "lang",
+					Usage: "language of the wikipedia the dump is from, e.g. 'de' (same as --wikipedia-lang)",
+				},
+			),
+			Action: runImport,
+		},
+		{
+			Name:      "coordinate",
+			Usage:     "hand out URLs of a site to workers started with --coordinator-addr",
+			ArgsUsage: "<site>",
+			Flags:     configFlags(),
+			Action:    runCoordinator,
+		},
+		{
+			Name:      "recrawl",
+			Usage:     "revisit pages crawled more than RECRAWL_TTL_SEC ago, adding and removing edges as their links changed",
+			ArgsUsage: "<site>",
+			Flags:     configFlags(),
+			Action:    runRecrawl,
+		},
+		{
+			Name:      "doctor",
+			Usage:     "check config, databases, word lists and ports before crawling",
+			ArgsUsage: "<site>",
+			Flags:     configFlags(),
+			Action: func(c *cli.Context) error {
+				name, s, err := siteFromArgs(c)
+				if err != nil {
+					return err
+				}
+				cfg, err := config.LoadForSite(configPath(c), name, setFlags(c))
+				db.Configure(cfg)
+				checks := doctor.Run(cfg, err, s.wordLists, crawler.CacheDir)
+				doctor.Print(os.Stdout, checks)
+				if !doctor.Passed(checks) {
+					return cli.NewExitError("", 1)
+				}
+				return nil
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "inspect configuration",
+			Subcommands: []cli.Command{
+				{
+					Name:      "print",
+					Usage:     "print effective config from flags, config file and env with secrets redacted",
+					ArgsUsage: "[site]",
+					Flags:     configFlags(),
+					Action: func(c *cli.Context) error {
+						name := ""
+						if c.NArg() > 0 {
+							n, _, err := siteFromArgs(c)
+							if err != nil {
+								return err
+							}
+							name = n
+						}
+						cfg, err := config.LoadForSite(configPath(c), name, setFlags(c))
+						printConfig(os.Stdout, cfg)
+						if problems, ok := err.(config.Errors); ok {
+							fmt.Fprintf(os.Stderr, "\nproblems:\n  - %s\n", strings.Join(problems, "\n  - "))
+						}
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "status",
+			Usage: "print live progress of a running crawler",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "addr",
+					Value: "localhost:8001",
+					Usage: "address of the crawler's metrics server",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				s, err := crawler.FetchStatus(c.String("addr"))
+				if err != nil {
+					return err
+				}
+				printStatus(os.Stdout, s)
 				return nil
 			},
 		},
@@ -139,3 +557,35 @@ func main() {
 	}
 
 }
+
+// pretty prints crawl status
+func printStatus(out io.Writer, s crawler.Status) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	budget := strconv.Itoa(int(s.NodesBudget))
+	if s.NodesBudget == -1 {
+		budget = "unlimited"
+	}
+	fmt.Fprintf(w, "site:\t%s\n", s.Site)
+	fmt.Fprintf(w, "run ID:\t%s\n", s.RunID)
+	fmt.Fprintf(w, "started:\t%s\n", s.StartTime.Format(time.RFC3339))
+	seeds := strings.Join(s.Seeds, ", ")
+	if s.SeedCount > len(s.Seeds) {
+		seeds = fmt.Sprintf("%s, ... (%d total)", seeds, s.SeedCount)
+	}
+	fmt.Fprintf(w, "seeds:\t%s\n", seeds)
+	fmt.Fprintf(w, "pages visited:\t%d\n", s.PagesVisited)
+	fmt.Fprintf(w, "nodes added:\t%d / %s\n", s.NodesAdded, budget)
+	fmt.Fprintf(w, "frontier:\t%d\n", s.FrontierSize)
+	fmt.Fprintf(w, "in flight:\t%d\n", s.InFlightRequests)
+	fmt.Fprintf(w, "max depth:\t%d\n", s.MaxDepth)
+	stages := []string{}
+	for stage := range s.RecentErrors {
+		stages = append(stages, stage)
+	}
+	sort.Strings(stages)
+	for _, stage := range stages {
+		fmt.Fprintf(w, "recent %s errors:\t%d\n", stage, s.RecentErrors[stage])
+	}
+	fmt.Fprintf(w, "time to budget:\t%s\n", s.EstimatedTimeLeft)
+	w.Flush()
+}
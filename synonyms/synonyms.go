@@ -1,35 +1,45 @@
 package synonyms
 
 import (
+	"context"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/crawler"
 	"github.com/dgoldstein1/crawler/db"
 	"github.com/dgoldstein1/crawler/util"
 	"github.com/gocolly/colly"
-	log "github.com/sirupsen/logrus"
-	"net/url"
 	"strings"
 	"time"
 )
 
 // globals
-var logErr = log.Errorf
 var prefix = "/synonym/"
 var baseEndpoint = "http://www.synonyms.com"
 var timeout = time.Duration(5 * time.Second)
 
+// random nodes are read from this file, see Configure
+var wordListPath = ""
+
+// base URL of the site
+func BaseEndpoint() string {
+	return baseEndpoint
+}
+
+// reads random words from cfg.EnglishWordListPath
+func Configure(cfg config.Config) {
+	wordListPath = cfg.EnglishWordListPath
+}
+
 // determines if is good link to crawl on
 func IsValidCrawlLink(link string) bool {
 	validPrefix := strings.HasPrefix(link, prefix)
 	noillegalChars := !strings.Contains(link, ":") && !strings.Contains(link, "#")
-	valid := validPrefix && noillegalChars
-	if !valid {
-		logErr("invalid link found %s. validPrefix : %v, noillegalChars: %v", link, validPrefix, noillegalChars)
-	}
-	return valid
+	return validPrefix && noillegalChars
 }
 
 func GetRandomNode() (string, error) {
 	return util.ReadRandomLineFromFile(
 		"ENGLISH_WORD_LIST_PATH",
+		wordListPath,
 		baseEndpoint,
 		prefix,
 		true,
@@ -46,12 +56,14 @@ func CleanUrl(link string) string {
 	link = strings.TrimPrefix(link, prefix)
 	link = strings.ToLower(link)
 	link = strings.ReplaceAll(link, "_", " ")
-	// decode string
-	link, err := url.QueryUnescape(link)
-	if err != nil {
-		logErr("Could not decode string %s: %v", link, err)
-	}
-	return link
+	// decode string, "" if it cannot be decoded
+	return util.UnescapeKey(link)
+}
+
+// relations listed on a word's page, crawled as typed edges with RELATIONS
+var Relations = []crawler.Relation{
+	{Label: "synonym", Selector: ".syns"},
+	{Label: "antonym", Selector: ".ants"},
 }
 
 // filters down full page body to elements we want to focus on
@@ -62,6 +74,7 @@ func FilterPage(e *colly.HTMLElement) (*colly.HTMLElement, error) {
 
 // adds edge to DB, returns new neighbors added (to crawl on)
 func AddEdgesIfDoNotExist(
+	ctx context.Context,
 	currentNode string,
 	neighborNodes []string,
 ) (
@@ -69,6 +82,7 @@ func AddEdgesIfDoNotExist(
 	err error,
 ) {
 	return db.AddEdgesIfDoNotExist(
+		ctx,
 		currentNode,
 		neighborNodes,
 		CleanUrl,
@@ -1,12 +1,11 @@
 package synonyms
 
 import (
-	"fmt"
+	"context"
 	"github.com/PuerkitoBio/goquery"
 	"github.com/gocolly/colly"
 	"github.com/stretchr/testify/assert"
 	"net/http"
-	"os"
 	"testing"
 )
 
@@ -69,15 +68,6 @@ func TestCleanURL(t *testing.T) {
 }
 
 func TestGetRandomNode(t *testing.T) {
-	errorsLogged := []string{}
-	logErr = func(format string, args ...interface{}) {
-		if len(args) > 0 {
-			errorsLogged = append(errorsLogged, fmt.Sprintf(format, args))
-		} else {
-			errorsLogged = append(errorsLogged, format)
-		}
-	}
-
 	type Test struct {
 		Name          string
 		ExpectedError string
@@ -91,7 +81,7 @@ func TestGetRandomNode(t *testing.T) {
 			Name:          "gets random word succesfully",
 			ExpectedError: "",
 			Before: func() {
-				os.Setenv("ENGLISH_WORD_LIST_PATH", defaultTextDir)
+				wordListPath = defaultTextDir
 			},
 			After: func() {},
 		},
@@ -169,6 +159,6 @@ func TestFilterPage(t *testing.T) {
 func TestAddEdgesIfDoNotExist(t *testing.T) {
 	node := "/synonym/test"
 	neighbors := []string{}
-	added, _ := AddEdgesIfDoNotExist(node, neighbors)
+	added, _ := AddEdgesIfDoNotExist(context.Background(), node, neighbors)
 	assert.Equal(t, added, []string(nil))
 }
@@ -0,0 +1,133 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type logFieldsKey struct{}
+
+// configures log level, format ("text" or "json") and the interval
+// repetitive messages are sampled at
+func ConfigureLogging(level string, format string, sampleInterval time.Duration) error {
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(lvl)
+	switch strings.ToLower(format) {
+	case "text":
+		log.SetFormatter(&log.TextFormatter{
+			FullTimestamp: true,
+		})
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		return fmt.Errorf("LOG_FORMAT must be 'text' or 'json' but was '%s'", format)
+	}
+	sampler.setInterval(sampleInterval)
+	return nil
+}
+
+// adds "site" field to every log line
+type siteHook struct {
+	site string
+}
+
+func (h siteHook) Levels() []log.Level {
+	return log.AllLevels
+}
+
+func (h siteHook) Fire(e *log.Entry) error {
+	if _, ok := e.Data["site"]; !ok {
+		e.Data["site"] = h.site
+	}
+	return nil
+}
+
+// tags all log lines with the site being crawled
+func SetLogSite(site string) {
+	log.AddHook(siteHook{site})
+}
+
+// returns ctx with fields added to its log fields
+func WithLogFields(ctx context.Context, fields log.Fields) context.Context {
+	merged := log.Fields{}
+	for k, v := range LogFields(ctx) {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, logFieldsKey{}, merged)
+}
+
+// log fields attached to ctx
+func LogFields(ctx context.Context) log.Fields {
+	if fields, ok := ctx.Value(logFieldsKey{}).(log.Fields); ok {
+		return fields
+	}
+	return log.Fields{}
+}
+
+// logger with the fields attached to ctx
+func Logger(ctx context.Context) *log.Entry {
+	return log.WithFields(LogFields(ctx))
+}
+
+// limits repetitive messages to one per interval per key
+type logSampler struct {
+	sync.Mutex
+	interval   time.Duration
+	last       map[string]time.Time
+	suppressed map[string]int
+}
+
+var sampler = &logSampler{
+	interval:   time.Duration(10 * time.Second),
+	last:       make(map[string]time.Time),
+	suppressed: make(map[string]int),
+}
+
+func (s *logSampler) setInterval(d time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+	s.interval = d
+}
+
+// returns true if a message of type key should be logged, along with the
+// number of messages of that type suppressed since it was last logged
+func ShouldLog(key string) (bool, int) {
+	sampler.Lock()
+	defer sampler.Unlock()
+	if time.Since(sampler.last[key]) < sampler.interval {
+		sampler.suppressed[key]++
+		return false, 0
+	}
+	suppressed := sampler.suppressed[key]
+	sampler.last[key] = time.Now()
+	sampler.suppressed[key] = 0
+	return true, suppressed
+}
+
+// GET returns current log level, PUT or POST with ?level= changes it
+func LogLevelHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == "PUT" || r.Method == "POST" {
+		level, err := log.ParseLevel(r.URL.Query().Get("level"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]interface{}{"error": err.Error(), "code": 400})
+			return
+		}
+		log.SetLevel(level)
+		log.Infof("log level set to %s", level)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"level": log.GetLevel().String()})
+}
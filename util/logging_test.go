@@ -0,0 +1,82 @@
+package util
+
+import (
+	"bytes"
+	"context"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConfigureLogging(t *testing.T) {
+	defer logrus.SetLevel(logrus.InfoLevel)
+	defer logrus.SetFormatter(&logrus.TextFormatter{})
+	defer sampler.setInterval(10 * time.Second)
+	t.Run("sets level, format and sample interval", func(t *testing.T) {
+		assert.Nil(t, ConfigureLogging("debug", "json", time.Minute))
+		assert.Equal(t, logrus.DebugLevel, logrus.GetLevel())
+		_, isJSON := logrus.StandardLogger().Formatter.(*logrus.JSONFormatter)
+		assert.True(t, isJSON)
+		assert.Equal(t, time.Minute, sampler.interval)
+	})
+	t.Run("fails on bad level", func(t *testing.T) {
+		assert.Error(t, ConfigureLogging("loud", "text", time.Second))
+	})
+	t.Run("fails on bad format", func(t *testing.T) {
+		assert.EqualError(t, ConfigureLogging("info", "xml", time.Second), "LOG_FORMAT must be 'text' or 'json' but was 'xml'")
+	})
+}
+
+func TestLogFields(t *testing.T) {
+	ctx := WithLogFields(context.Background(), logrus.Fields{"url": "/wiki/test", "depth": 1})
+	ctx = WithLogFields(ctx, logrus.Fields{"stage": "kv"})
+	assert.Equal(t, logrus.Fields{"url": "/wiki/test", "depth": 1, "stage": "kv"}, LogFields(ctx))
+	assert.Equal(t, logrus.Fields{}, LogFields(context.Background()))
+
+	out := &bytes.Buffer{}
+	logger := Logger(ctx)
+	logger.Logger = &logrus.Logger{Out: out, Formatter: &logrus.JSONFormatter{}, Hooks: make(logrus.LevelHooks), Level: logrus.InfoLevel}
+	logger.Logger.AddHook(siteHook{"wikipedia"})
+	logger.Info("test")
+	assert.Contains(t, out.String(), `"site":"wikipedia"`)
+	assert.Contains(t, out.String(), `"stage":"kv"`)
+}
+
+func TestShouldLog(t *testing.T) {
+	sampler.setInterval(time.Hour)
+	defer sampler.setInterval(10 * time.Second)
+	ok, _ := ShouldLog("test message")
+	assert.True(t, ok)
+	ok, _ = ShouldLog("test message")
+	assert.False(t, ok)
+	ok, _ = ShouldLog("other message")
+	assert.True(t, ok)
+	// suppressed messages are reported on next log
+	sampler.last["test message"] = time.Time{}
+	ok, suppressed := ShouldLog("test message")
+	assert.True(t, ok)
+	assert.Equal(t, 1, suppressed)
+}
+
+func TestLogLevelHandler(t *testing.T) {
+	defer logrus.SetLevel(logrus.InfoLevel)
+	t.Run("changes level at runtime", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		LogLevelHandler(w, httptest.NewRequest("PUT", "/loglevel?level=warn", nil))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, logrus.WarnLevel, logrus.GetLevel())
+	})
+	t.Run("rejects unknown level", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		LogLevelHandler(w, httptest.NewRequest("PUT", "/loglevel?level=loud", nil))
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+	t.Run("returns current level", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		LogLevelHandler(w, httptest.NewRequest("GET", "/loglevel", nil))
+		assert.Contains(t, w.Body.String(), `"level":"warning"`)
+	})
+}
@@ -2,25 +2,38 @@ package util
 
 import (
 	"bufio"
+	"context"
 	"fmt"
 	log "github.com/sirupsen/logrus"
 	"math/rand"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
 	"time"
 )
 
 var logErr = log.Errorf
+var logDebug = func(ctx context.Context, format string, args ...interface{}) {
+	Logger(ctx).Debugf(format, args...)
+}
+
+type relationKey struct{}
+type edgeAttributesKey struct{}
+type nodeAttributesKey struct{}
+type nodeCountKey struct{}
+type linkedBackKey struct{}
 
+// link to a random line of the file at path, which is configured as name
 func ReadRandomLineFromFile(
-	envName string,
+	name string,
+	path string,
 	baseEndpoint string,
 	prefix string,
 	toLower bool,
 ) (string, error) {
-	path := os.Getenv(envName)
 	if path == "" {
-		return "", fmt.Errorf("%s was not set", envName)
+		return "", fmt.Errorf("%s was not set", name)
 	}
 	// read in file to list of strings
 	file, err := os.Open(path)
@@ -42,3 +55,130 @@ func ReadRandomLineFromFile(
 	rand.Seed(time.Now().UnixNano())
 	return baseEndpoint + prefix + words[rand.Intn(len(words))], err
 }
+
+// checks that the file at path, configured as name, exists and is not empty
+func CheckFile(name string, path string) error {
+	if path == "" {
+		return fmt.Errorf("%s was not set", name)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("%s is empty", path)
+	}
+	return nil
+}
+
+// returns ctx for edges of the given relation type, e.g. "antonym"
+func WithRelation(ctx context.Context, relation string) context.Context {
+	return context.WithValue(ctx, relationKey{}, relation)
+}
+
+// relation type of edges written with ctx, "" if they are untyped
+func Relation(ctx context.Context) string {
+	r, _ := ctx.Value(relationKey{}).(string)
+	return r
+}
+
+// returns ctx for removing edges to links, of which those in linkedBack
+// still link back to the page themselves
+func WithLinkedBack(ctx context.Context, linkedBack []string) context.Context {
+	return context.WithValue(ctx, linkedBackKey{}, linkedBack)
+}
+
+// links set with WithLinkedBack, nil if there are none
+func LinkedBack(ctx context.Context) []string {
+	l, _ := ctx.Value(linkedBackKey{}).([]string)
+	return l
+}
+
+// returns ctx for edges with attribute key set to value, e.g. zone=lead
+func WithEdgeAttribute(ctx context.Context, key string, value string) context.Context {
+	attributes := map[string]string{key: value}
+	for k, v := range EdgeAttributes(ctx) {
+		if k != key {
+			attributes[k] = v
+		}
+	}
+	return context.WithValue(ctx, edgeAttributesKey{}, attributes)
+}
+
+// attributes of edges written with ctx, nil if there are none
+func EdgeAttributes(ctx context.Context) map[string]string {
+	a, _ := ctx.Value(edgeAttributesKey{}).(map[string]string)
+	return a
+}
+
+// returns ctx for edges from a node with attribute key set to value, e.g.
+// disambiguation=true
+func WithNodeAttribute(ctx context.Context, key string, value string) context.Context {
+	attributes := map[string]string{key: value}
+	for k, v := range NodeAttributes(ctx) {
+		if k != key {
+			attributes[k] = v
+		}
+	}
+	return context.WithValue(ctx, nodeAttributesKey{}, attributes)
+}
+
+// returns ctx for edges from another node, without node attributes
+func WithoutNodeAttributes(ctx context.Context) context.Context {
+	return context.WithValue(ctx, nodeAttributesKey{}, map[string]string(nil))
+}
+
+// attributes of the node edges written with ctx are from, nil if there
+// are none
+func NodeAttributes(ctx context.Context) map[string]string {
+	a, _ := ctx.Value(nodeAttributesKey{}).(map[string]string)
+	return a
+}
+
+// nodes edges written with a ctx added to the graph, for when those are not
+// the nodes returned to crawl on
+type NodeCount struct {
+	sync.Mutex
+	counted bool
+	n       int
+}
+
+// returns ctx in which nodes added to the graph can be counted with
+// CountNodes
+func WithNodeCount(ctx context.Context) (context.Context, *NodeCount) {
+	c := &NodeCount{}
+	return context.WithValue(ctx, nodeCountKey{}, c), c
+}
+
+// counts n nodes as added to the graph, if ctx counts them
+func CountNodes(ctx context.Context, n int) {
+	c, ok := ctx.Value(nodeCountKey{}).(*NodeCount)
+	if !ok {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.counted = true
+	c.n += n
+}
+
+// nodes counted with CountNodes, false if none were counted
+func (c *NodeCount) Get() (int, bool) {
+	c.Lock()
+	defer c.Unlock()
+	return c.n, c.counted
+}
+
+// decodes link to a node key, "" if it cannot be decoded. sites clean URLs
+// without the page's context, failures are logged at debug with the link
+func UnescapeKey(link string) string {
+	key, err := url.QueryUnescape(link)
+	if err != nil {
+		logDebug(WithLogFields(context.Background(), log.Fields{"stage": "clean", "link": link}), "Could not decode %s: %v", link, err)
+		return ""
+	}
+	return key
+}
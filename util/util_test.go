@@ -1,8 +1,10 @@
 package util
 
 import (
+	"context"
 	"fmt"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"os"
 	"testing"
 )
@@ -21,9 +23,7 @@ func TestReadRandomLineFromFile(t *testing.T) {
 		Name          string
 		ExpectedError string
 		toLower       bool
-		Before        func()
-		After         func()
-		EnvName       string
+		Path          string
 	}
 
 	prefix := "/synonym/ar/"
@@ -34,43 +34,26 @@ func TestReadRandomLineFromFile(t *testing.T) {
 		Test{
 			Name:          "ARABIC_WORD_LIST_PATH not set",
 			ExpectedError: "ARABIC_WORD_LIST_PATH was not set",
-			EnvName:       "ARABIC_WORD_LIST_PATH",
 			toLower:       true,
-			Before: func() {
-				os.Setenv("ARABIC_WORD_LIST_PATH", "")
-			},
-			After: func() {
-				os.Setenv("ARABIC_WORD_LIST_PATH", defaultTextDir)
-			},
+			Path:          "",
 		},
 		Test{
 			Name:          "gets random word succesfully",
-			EnvName:       "ARABIC_WORD_LIST_PATH",
 			ExpectedError: "",
 			toLower:       true,
-			Before: func() {
-				os.Setenv("ARABIC_WORD_LIST_PATH", defaultTextDir)
-			},
-			After: func() {},
+			Path:          defaultTextDir,
 		},
 		Test{
 			Name:          "no such path",
 			ExpectedError: "open this/does/not/exist: no such file or directory",
-			EnvName:       "ARABIC_WORD_LIST_PATH",
 			toLower:       true,
-			Before: func() {
-				os.Setenv("ARABIC_WORD_LIST_PATH", "this/does/not/exist")
-			},
-			After: func() {
-				os.Setenv("ARABIC_WORD_LIST_PATH", defaultTextDir)
-			},
+			Path:          "this/does/not/exist",
 		},
 	}
 
 	for _, test := range testTable {
 		t.Run(test.Name, func(t *testing.T) {
-			test.Before()
-			w, err := ReadRandomLineFromFile(test.EnvName, baseEndpoint, prefix, test.toLower)
+			w, err := ReadRandomLineFromFile("ARABIC_WORD_LIST_PATH", test.Path, baseEndpoint, prefix, test.toLower)
 			// positive tests
 			if test.ExpectedError == "" {
 				assert.NotEqual(t, "", w)
@@ -80,8 +63,85 @@ func TestReadRandomLineFromFile(t *testing.T) {
 				assert.NotEqual(t, nil, err)
 				assert.Equal(t, test.ExpectedError, err.Error())
 			}
-			test.After()
 		})
 	}
 
 }
+
+func TestCheckFile(t *testing.T) {
+	t.Run("fails when path is not set", func(t *testing.T) {
+		assert.EqualError(t, CheckFile("WORD_LIST", ""), "WORD_LIST was not set")
+	})
+	t.Run("fails when file does not exist", func(t *testing.T) {
+		assert.Error(t, CheckFile("WORD_LIST", "this/does/not/exist"))
+	})
+	t.Run("fails when file is empty", func(t *testing.T) {
+		f, _ := ioutil.TempFile("", "words")
+		defer os.Remove(f.Name())
+		assert.EqualError(t, CheckFile("WORD_LIST", f.Name()), f.Name()+" is empty")
+	})
+	t.Run("passes on existing word list", func(t *testing.T) {
+		assert.Nil(t, CheckFile("WORD_LIST", "../ar_synonyms/arabic.txt"))
+	})
+}
+
+func TestWithRelation(t *testing.T) {
+	assert.Equal(t, "", Relation(context.Background()))
+	ctx := WithRelation(context.Background(), "antonym")
+	assert.Equal(t, "antonym", Relation(ctx))
+	assert.Equal(t, "synonym", Relation(WithRelation(ctx, "synonym")))
+}
+
+func TestWithEdgeAttribute(t *testing.T) {
+	assert.Nil(t, EdgeAttributes(context.Background()))
+	ctx := WithEdgeAttribute(context.Background(), "zone", "lead")
+	both := WithEdgeAttribute(ctx, "source", "html")
+	assert.Equal(t, map[string]string{"zone": "lead", "source": "html"}, EdgeAttributes(both))
+	assert.Equal(t, map[string]string{"zone": "body", "source": "html"}, EdgeAttributes(WithEdgeAttribute(both, "zone", "body")))
+	// parent contexts are untouched
+	assert.Equal(t, map[string]string{"zone": "lead"}, EdgeAttributes(ctx))
+}
+
+func TestWithNodeAttribute(t *testing.T) {
+	assert.Nil(t, NodeAttributes(context.Background()))
+	ctx := WithNodeAttribute(context.Background(), "disambiguation", "true")
+	both := WithNodeAttribute(WithEdgeAttribute(ctx, "zone", "lead"), "lang", "de")
+	assert.Equal(t, map[string]string{"disambiguation": "true", "lang": "de"}, NodeAttributes(both))
+	assert.Equal(t, map[string]string{"disambiguation": "true"}, NodeAttributes(ctx))
+	// edge attributes are kept for other nodes
+	other := WithoutNodeAttributes(both)
+	assert.Nil(t, NodeAttributes(other))
+	assert.Equal(t, map[string]string{"zone": "lead"}, EdgeAttributes(other))
+}
+
+func TestWithNodeCount(t *testing.T) {
+	// counting without a count is a no-op
+	CountNodes(context.Background(), 1)
+	ctx, count := WithNodeCount(context.Background())
+	_, counted := count.Get()
+	assert.False(t, counted)
+	CountNodes(WithRelation(ctx, "synonym"), 0)
+	n, counted := count.Get()
+	assert.True(t, counted)
+	assert.Equal(t, 0, n)
+	CountNodes(ctx, 2)
+	CountNodes(ctx, 1)
+	n, _ = count.Get()
+	assert.Equal(t, 3, n)
+}
+
+func TestUnescapeKey(t *testing.T) {
+	originalLogDebug := logDebug
+	defer func() { logDebug = originalLogDebug }()
+	logged := []string{}
+	fields := []interface{}{}
+	logDebug = func(ctx context.Context, format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+		fields = append(fields, LogFields(ctx)["link"])
+	}
+	assert.Equal(t, "ingeniøren", UnescapeKey("ingeni%c3%b8ren"))
+	assert.Equal(t, []string{}, logged)
+	assert.Equal(t, "", UnescapeKey("fromage%zz"))
+	assert.Equal(t, []string{`Could not decode fromage%zz: invalid URL escape "%zz"`}, logged)
+	assert.Equal(t, []interface{}{"fromage%zz"}, fields)
+}
@@ -0,0 +1,144 @@
+package wikipedia
+
+import (
+	"context"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/db"
+	"github.com/dgoldstein1/crawler/util"
+	"github.com/gocolly/colly"
+	"net/url"
+	"strings"
+)
+
+// relations of edges from a category
+const (
+	// to a category listed in it
+	SubcategoryRelation = "subcategory"
+	// to an article listed in it
+	ArticleRelation = "article"
+)
+
+// same query in the category namespace
+func categoryQuery(query string) string {
+	return strings.Replace(query, "grnnamespace=0", "grnnamespace=14", 1)
+}
+
+// crawls categories of the wikipedia of cfg.WikipediaLang
+func ConfigureCategories(cfg config.Config) {
+	SetLang(cfg.WikipediaLang)
+	configureSeeds(cfg)
+	configureClient(cfg)
+}
+
+// title of category page link, e.g. "Category:Cheese", false if it is
+// not a category
+func categoryTitle(link string) (string, bool) {
+	if !strings.HasPrefix(link, prefix) {
+		return "", false
+	}
+	title, err := url.PathUnescape(strings.TrimPrefix(link, prefix))
+	if err != nil || strings.Contains(title, "#") {
+		return "", false
+	}
+	for _, namespace := range []string{lang.Category, English.Category} {
+		if strings.HasPrefix(title, namespace+":") && len(title) > len(namespace)+1 {
+			return title, true
+		}
+	}
+	return "", false
+}
+
+// title of the category link is a further page of members of, e.g.
+// "/w/index.php?title=Category:Cheese&pagefrom=Brie", false if it is not one
+func membersPageTitle(link string) (string, bool) {
+	u, err := url.Parse(link)
+	if err != nil || u.Path != "/w/index.php" {
+		return "", false
+	}
+	q := u.Query()
+	if q.Get("pagefrom") == "" && q.Get("subcatfrom") == "" {
+		return "", false
+	}
+	return categoryTitle(articleLink(q.Get("title")))
+}
+
+// determines if link is a category, an article or a further page of
+// members of a category
+func IsValidCategoryLink(link string) bool {
+	if _, ok := categoryTitle(link); ok {
+		return true
+	}
+	if _, ok := membersPageTitle(link); ok {
+		return true
+	}
+	return lang.IsValidCrawlLink(link)
+}
+
+// gets random category from metawiki API
+func GetRandomCategory() (string, error) {
+	return categorySeeds.next()
+}
+
+// decodes and standaridizes URL, further pages of members of a category
+// are the same node as the category
+func CleanCategoryUrl(link string) string {
+	if title, ok := membersPageTitle(strings.TrimPrefix(link, baseEndpoint)); ok {
+		link = articleLink(title)
+	}
+	return lang.CleanUrl(link)
+}
+
+// filters category page down to its subcategories and articles
+func FilterCategoryPage(e *colly.HTMLElement) (*colly.HTMLElement, error) {
+	e.DOM = e.DOM.Find("#mw-subcategories, #mw-pages")
+	return e, nil
+}
+
+// adds subcategory and article edges to DB. returns new subcategories and
+// further pages of members to crawl on, articles are not crawled. further
+// pages are the same node, they are crawled at its depth and not counted.
+func AddCategoryEdges(
+	ctx context.Context,
+	currentNode string,
+	neighborNodes []string,
+) (
+	neighborsAdded []string,
+	err error,
+) {
+	subcategories, articles, pages := []string{}, []string{}, []string{}
+	for _, n := range neighborNodes {
+		if _, ok := membersPageTitle(n); ok {
+			pages = append(pages, baseEndpoint+n)
+		} else if _, ok := categoryTitle(n); ok {
+			subcategories = append(subcategories, n)
+		} else {
+			articles = append(articles, n)
+		}
+	}
+	if len(subcategories) > 0 {
+		neighborsAdded, err = db.AddEdgesIfDoNotExist(
+			util.WithRelation(ctx, SubcategoryRelation),
+			currentNode,
+			subcategories,
+			CleanCategoryUrl,
+			baseEndpoint,
+		)
+		if err != nil {
+			return neighborsAdded, err
+		}
+	}
+	if len(articles) > 0 {
+		_, err = db.AddEdgesIfDoNotExist(
+			util.WithRelation(ctx, ArticleRelation),
+			currentNode,
+			articles,
+			CleanCategoryUrl,
+			baseEndpoint,
+		)
+		if err != nil {
+			return neighborsAdded, err
+		}
+	}
+	// further pages of members are the same node, but are crawled
+	return append(neighborsAdded, pages...), nil
+}
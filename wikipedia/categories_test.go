@@ -0,0 +1,128 @@
+package wikipedia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/db"
+	"github.com/gocolly/colly"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestIsValidCategoryLink(t *testing.T) {
+	defer SetLang("en")
+	type Test struct {
+		Name     string
+		Lang     string
+		Link     string
+		Expected bool
+	}
+	testTable := []Test{
+		Test{"category", "en", "/wiki/Category:Cheeses_by_country", true},
+		Test{"article", "en", "/wiki/Brie", true},
+		Test{"further members", "en", "/w/index.php?title=Category:Cheese&pagefrom=Brie#mw-pages", true},
+		Test{"further subcategories", "en", "/w/index.php?title=Category:Cheese&subcatfrom=Blue#mw-subcategories", true},
+		Test{"previous members", "en", "/w/index.php?title=Category:Cheese&pageuntil=Brie#mw-pages", false},
+		Test{"other namespace", "en", "/wiki/Template:Cheese", false},
+		Test{"empty category", "en", "/wiki/Category:", false},
+		Test{"localized category", "de", "/wiki/Kategorie:K%C3%A4se", true},
+		Test{"english alias", "de", "/wiki/Category:K%C3%A4se", true},
+		Test{"other localized category", "en", "/wiki/Kategorie:K%C3%A4se", false},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			SetLang(test.Lang)
+			assert.Equal(t, test.Expected, IsValidCategoryLink(test.Link))
+		})
+	}
+}
+
+func TestCleanCategoryUrl(t *testing.T) {
+	assert.Equal(t, "category:blue cheeses", CleanCategoryUrl("https://en.wikipedia.org/wiki/Category:Blue_cheeses"))
+	// further pages are the same node as their category
+	assert.Equal(t, "category:blue cheeses", CleanCategoryUrl("https://en.wikipedia.org/w/index.php?title=Category:Blue_cheeses&pagefrom=Roquefort#mw-pages"))
+	assert.Equal(t, "category:c++ libraries", CleanCategoryUrl("/w/index.php?title=Category:C%2B%2B_libraries&subcatfrom=B"))
+	// articles have the same keys as in the article graph
+	assert.Equal(t, CleanUrl("/wiki/Roquefort"), CleanCategoryUrl("/wiki/Roquefort"))
+}
+
+func TestFilterCategoryPage(t *testing.T) {
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><body>
+		<div class="mw-parser-output"><p>About <a href="/wiki/Cheese">cheese</a></p></div>
+		<div id="mw-subcategories"><a href="/wiki/Category:Blue_cheeses">Blue cheeses</a></div>
+		<div id="mw-pages"><a href="/wiki/Brie">Brie</a><a href="/w/index.php?title=Category:Cheese&amp;pagefrom=Cheddar#mw-pages">next page</a></div>
+		<div id="catlinks"><a href="/wiki/Category:Dairy_products">Dairy products</a></div>
+	</body></html>`))
+	require.Nil(t, err)
+	e, err := FilterCategoryPage(&colly.HTMLElement{DOM: doc.Selection})
+	assert.Nil(t, err)
+	links := []string{}
+	e.DOM.Find("a[href]").Each(func(_ int, s *goquery.Selection) {
+		links = append(links, s.AttrOr("href", ""))
+	})
+	assert.Equal(t, []string{"/wiki/Category:Blue_cheeses", "/wiki/Brie", "/w/index.php?title=Category:Cheese&pagefrom=Cheddar#mw-pages"}, links)
+}
+
+func TestAddCategoryEdges(t *testing.T) {
+	db.Configure(config.Config{GraphDBEndpoint: dbEndpoint, TwoWayKVEndpoint: twoWayEndpoint})
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	// ids by key, every key is new
+	ids := map[string]int{}
+	httpmock.RegisterResponder("POST", twoWayEndpoint+"/entries",
+		func(req *http.Request) (*http.Response, error) {
+			keys := []string{}
+			json.NewDecoder(req.Body).Decode(&keys)
+			entries := []db.TwoWayEntry{}
+			for _, k := range keys {
+				if _, ok := ids[k]; !ok {
+					ids[k] = len(ids) + 1
+				}
+				entries = append(entries, db.TwoWayEntry{Key: k, Value: ids[k]})
+			}
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"errors": []string{}, "entries": entries})
+		},
+	)
+	edges := []string{}
+	httpmock.RegisterResponder("POST", `=~^`+dbEndpoint+`/edges`,
+		func(req *http.Request) (*http.Response, error) {
+			body := struct {
+				Neighbors []int  `json:"neighbors"`
+				Relation  string `json:"relation"`
+			}{}
+			json.NewDecoder(req.Body).Decode(&body)
+			added := []string{}
+			for _, n := range body.Neighbors {
+				edges = append(edges, fmt.Sprintf("%s %s %d", req.URL.Query().Get("node"), body.Relation, n))
+				added = append(added, fmt.Sprint(n))
+			}
+			return httpmock.NewJsonResponse(200, map[string]interface{}{"neighborsAdded": added})
+		},
+	)
+
+	added, err := AddCategoryEdges(context.Background(), "https://en.wikipedia.org/wiki/Category:Cheese", []string{
+		"/wiki/Category:Blue_cheeses",
+		"/wiki/Brie",
+		"/w/index.php?title=Category:Cheese&pagefrom=Cheddar#mw-pages",
+	})
+	assert.Nil(t, err)
+	// articles are not crawled, further pages are
+	assert.Equal(t, []string{
+		"https://en.wikipedia.org/wiki/Category:Blue_cheeses",
+		"https://en.wikipedia.org/w/index.php?title=Category:Cheese&pagefrom=Cheddar#mw-pages",
+	}, added)
+	sort.Strings(edges)
+	cheese, blue, brie := ids["category:cheese"], ids["category:blue cheeses"], ids["brie"]
+	assert.Equal(t, []string{
+		fmt.Sprintf("%d article %d", cheese, brie),
+		fmt.Sprintf("%d subcategory %d", cheese, blue),
+	}, edges)
+}
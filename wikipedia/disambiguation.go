@@ -0,0 +1,194 @@
+package wikipedia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dgoldstein1/crawler/util"
+	"github.com/gocolly/colly"
+	log "github.com/sirupsen/logrus"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ways disambiguation pages are crawled
+const (
+	// like any other article
+	KeepDisambiguation = "keep"
+	// not at all, their links are not followed and links to them are dropped
+	SkipDisambiguation = "skip"
+	// like any other article, with a disambiguation attribute on their node
+	MarkDisambiguation = "mark"
+	// like any other article, with a disambiguation attribute on edges from
+	// and to them
+	TagDisambiguation = "tag"
+)
+
+var disambiguation = KeepDisambiguation
+
+// attribute set on nodes or edges of disambiguation pages
+var disambiguationAttribute = "disambiguation"
+
+// notices disambiguation pages carry, in several editions
+var disambiguationMarkers = "#disambigbox, #disambig, .dmbox-disambig, .disambig"
+
+// links to disambiguation pages, and redirects to them
+var disambiguationLinks = "a.mw-disambig"
+
+// page props of titles, redirects are followed
+var pagePropsQuery = "/w/api.php?format=json&action=query&prop=pageprops&ppprop=disambiguation&redirects=1&titles="
+
+// titles the API accepts in one request
+var pagePropsBatch = 50
+
+// disambiguation pages remembered at most, an arbitrary one is forgotten
+// for every page recorded beyond that
+var maxDisambiguationPages = 100000
+
+// keys of pages known to be disambiguation pages
+var disambiguationPages = struct {
+	sync.Mutex
+	keys map[string]bool
+}{keys: make(map[string]bool)}
+
+// crawls disambiguation pages as mode, one of keep, skip, mark or tag
+func SetDisambiguation(mode string) {
+	disambiguation = mode
+}
+
+// remembers the page at link is a disambiguation page
+func recordDisambiguation(link string) {
+	disambiguationPages.Lock()
+	defer disambiguationPages.Unlock()
+	key := CleanUrl(link)
+	if !disambiguationPages.keys[key] && len(disambiguationPages.keys) >= maxDisambiguationPages {
+		for k := range disambiguationPages.keys {
+			delete(disambiguationPages.keys, k)
+			break
+		}
+	}
+	disambiguationPages.keys[key] = true
+}
+
+// whether the page at link is known to be a disambiguation page
+func isDisambiguation(link string) bool {
+	disambiguationPages.Lock()
+	defer disambiguationPages.Unlock()
+	return disambiguationPages.keys[CleanUrl(link)]
+}
+
+// records e if it is a disambiguation page and its links to disambiguation
+// pages
+func findDisambiguation(e *colly.HTMLElement) {
+	if e.Request != nil && e.DOM.Find(disambiguationMarkers).Length() > 0 {
+		recordDisambiguation(e.Request.URL.String())
+	}
+	e.DOM.Find(disambiguationLinks).Each(func(_ int, a *goquery.Selection) {
+		if link, ok := a.Attr("href"); ok {
+			recordDisambiguation(link)
+		}
+	})
+}
+
+// records which of links, articles returned by the API, are disambiguation
+// pages. only needed when links to them are skipped or tagged, as the API
+// does not mark them like the HTML of a page does
+func findLinkedDisambiguation(ctx context.Context, links []string) {
+	if disambiguation != SkipDisambiguation && disambiguation != TagDisambiguation {
+		return
+	}
+	ctx = util.WithLogFields(ctx, log.Fields{"stage": "disambiguation"})
+	for start := 0; start < len(links); start += pagePropsBatch {
+		end := start + pagePropsBatch
+		if end > len(links) {
+			end = len(links)
+		}
+		if err := fetchDisambiguation(ctx, links[start:end]); err != nil {
+			logErr(ctx, "Could not get page props of links: %v", err)
+		}
+	}
+}
+
+// records which of links, at most pagePropsBatch, are disambiguation pages
+func fetchDisambiguation(ctx context.Context, links []string) error {
+	titles := []string{}
+	for _, l := range links {
+		titles = append(titles, articleTitle(l))
+	}
+	body, err := fetch(ctx, baseEndpoint+pagePropsQuery+url.QueryEscape(strings.Join(titles, "|")))
+	if err != nil {
+		return err
+	}
+	resp := &RArticleResp{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return fmt.Errorf("could not unmarshal page props response: %v", err)
+	}
+	// links to redirects are links to the page they redirect to
+	redirectedFrom := map[string][]string{}
+	for _, r := range resp.Query.Redirects {
+		redirectedFrom[r.To] = append(redirectedFrom[r.To], r.From)
+	}
+	for _, p := range resp.Query.Pages {
+		if _, ok := p.PageProps["disambiguation"]; !ok {
+			continue
+		}
+		recordDisambiguation(articleLink(p.Title))
+		for _, from := range redirectedFrom[p.Title] {
+			recordDisambiguation(articleLink(from))
+		}
+	}
+	return nil
+}
+
+// links which are not known to be disambiguation pages, and those which are
+func splitDisambiguation(links []string) (articles []string, ambiguous []string) {
+	for _, l := range links {
+		if isDisambiguation(l) {
+			ambiguous = append(ambiguous, l)
+		} else {
+			articles = append(articles, l)
+		}
+	}
+	return articles, ambiguous
+}
+
+// adds edges from currentNode to neighborNodes as disambiguation pages are
+// crawled, with addEdges writing edges of a single kind
+func addDisambiguationEdges(
+	ctx context.Context,
+	currentNode string,
+	neighborNodes []string,
+	addEdges func(context.Context, string, []string) ([]string, error),
+) ([]string, error) {
+	tagged := util.WithEdgeAttribute(ctx, disambiguationAttribute, "true")
+	switch disambiguation {
+	case SkipDisambiguation:
+		if isDisambiguation(currentNode) {
+			return []string{}, nil
+		}
+		articles, _ := splitDisambiguation(neighborNodes)
+		return addEdges(ctx, currentNode, articles)
+	case MarkDisambiguation:
+		if isDisambiguation(currentNode) {
+			ctx = util.WithNodeAttribute(ctx, disambiguationAttribute, "true")
+		}
+		return addEdges(ctx, currentNode, neighborNodes)
+	case TagDisambiguation:
+		if isDisambiguation(currentNode) {
+			return addEdges(tagged, currentNode, neighborNodes)
+		}
+		articles, ambiguous := splitDisambiguation(neighborNodes)
+		if len(ambiguous) == 0 {
+			return addEdges(ctx, currentNode, articles)
+		}
+		added, err := addEdges(tagged, currentNode, ambiguous)
+		if err != nil || len(articles) == 0 {
+			return added, err
+		}
+		more, err := addEdges(ctx, currentNode, articles)
+		return append(added, more...), err
+	}
+	return addEdges(ctx, currentNode, neighborNodes)
+}
@@ -0,0 +1,207 @@
+package wikipedia
+
+import (
+	"context"
+	"fmt"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dgoldstein1/crawler/util"
+	"github.com/gocolly/colly"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// forgets all disambiguation pages
+func resetDisambiguation() {
+	disambiguationPages.Lock()
+	defer disambiguationPages.Unlock()
+	disambiguationPages.keys = make(map[string]bool)
+}
+
+func TestFindDisambiguation(t *testing.T) {
+	defer resetDisambiguation()
+	defer SetDisambiguation(KeepDisambiguation)
+	SetDisambiguation(SkipDisambiguation)
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(`<html><body>
+		<div class="mw-parser-output">
+			<p><b>Mercury</b> may refer to:</p>
+			<ul>
+				<li><a href="/wiki/Mercury_(planet)">Mercury (planet)</a></li>
+				<li><a href="/wiki/Quicksilver_(disambiguation)" class="mw-disambig">Quicksilver</a></li>
+			</ul>
+			<table id="disambigbox" class="metadata plainlinks dmbox dmbox-disambig"></table>
+		</div>
+	</body></html>`))
+	require.Nil(t, err)
+	u, _ := url.Parse("https://en.wikipedia.org/wiki/Mercury")
+	e, err := FilterPage(&colly.HTMLElement{DOM: doc.Selection, Request: &colly.Request{URL: u}})
+	assert.Nil(t, err)
+	// links are only recorded, not dropped
+	assert.Equal(t, 2, e.DOM.Find("a").Length())
+	assert.True(t, isDisambiguation("https://en.wikipedia.org/wiki/Mercury"))
+	assert.True(t, isDisambiguation("/wiki/Quicksilver_(disambiguation)"))
+	assert.False(t, isDisambiguation("/wiki/Mercury_(planet)"))
+
+	// articles are not disambiguation pages
+	doc, err = goquery.NewDocumentFromReader(strings.NewReader(`<html><body>
+		<div class="mw-parser-output"><p>Mercury is the first planet.</p></div>
+	</body></html>`))
+	require.Nil(t, err)
+	u, _ = url.Parse("https://en.wikipedia.org/wiki/Mercury_(planet)")
+	_, err = FilterPage(&colly.HTMLElement{DOM: doc.Selection, Request: &colly.Request{URL: u}})
+	assert.Nil(t, err)
+	assert.False(t, isDisambiguation("/wiki/Mercury_(planet)"))
+}
+
+func TestAddDisambiguationEdges(t *testing.T) {
+	defer resetDisambiguation()
+	defer SetDisambiguation(KeepDisambiguation)
+	recordDisambiguation("/wiki/Mercury")
+	recordDisambiguation("/wiki/Quicksilver_(disambiguation)")
+
+	type Test struct {
+		Name     string
+		Mode     string
+		Node     string
+		Expected []string
+	}
+	testTable := []Test{
+		Test{
+			"keeps disambiguation pages",
+			KeepDisambiguation,
+			"/wiki/Mercury",
+			[]string{"/wiki/Mercury -> /wiki/Mercury_(planet) /wiki/Quicksilver_(disambiguation) {} {}"},
+		},
+		Test{
+			"skips disambiguation pages",
+			SkipDisambiguation,
+			"/wiki/Mercury",
+			[]string{},
+		},
+		Test{
+			"skips links to disambiguation pages",
+			SkipDisambiguation,
+			"/wiki/Venus",
+			[]string{"/wiki/Venus -> /wiki/Mercury_(planet) {} {}"},
+		},
+		Test{
+			"marks disambiguation pages",
+			MarkDisambiguation,
+			"/wiki/Mercury",
+			[]string{"/wiki/Mercury -> /wiki/Mercury_(planet) /wiki/Quicksilver_(disambiguation) {disambiguation=true} {}"},
+		},
+		Test{
+			"does not mark articles",
+			MarkDisambiguation,
+			"/wiki/Venus",
+			[]string{"/wiki/Venus -> /wiki/Mercury_(planet) /wiki/Quicksilver_(disambiguation) {} {}"},
+		},
+		Test{
+			"tags edges from disambiguation pages",
+			TagDisambiguation,
+			"/wiki/Mercury",
+			[]string{"/wiki/Mercury -> /wiki/Mercury_(planet) /wiki/Quicksilver_(disambiguation) {} {disambiguation=true}"},
+		},
+		Test{
+			"tags edges to disambiguation pages",
+			TagDisambiguation,
+			"/wiki/Venus",
+			[]string{
+				"/wiki/Venus -> /wiki/Mercury_(planet) {} {}",
+				"/wiki/Venus -> /wiki/Quicksilver_(disambiguation) {} {disambiguation=true}",
+			},
+		},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			SetDisambiguation(test.Mode)
+			calls := []string{}
+			added, err := addDisambiguationEdges(
+				context.Background(),
+				test.Node,
+				[]string{"/wiki/Mercury_(planet)", "/wiki/Quicksilver_(disambiguation)"},
+				func(ctx context.Context, node string, neighbors []string) ([]string, error) {
+					calls = append(calls, fmt.Sprintf("%s -> %s %s %s",
+						node,
+						strings.Join(neighbors, " "),
+						formatAttributes(util.NodeAttributes(ctx)),
+						formatAttributes(util.EdgeAttributes(ctx)),
+					))
+					return neighbors, nil
+				},
+			)
+			assert.Nil(t, err)
+			sort.Strings(calls)
+			assert.Equal(t, test.Expected, calls)
+			// skipped disambiguation pages are not crawled
+			for _, n := range added {
+				assert.True(t, test.Mode != SkipDisambiguation || !isDisambiguation(n))
+			}
+		})
+	}
+}
+
+// attributes as "{k=v}"
+func formatAttributes(attributes map[string]string) string {
+	pairs := []string{}
+	for k, v := range attributes {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return "{" + strings.Join(pairs, " ") + "}"
+}
+
+func TestParseLinksDisambiguation(t *testing.T) {
+	defer resetDisambiguation()
+	defer SetDisambiguation(KeepDisambiguation)
+	SetDisambiguation(SkipDisambiguation)
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", baseEndpoint+pagePropsQuery+url.QueryEscape("Mercury (planet)"),
+		httpmock.NewStringResponder(200, `{"query":{"pages":{"19331":{"pageid":19331,"ns":0,"title":"Mercury (planet)"}}}}`))
+	httpmock.RegisterResponder("GET", baseEndpoint+pagePropsQuery+url.QueryEscape("Venus|Quicksilver"),
+		httpmock.NewStringResponder(200, `{"query":{"redirects":[{"from":"Quicksilver","to":"Quicksilver (disambiguation)"}],"pages":{
+			"32745":{"pageid":32745,"ns":0,"title":"Venus"},
+			"25185":{"pageid":25185,"ns":0,"title":"Quicksilver (disambiguation)","pageprops":{"disambiguation":""}}}}}`))
+
+	body := `{"query":{"pages":{"19694":{"pageid":19694,"ns":0,"title":"Mercury","pageprops":{"disambiguation":""},"links":[{"ns":0,"title":"Mercury (planet)"}]}}}}`
+	page, links, err := ParseLinks(context.Background(), linksURL("/wiki/Mercury"), []byte(body))
+	assert.Nil(t, err)
+	assert.Equal(t, "https://en.wikipedia.org/wiki/Mercury", page)
+	assert.Equal(t, []string{"/wiki/Mercury_%28planet%29"}, links)
+	assert.True(t, isDisambiguation(page))
+	assert.False(t, isDisambiguation("/wiki/Mercury_%28planet%29"))
+
+	// links to disambiguation pages are looked up, through redirects
+	body = `{"query":{"pages":{"19331":{"pageid":19331,"ns":0,"title":"Mercury (planet)","links":[{"ns":0,"title":"Venus"},{"ns":0,"title":"Quicksilver"}]}}}}`
+	page, links, err = ParseLinks(context.Background(), linksURL("/wiki/Mercury_(planet)"), []byte(body))
+	assert.Nil(t, err)
+	assert.False(t, isDisambiguation(page))
+	articles, ambiguous := splitDisambiguation(links)
+	assert.Equal(t, []string{"/wiki/Venus"}, articles)
+	assert.Equal(t, []string{"/wiki/Quicksilver"}, ambiguous)
+	assert.True(t, isDisambiguation("/wiki/Quicksilver_(disambiguation)"))
+
+	// links are not looked up when they are kept
+	SetDisambiguation(KeepDisambiguation)
+	calls := httpmock.GetTotalCallCount()
+	_, _, err = ParseLinks(context.Background(), linksURL("/wiki/Mercury"), []byte(body))
+	assert.Nil(t, err)
+	assert.Equal(t, calls, httpmock.GetTotalCallCount())
+}
+
+func TestDisambiguationLimit(t *testing.T) {
+	defer resetDisambiguation()
+	defer func(max int) { maxDisambiguationPages = max }(maxDisambiguationPages)
+	maxDisambiguationPages = 2
+	recordDisambiguation("/wiki/Mercury")
+	recordDisambiguation("/wiki/Mercury")
+	recordDisambiguation("/wiki/Quicksilver")
+	recordDisambiguation("/wiki/Venus")
+	assert.Equal(t, 2, len(disambiguationPages.keys))
+	assert.True(t, isDisambiguation("/wiki/Venus"))
+}
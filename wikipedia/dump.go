@@ -0,0 +1,351 @@
+package wikipedia
+
+import (
+	"bufio"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"github.com/dgoldstein1/crawler/crawler"
+	"github.com/dgoldstein1/crawler/util"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// progress is logged every this many pages
+var importLogInterval = 10000
+
+// files of a MediaWiki SQL dump, e.g. "enwiki-latest-page.sql.gz"
+type SQLDump struct {
+	Page      string
+	PageLinks string
+	// only needed for dumps where pagelinks refer to a linktarget table
+	LinkTarget string
+}
+
+// pages written by an import and the links submitted for them, the graph
+// db does not tell which of those were new edges
+type ImportStats struct {
+	Pages          int
+	EdgesSubmitted int
+	Failed         int
+}
+
+// opens a dump, decompressing it if it ends with .gz or .bz2
+func openDump(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open dump: %v", err)
+	}
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("could not decompress %s: %v", path, err)
+		}
+		return struct {
+			io.Reader
+			io.Closer
+		}{gz, f}, nil
+	case strings.HasSuffix(path, ".bz2"):
+		return struct {
+			io.Reader
+			io.Closer
+		}{bzip2.NewReader(f), f}, nil
+	}
+	return f, nil
+}
+
+// a row of a table in a SQL dump
+type sqlRow struct {
+	columns map[string]int
+	values  []string
+}
+
+// value of column, "" if the table has no such column
+func (r sqlRow) get(column string) string {
+	if i, ok := r.columns[column]; ok && i < len(r.values) {
+		return r.values[i]
+	}
+	return ""
+}
+
+// integer value of column, -1 if it is not a number
+func (r sqlRow) getInt(column string) int {
+	n, err := strconv.Atoi(r.get(column))
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+var sqlColumn = regexp.MustCompile("^\\s*`(\\w+)`")
+
+// reads rows of table from a mysqldump, columns are named by its CREATE
+// TABLE statement
+func readSQLDump(r io.Reader, table string, fn func(sqlRow) error) error {
+	create := "CREATE TABLE `" + table + "`"
+	insert := "INSERT INTO `" + table + "` VALUES "
+	columns := make(map[string]int)
+	inCreate := false
+	br := bufio.NewReaderSize(r, 1<<20)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		switch {
+		case strings.HasPrefix(line, create):
+			inCreate = true
+		case inCreate && strings.HasPrefix(line, ")"):
+			inCreate = false
+		case inCreate:
+			if m := sqlColumn.FindStringSubmatch(line); m != nil {
+				columns[m[1]] = len(columns)
+			}
+		case strings.HasPrefix(line, insert):
+			if len(columns) == 0 {
+				return fmt.Errorf("dump has no CREATE TABLE statement for `%s`", table)
+			}
+			if perr := parseSQLValues(line[len(insert):], func(values []string) error {
+				return fn(sqlRow{columns, values})
+			}); perr != nil {
+				return fmt.Errorf("could not parse `%s` dump: %v", table, perr)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// parses tuples like "(1,'a\'b',NULL),(2,'c',3);" passing the unquoted
+// values of each to fn
+func parseSQLValues(s string, fn func([]string) error) error {
+	values := []string{}
+	value := strings.Builder{}
+	inTuple, inString, escaped := false, false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escaped:
+			value.WriteByte(unescapeSQL(c))
+			escaped = false
+		case inString && c == '\\':
+			escaped = true
+		case inString && c == '\'':
+			inString = false
+		case inString:
+			value.WriteByte(c)
+		case !inTuple && c == '(':
+			inTuple = true
+		case !inTuple && c == ';':
+			return nil
+		case !inTuple:
+			// separators between tuples and line endings
+		case c == '\'':
+			inString = true
+		case c == ',' || c == ')':
+			values = append(values, value.String())
+			value.Reset()
+			if c == ')' {
+				if err := fn(values); err != nil {
+					return err
+				}
+				values = []string{}
+				inTuple = false
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+	if inTuple {
+		return fmt.Errorf("unterminated row")
+	}
+	return nil
+}
+
+// character an escape sequence in a mysqldump string stands for
+func unescapeSQL(c byte) byte {
+	switch c {
+	case '0':
+		return 0
+	case 'n':
+		return '\n'
+	case 'r':
+		return '\r'
+	case 't':
+		return '\t'
+	case 'Z':
+		return 26
+	}
+	return c
+}
+
+// reads dump at path as table
+func readSQLFile(path string, table string, fn func(sqlRow) error) error {
+	f, err := openDump(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return readSQLDump(f, table, fn)
+}
+
+// writes the links of every article in dump through addEdges, as if each
+// had been crawled. redirects and pages outside the main namespace are
+// skipped.
+func ImportSQLDump(ctx context.Context, dump SQLDump, addEdges crawler.AddEdgeFunction) (ImportStats, error) {
+	stats := ImportStats{}
+	// titles of link targets by ID, in newer dumps
+	targets := make(map[int]string)
+	if dump.LinkTarget != "" {
+		err := readSQLFile(dump.LinkTarget, "linktarget", func(r sqlRow) error {
+			if r.get("lt_namespace") == "0" {
+				targets[r.getInt("lt_id")] = r.get("lt_title")
+			}
+			return nil
+		})
+		if err != nil {
+			return stats, err
+		}
+	}
+	// titles of articles by page ID
+	articles := make(map[int]string)
+	err := readSQLFile(dump.Page, "page", func(r sqlRow) error {
+		if r.get("page_namespace") == "0" && r.get("page_is_redirect") != "1" {
+			articles[r.getInt("page_id")] = r.get("page_title")
+		}
+		return nil
+	})
+	if err != nil {
+		return stats, err
+	}
+	logMsg(ctx, "read %d articles and %d link targets", len(articles), len(targets))
+	// pagelinks are sorted by the page they are on
+	from := -1
+	links := []string{}
+	err = readSQLFile(dump.PageLinks, "pagelinks", func(r sqlRow) error {
+		id := r.getInt("pl_from")
+		if id != from {
+			importPage(ctx, articles[from], links, addEdges, &stats)
+			from = id
+			links = []string{}
+		}
+		if _, ok := articles[id]; !ok {
+			return nil
+		}
+		title := ""
+		if _, ok := r.columns["pl_target_id"]; ok {
+			title = targets[r.getInt("pl_target_id")]
+		} else if r.get("pl_namespace") == "0" {
+			title = r.get("pl_title")
+		}
+		if title != "" {
+			links = append(links, articleLink(title))
+		}
+		return nil
+	})
+	importPage(ctx, articles[from], links, addEdges, &stats)
+	return stats, err
+}
+
+// a page of a pages-articles XML dump
+type xmlPage struct {
+	Title    string    `xml:"title"`
+	NS       int       `xml:"ns"`
+	Redirect *struct{} `xml:"redirect"`
+	Text     string    `xml:"revision>text"`
+}
+
+// [[target]], [[target|label]] and [[target#section|label]]
+var wikiLink = regexp.MustCompile(`\[\[([^\[\]|#]*)[^\[\]]*\]\]`)
+
+// writes the links in the wikitext of every article in the XML dump at path
+// through addEdges. links added by templates are not in the wikitext and
+// are missing.
+func ImportXMLDump(ctx context.Context, path string, addEdges crawler.AddEdgeFunction) (ImportStats, error) {
+	stats := ImportStats{}
+	f, err := openDump(path)
+	if err != nil {
+		return stats, err
+	}
+	defer f.Close()
+	dec := xml.NewDecoder(bufio.NewReaderSize(f, 1<<20))
+	for {
+		t, err := dec.Token()
+		if err == io.EOF {
+			return stats, nil
+		} else if err != nil {
+			return stats, fmt.Errorf("could not parse XML dump: %v", err)
+		}
+		start, ok := t.(xml.StartElement)
+		if !ok || start.Name.Local != "page" {
+			continue
+		}
+		p := xmlPage{}
+		if err := dec.DecodeElement(&p, &start); err != nil {
+			return stats, fmt.Errorf("could not parse XML dump: %v", err)
+		}
+		if p.NS != 0 || p.Redirect != nil {
+			continue
+		}
+		importPage(ctx, p.Title, wikitextLinks(p.Text), addEdges, &stats)
+	}
+}
+
+// links to articles in wikitext, once each
+func wikitextLinks(text string) []string {
+	links := []string{}
+	seen := make(map[string]bool)
+	for _, m := range wikiLink.FindAllStringSubmatch(text, -1) {
+		title := strings.TrimSpace(m[1])
+		// links to sections of the same page
+		if title == "" {
+			continue
+		}
+		// titles start with a capital letter, whatever the link says
+		r, size := utf8.DecodeRuneInString(title)
+		title = string(unicode.ToUpper(r)) + title[size:]
+		link := articleLink(title)
+		if !seen[link] {
+			seen[link] = true
+			links = append(links, link)
+		}
+	}
+	return links
+}
+
+// writes edges from the article with title to links which would be crawled
+func importPage(ctx context.Context, title string, links []string, addEdges crawler.AddEdgeFunction, stats *ImportStats) {
+	page := articleLink(title)
+	if title == "" || !IsValidCrawlLink(page) {
+		return
+	}
+	valid := []string{}
+	for _, l := range links {
+		if IsValidCrawlLink(l) {
+			valid = append(valid, l)
+		}
+	}
+	if len(valid) > 0 {
+		if _, err := addEdges(ctx, baseEndpoint+page, valid); err != nil {
+			logErr(util.WithLogFields(ctx, log.Fields{"stage": "import", "url": baseEndpoint + page}), "Could not import links of '%s': %v", title, err)
+			stats.Failed++
+		} else {
+			stats.EdgesSubmitted += len(valid)
+		}
+	}
+	stats.Pages++
+	if stats.Pages%importLogInterval == 0 {
+		logMsg(ctx, "imported %d pages, submitted %d edges", stats.Pages, stats.EdgesSubmitted)
+	}
+}
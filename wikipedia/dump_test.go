@@ -0,0 +1,212 @@
+package wikipedia
+
+import (
+	"compress/gzip"
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// records edges instead of writing them
+type edgeRecorder map[string][]string
+
+func (e edgeRecorder) addEdges(ctx context.Context, node string, links []string) ([]string, error) {
+	e[CleanUrl(node)] = []string{}
+	for _, l := range links {
+		e[CleanUrl(node)] = append(e[CleanUrl(node)], CleanUrl(l))
+	}
+	return links, nil
+}
+
+// writes content to name in dir, gzipped if name ends with .gz
+func writeDump(t *testing.T, dir string, name string, content string) string {
+	path := filepath.Join(dir, name)
+	f, err := os.Create(path)
+	require.Nil(t, err)
+	defer f.Close()
+	if strings.HasSuffix(name, ".gz") {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		_, err = gz.Write([]byte(content))
+	} else {
+		_, err = f.Write([]byte(content))
+	}
+	require.Nil(t, err)
+	return path
+}
+
+func TestParseSQLValues(t *testing.T) {
+	type Test struct {
+		Name          string
+		Values        string
+		Expected      [][]string
+		ExpectedError string
+	}
+	testTable := []Test{
+		Test{
+			"numbers and strings",
+			"(1,0,'Cheese',0),(2,0,'Brie',1);\n",
+			[][]string{{"1", "0", "Cheese", "0"}, {"2", "0", "Brie", "1"}},
+			"",
+		},
+		Test{
+			"escaped quotes and separators",
+			`(1,'Ben_\'n\'_Jerry\'s','a,b)'),(2,'C:\\dos',NULL);`,
+			[][]string{{"1", "Ben_'n'_Jerry's", "a,b)"}, {"2", `C:\dos`, "NULL"}},
+			"",
+		},
+		Test{
+			"empty string",
+			"(1,'',2);",
+			[][]string{{"1", "", "2"}},
+			"",
+		},
+		Test{
+			"unterminated",
+			"(1,'Cheese'",
+			[][]string{},
+			"unterminated row",
+		},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			rows := [][]string{}
+			err := parseSQLValues(test.Values, func(values []string) error {
+				rows = append(rows, values)
+				return nil
+			})
+			if test.ExpectedError == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, test.ExpectedError)
+			}
+			assert.Equal(t, test.Expected, rows)
+		})
+	}
+}
+
+func TestReadSQLDump(t *testing.T) {
+	dump := "-- MySQL dump\n" +
+		"CREATE TABLE `page` (\n" +
+		"  `page_id` int(8) unsigned NOT NULL AUTO_INCREMENT,\n" +
+		"  `page_namespace` int(11) NOT NULL DEFAULT 0,\n" +
+		"  `page_title` varbinary(255) NOT NULL DEFAULT '',\n" +
+		"  PRIMARY KEY (`page_id`)\n" +
+		") ENGINE=InnoDB;\n" +
+		"INSERT INTO `page` VALUES (1,0,'Cheese'),(2,14,'Cheeses');\n" +
+		"INSERT INTO `other` VALUES (3,0,'Milk');\n"
+	titles := []string{}
+	err := readSQLDump(strings.NewReader(dump), "page", func(r sqlRow) error {
+		titles = append(titles, r.get("page_title")+"@"+r.get("page_namespace"))
+		assert.Equal(t, "", r.get("page_len"))
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Cheese@0", "Cheeses@14"}, titles)
+
+	err = readSQLDump(strings.NewReader("INSERT INTO `page` VALUES (1,0,'Cheese');\n"), "page", func(r sqlRow) error { return nil })
+	assert.EqualError(t, err, "dump has no CREATE TABLE statement for `page`")
+}
+
+var pageDump = "CREATE TABLE `page` (\n" +
+	"  `page_id` int(8) unsigned NOT NULL AUTO_INCREMENT,\n" +
+	"  `page_namespace` int(11) NOT NULL DEFAULT 0,\n" +
+	"  `page_title` varbinary(255) NOT NULL DEFAULT '',\n" +
+	"  `page_is_redirect` tinyint(1) unsigned NOT NULL DEFAULT 0,\n" +
+	"  PRIMARY KEY (`page_id`)\n" +
+	");\n" +
+	"INSERT INTO `page` VALUES (1,0,'Cheese',0),(2,0,'Brie',0),(3,0,'Fromage',1),(4,14,'Cheeses',0),(5,0,'Main_Page',0),(6,0,'C++',0);\n"
+
+func TestImportSQLDump(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dump")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	page := writeDump(t, dir, "page.sql.gz", pageDump)
+	expected := edgeRecorder{
+		"cheese": []string{"brie", "milk", "c++"},
+		"brie":   []string{"cheese"},
+		"c++":    []string{"cheese"},
+	}
+
+	t.Run("pagelinks with titles", func(t *testing.T) {
+		pagelinks := writeDump(t, dir, "pagelinks.sql", "CREATE TABLE `pagelinks` (\n"+
+			"  `pl_from` int(8) unsigned NOT NULL DEFAULT 0,\n"+
+			"  `pl_namespace` int(11) NOT NULL DEFAULT 0,\n"+
+			"  `pl_title` varbinary(255) NOT NULL DEFAULT '',\n"+
+			"  `pl_from_namespace` int(11) NOT NULL DEFAULT 0\n"+
+			");\n"+
+			"INSERT INTO `pagelinks` VALUES (1,0,'Brie',0),(1,0,'Milk',0),(1,0,'C++',0),(1,14,'Cheeses',0),(1,0,'Main_Page',0);\n"+
+			"INSERT INTO `pagelinks` VALUES (2,0,'Cheese',0),(3,0,'Cheese',0),(4,0,'Cheese',14),(5,0,'Cheese',0),(6,0,'Cheese',0);\n")
+		edges := edgeRecorder{}
+		stats, err := ImportSQLDump(context.Background(), SQLDump{Page: page, PageLinks: pagelinks}, edges.addEdges)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, edges)
+		assert.Equal(t, ImportStats{Pages: 3, EdgesSubmitted: 5}, stats)
+	})
+
+	t.Run("pagelinks with link targets", func(t *testing.T) {
+		linktarget := writeDump(t, dir, "linktarget.sql", "CREATE TABLE `linktarget` (\n"+
+			"  `lt_id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,\n"+
+			"  `lt_namespace` int(11) NOT NULL,\n"+
+			"  `lt_title` varbinary(255) NOT NULL\n"+
+			");\n"+
+			"INSERT INTO `linktarget` VALUES (10,0,'Brie'),(11,0,'Cheese'),(12,0,'Milk'),(13,14,'Cheeses'),(14,0,'C++');\n")
+		pagelinks := writeDump(t, dir, "pagelinks-lt.sql", "CREATE TABLE `pagelinks` (\n"+
+			"  `pl_from` int(8) unsigned NOT NULL DEFAULT 0,\n"+
+			"  `pl_from_namespace` int(11) NOT NULL DEFAULT 0,\n"+
+			"  `pl_target_id` bigint(20) unsigned NOT NULL\n"+
+			");\n"+
+			"INSERT INTO `pagelinks` VALUES (1,0,10),(1,0,12),(1,0,14),(1,0,13),(2,0,11),(3,0,11),(6,0,11);\n")
+		edges := edgeRecorder{}
+		stats, err := ImportSQLDump(context.Background(), SQLDump{page, pagelinks, linktarget}, edges.addEdges)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, edges)
+		assert.Equal(t, ImportStats{Pages: 3, EdgesSubmitted: 5}, stats)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := ImportSQLDump(context.Background(), SQLDump{Page: filepath.Join(dir, "missing.sql")}, edgeRecorder{}.addEdges)
+		assert.NotNil(t, err)
+	})
+}
+
+func TestImportXMLDump(t *testing.T) {
+	defer SetLang("en")
+	dir, err := ioutil.TempDir("", "dump")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := writeDump(t, dir, "pages-articles.xml.gz", `<mediawiki xmlns="http://www.mediawiki.org/xml/export-0.10/">
+  <siteinfo><sitename>Wikipedia</sitename></siteinfo>
+  <page>
+    <title>Käse</title>
+    <ns>0</ns>
+    <revision><text>'''Käse''' wird aus [[Milch]] gemacht, z.B. [[brie|Brie]] und [[Brie]].
+[[Datei:Käse.jpg|mini|Ein [[Camembert]]]] [[Kategorie:Käse]] [[#Geschichte|Geschichte]]</text></revision>
+  </page>
+  <page>
+    <title>Fromage</title>
+    <ns>0</ns>
+    <redirect title="Käse" />
+    <revision><text>#WEITERLEITUNG [[Käse]]</text></revision>
+  </page>
+  <page>
+    <title>Kategorie:Käse</title>
+    <ns>14</ns>
+    <revision><text>[[Käse]]</text></revision>
+  </page>
+</mediawiki>`)
+	SetLang("de")
+	edges := edgeRecorder{}
+	stats, err := ImportXMLDump(context.Background(), path, edges.addEdges)
+	assert.Nil(t, err)
+	assert.Equal(t, edgeRecorder{"de:käse": []string{"de:milch", "de:brie", "de:camembert"}}, edges)
+	assert.Equal(t, ImportStats{Pages: 1, EdgesSubmitted: 3}, stats)
+
+	_, err = ImportXMLDump(context.Background(), writeDump(t, dir, "broken.xml", "<mediawiki><page><title>"), edges.addEdges)
+	assert.NotNil(t, err)
+}
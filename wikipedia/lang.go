@@ -0,0 +1,86 @@
+package wikipedia
+
+import (
+	"github.com/dgoldstein1/crawler/util"
+	"net/url"
+	"strings"
+)
+
+// a language edition of wikipedia
+type Language struct {
+	// subdomain of the edition, e.g. "de"
+	Code string
+	// title of the main page, which is not crawled
+	MainPage string
+	// name of the Category namespace
+	Category string
+}
+
+var English = Language{"en", "Main_Page", "Category"}
+
+// large editions, others are assumed to use English names
+var languages = map[string]Language{
+	"de": Language{"de", "Wikipedia:Hauptseite", "Kategorie"},
+	"es": Language{"es", "Wikipedia:Portada", "Categoría"},
+	"fr": Language{"fr", "Wikipédia:Accueil_principal", "Catégorie"},
+	"it": Language{"it", "Pagina_principale", "Categoria"},
+	"ja": Language{"ja", "メインページ", "Category"},
+	"nl": Language{"nl", "Hoofdpagina", "Categorie"},
+	"pl": Language{"pl", "Wikipedia:Strona_główna", "Kategoria"},
+	"pt": Language{"pt", "Wikipédia:Página_principal", "Categoria"},
+	"ru": Language{"ru", "Заглавная_страница", "Категория"},
+	"sv": Language{"sv", "Portal:Huvudsida", "Kategori"},
+	"zh": Language{"zh", "Wikipedia:首页", "Category"},
+}
+
+// edition of wikipedia in language code
+func NewLanguage(code string) Language {
+	if code == "" || code == English.Code {
+		return English
+	}
+	if l, ok := languages[code]; ok {
+		return l
+	}
+	return Language{code, English.MainPage, English.Category}
+}
+
+// e.g. "https://de.wikipedia.org"
+func (l Language) BaseEndpoint() string {
+	return "https://" + l.Code + ".wikipedia.org"
+}
+
+// determines if link is an article of this edition. links are decoded
+// first, so encoded namespaces like "Kategorie%3AK%C3%A4se" are skipped.
+func (l Language) IsValidCrawlLink(link string) bool {
+	if !strings.HasPrefix(link, prefix) {
+		return false
+	}
+	title, err := url.PathUnescape(strings.TrimPrefix(link, prefix))
+	if err != nil {
+		return false
+	}
+	isNotMainPage := !strings.EqualFold(title, English.MainPage) && !strings.EqualFold(title, l.MainPage)
+	// all namespaces, in every language, are separated by ':'
+	noillegalChars := !strings.Contains(title, ":") && !strings.Contains(title, "#")
+	return isNotMainPage && noillegalChars
+}
+
+// decodes and standaridizes URL. keys of editions other than English are
+// prefixed with their language, e.g. "de:käse", so editions can share a KV
+func (l Language) CleanUrl(link string) string {
+	// trim current node if needed
+	link = strings.TrimPrefix(link, l.BaseEndpoint())
+	link = strings.TrimPrefix(link, prefix)
+	link = strings.ReplaceAll(link, "_", " ")
+	if l.Code == English.Code {
+		// lowered before decoding, so only ASCII letters are lowered and
+		// existing graphs keep their keys
+		return util.UnescapeKey(strings.ToLower(link))
+	}
+	// decode string, "" if it cannot be decoded
+	link = util.UnescapeKey(link)
+	if link == "" {
+		return link
+	}
+	return l.Code + ":" + strings.ToLower(link)
+}
@@ -0,0 +1,75 @@
+package wikipedia
+
+import (
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewLanguage(t *testing.T) {
+	assert.Equal(t, English, NewLanguage(""))
+	assert.Equal(t, English, NewLanguage("en"))
+	assert.Equal(t, Language{"de", "Wikipedia:Hauptseite", "Kategorie"}, NewLanguage("de"))
+	// unknown editions are assumed to use English names
+	assert.Equal(t, Language{"simple", "Main_Page", "Category"}, NewLanguage("simple"))
+	assert.Equal(t, "https://simple.wikipedia.org", NewLanguage("simple").BaseEndpoint())
+}
+
+func TestLanguageIsValidCrawlLink(t *testing.T) {
+	type Test struct {
+		Name     string
+		Lang     string
+		Link     string
+		Expected bool
+	}
+	testTable := []Test{
+		Test{"article", "de", "/wiki/K%C3%A4se", true},
+		Test{"localized namespace", "de", "/wiki/Kategorie:K%C3%A4se", false},
+		Test{"encoded namespace", "de", "/wiki/Kategorie%3AK%C3%A4se", false},
+		Test{"localized main page", "de", "/wiki/Wikipedia:Hauptseite", false},
+		Test{"main page without namespace", "ru", "/wiki/%D0%97%D0%B0%D0%B3%D0%BB%D0%B0%D0%B2%D0%BD%D0%B0%D1%8F_%D1%81%D1%82%D1%80%D0%B0%D0%BD%D0%B8%D1%86%D0%B0", false},
+		Test{"english main page", "nl", "/wiki/Main_Page", false},
+		Test{"encoded anchor", "fr", "/wiki/Fromage%23Histoire", false},
+		Test{"invalid encoding", "fr", "/wiki/Fromage%zz", false},
+		Test{"not an article", "ja", "/w/index.php", false},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			assert.Equal(t, test.Expected, NewLanguage(test.Lang).IsValidCrawlLink(test.Link))
+		})
+	}
+}
+
+func TestLanguageCleanUrl(t *testing.T) {
+	de := NewLanguage("de")
+	assert.Equal(t, "de:käse", de.CleanUrl("https://de.wikipedia.org/wiki/K%C3%A4se"))
+	assert.Equal(t, "de:blauer käse", de.CleanUrl("/wiki/Blauer_K%C3%A4se"))
+	// letters are lowered after decoding
+	assert.Equal(t, "de:äpfel", de.CleanUrl("/wiki/%C3%84pfel"))
+	assert.Equal(t, "de:äpfel", de.CleanUrl("/wiki/Äpfel"))
+	assert.Equal(t, "", de.CleanUrl("/wiki/^#$%#$G#$(JG#($JG(DFS(J#(JF%23423"))
+	// english keys are not prefixed, so existing graphs keep their keys
+	assert.Equal(t, "cheese", English.CleanUrl("https://en.wikipedia.org/wiki/Cheese"))
+	assert.Equal(t, "Éclair", English.CleanUrl("/wiki/%C3%89clair"))
+}
+
+func TestSetLang(t *testing.T) {
+	defer SetLang("en")
+	SetLang("de")
+	assert.Equal(t, "https://de.wikipedia.org", baseEndpoint)
+	assert.Equal(t, "https://de.wikipedia.org"+metawikiQuery, metawikiEndpoint)
+	assert.Equal(t, "de:käse", CleanUrl("/wiki/K%C3%A4se"))
+	assert.False(t, IsValidCrawlLink("/wiki/Wikipedia:Hauptseite"))
+
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", metawikiEndpoint+"20",
+		httpmock.NewStringResponder(200, `{"query":{"pages":{"1":{"pageid":1,"ns":0,"title":"Käse"}}}}`))
+	node, err := GetRandomNode()
+	assert.Nil(t, err)
+	assert.Equal(t, "https://de.wikipedia.org/wiki/K%C3%A4se", node)
+
+	SetLang("en")
+	assert.Equal(t, "https://en.wikipedia.org", baseEndpoint)
+	assert.Equal(t, "cheese", CleanUrl("/wiki/Cheese"))
+}
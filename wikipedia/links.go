@@ -0,0 +1,119 @@
+package wikipedia
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/crawler"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// links of an article in the main namespace and whether it is a
+// disambiguation page, redirects are followed
+var linksQuery = "/w/api.php?format=json&action=query&prop=links%7Cpageprops&ppprop=disambiguation&plnamespace=0&pllimit=max&redirects=1&titles="
+
+// reads links of articles from the MediaWiki API instead of their HTML
+var LinkAPI = &crawler.LinkAPI{
+	ContentType: "application/json",
+	RequestURL:  linksURL,
+	ParseLinks:  ParseLinks,
+}
+
+// API URL links of the article at link are requested from
+func linksURL(link string) string {
+	return baseEndpoint + linksQuery + url.QueryEscape(articleTitle(link))
+}
+
+// title of the article at link
+func articleTitle(link string) string {
+	title := strings.TrimPrefix(link, baseEndpoint)
+	title = strings.TrimPrefix(title, prefix)
+	if t, err := url.PathUnescape(title); err == nil {
+		title = t
+	}
+	return strings.ReplaceAll(title, "_", " ")
+}
+
+// link to article with title, escaped like wikipedia's own hrefs
+func articleLink(title string) string {
+	escaped := url.PathEscape(strings.ReplaceAll(title, " ", "_"))
+	// '+' would be decoded as a space in keys
+	return prefix + strings.ReplaceAll(escaped, "+", "%2B")
+}
+
+// URL of the article a links response is for and its links, fetching
+// further batches until all links are read
+func ParseLinks(ctx context.Context, requestURL string, body []byte) (string, []string, error) {
+	page := ""
+	links := []string{}
+	for {
+		resp := &RArticleResp{}
+		if err := json.Unmarshal(body, resp); err != nil {
+			return page, links, fmt.Errorf("could not unmarshal links response: %v", err)
+		}
+		for _, p := range resp.Query.Pages {
+			if p.Missing != nil {
+				return page, links, fmt.Errorf("article '%s' does not exist", p.Title)
+			}
+			page = baseEndpoint + articleLink(p.Title)
+			if _, ok := p.PageProps["disambiguation"]; ok && disambiguation != KeepDisambiguation {
+				recordDisambiguation(page)
+			}
+			for _, l := range p.Links {
+				links = append(links, articleLink(l.Title))
+			}
+		}
+		if page == "" {
+			return page, links, fmt.Errorf("could not find article in links response: %s", string(body))
+		}
+		if resp.Continue["plcontinue"] == "" {
+			findLinkedDisambiguation(ctx, links)
+			return page, links, nil
+		}
+		params := url.Values{}
+		for k, v := range resp.Continue {
+			params.Set(k, v)
+		}
+		next := requestURL + "&" + params.Encode()
+		var err error
+		if body, err = fetch(ctx, next); err != nil {
+			return page, links, err
+		}
+	}
+}
+
+// sends API requests like page requests, see configureClient
+var client = &http.Client{Timeout: timeout}
+
+// sends API requests with the user agent, headers, proxies, guards and
+// limits of page requests in cfg
+func configureClient(cfg config.Config) {
+	c, err := crawler.NewClient(cfg, LinkAPI.ContentType)
+	if err != nil {
+		logErr(context.Background(), "Could not configure API requests: %v", err)
+		return
+	}
+	client = c
+}
+
+// body of a GET request to u
+func fetch(ctx context.Context, u string) ([]byte, error) {
+	req, _ := http.NewRequestWithContext(ctx, "GET", u, nil)
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s responded with %d: %s", u, res.StatusCode, string(body))
+	}
+	return body, nil
+}
@@ -0,0 +1,86 @@
+package wikipedia
+
+import (
+	"context"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLinksURL(t *testing.T) {
+	assert.Equal(t, "https://en.wikipedia.org"+linksQuery+"String+cheese", linksURL("https://en.wikipedia.org/wiki/String_cheese"))
+	assert.Equal(t, "https://en.wikipedia.org"+linksQuery+"C%2B%2B", linksURL("/wiki/C%2B%2B"))
+	// random articles are not escaped
+	assert.Equal(t, "https://en.wikipedia.org"+linksQuery+"Oregon+Bicycle+Racing+Association", linksURL("https://en.wikipedia.org/wiki/Oregon Bicycle Racing Association"))
+}
+
+func TestArticleLink(t *testing.T) {
+	assert.Equal(t, "/wiki/String_cheese", articleLink("String cheese"))
+	assert.Equal(t, "/wiki/C%2B%2B", articleLink("C++"))
+	assert.Equal(t, "/wiki/AC%2FDC", articleLink("AC/DC"))
+	// keys are the same as for links scraped from HTML
+	assert.Equal(t, CleanUrl("/wiki/C%2B%2B"), CleanUrl(articleLink("C++")))
+	assert.Equal(t, CleanUrl("/wiki/Caf%C3%A9_au_lait"), CleanUrl(articleLink("Café au lait")))
+}
+
+func TestParseLinks(t *testing.T) {
+	requestURL := linksURL("/wiki/Cheese")
+	t.Run("reads links of article", func(t *testing.T) {
+		page, links, err := ParseLinks(context.Background(), requestURL, []byte(`{"batchcomplete":"","query":{"redirects":[{"from":"Cheeses","to":"Cheese"}],"pages":{"5224":{"pageid":5224,"ns":0,"title":"Cheese","links":[{"ns":0,"title":"Milk"},{"ns":0,"title":"Blue cheese"}]}}}}`))
+		assert.Nil(t, err)
+		assert.Equal(t, "https://en.wikipedia.org/wiki/Cheese", page)
+		assert.Equal(t, []string{"/wiki/Milk", "/wiki/Blue_cheese"}, links)
+	})
+	t.Run("fetches further batches", func(t *testing.T) {
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", requestURL+"&plcontinue=5224%7C0%7CRennet",
+			httpmock.NewStringResponder(200, `{"continue":{"plcontinue":"5224|0|Whey","continue":"||"},"query":{"pages":{"5224":{"pageid":5224,"ns":0,"title":"Cheese","links":[{"ns":0,"title":"Rennet"}]}}}}`))
+		httpmock.RegisterResponder("GET", requestURL+"&continue=%7C%7C&plcontinue=5224%7C0%7CWhey",
+			httpmock.NewStringResponder(200, `{"batchcomplete":"","query":{"pages":{"5224":{"pageid":5224,"ns":0,"title":"Cheese","links":[{"ns":0,"title":"Whey"}]}}}}`))
+		page, links, err := ParseLinks(context.Background(), requestURL, []byte(`{"continue":{"plcontinue":"5224|0|Rennet"},"query":{"pages":{"5224":{"pageid":5224,"ns":0,"title":"Cheese","links":[{"ns":0,"title":"Milk"}]}}}}`))
+		assert.Nil(t, err)
+		assert.Equal(t, "https://en.wikipedia.org/wiki/Cheese", page)
+		assert.Equal(t, []string{"/wiki/Milk", "/wiki/Rennet", "/wiki/Whey"}, links)
+	})
+	t.Run("fails when a batch cannot be fetched", func(t *testing.T) {
+		httpmock.Activate()
+		defer httpmock.DeactivateAndReset()
+		httpmock.RegisterResponder("GET", requestURL+"&plcontinue=5224%7C0%7CRennet",
+			httpmock.NewStringResponder(429, "too many requests"))
+		_, _, err := ParseLinks(context.Background(), requestURL, []byte(`{"continue":{"plcontinue":"5224|0|Rennet"},"query":{"pages":{"5224":{"pageid":5224,"ns":0,"title":"Cheese","links":[]}}}}`))
+		assert.EqualError(t, err, requestURL+"&plcontinue=5224%7C0%7CRennet responded with 429: too many requests")
+	})
+	t.Run("fails on missing articles", func(t *testing.T) {
+		_, _, err := ParseLinks(context.Background(), requestURL, []byte(`{"query":{"pages":{"-1":{"ns":0,"title":"Chese","missing":""}}}}`))
+		assert.EqualError(t, err, "article 'Chese' does not exist")
+	})
+	t.Run("fails on unexpected responses", func(t *testing.T) {
+		_, _, err := ParseLinks(context.Background(), requestURL, []byte(`{"error":{"code":"badvalue"}}`))
+		assert.EqualError(t, err, `could not find article in links response: {"error":{"code":"badvalue"}}`)
+		_, _, err = ParseLinks(context.Background(), requestURL, []byte(`<html>`))
+		assert.Error(t, err)
+	})
+}
+
+func TestConfigureClient(t *testing.T) {
+	defer func(c *http.Client) { client = c }(client)
+	userAgent := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgent = r.UserAgent()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"query":{}}`))
+	}))
+	defer server.Close()
+	cfg := config.Default()
+	cfg.UserAgent = "cheese-crawler/1.0"
+	configureClient(cfg)
+	body, err := fetch(context.Background(), server.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"query":{}}`, string(body))
+	// further batches are requested like pages
+	assert.Equal(t, "cheese-crawler/1.0", userAgent)
+}
@@ -1,11 +1,28 @@
 package wikipedia
 
 type RArticleResp struct {
-	Query RQuery `json:"query"`
+	// parameters of the request for the next batch, if there is one
+	Continue map[string]string `json:"continue"`
+	Query    RQuery            `json:"query"`
 }
 type RQuery struct {
 	Pages map[string]Page `json:"pages"`
+	// only listed when redirects are followed
+	Redirects []Redirect `json:"redirects"`
 }
 type Page struct {
 	Title string `json:"title"`
+	// only listed when links are requested
+	Links []Link `json:"links"`
+	// set if the page does not exist
+	Missing *string `json:"missing"`
+	// only listed when page props are requested, e.g. "disambiguation"
+	PageProps map[string]string `json:"pageprops"`
+}
+type Link struct {
+	Title string `json:"title"`
+}
+type Redirect struct {
+	From string `json:"from"`
+	To   string `json:"to"`
 }
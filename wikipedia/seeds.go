@@ -0,0 +1,135 @@
+package wikipedia
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/util"
+	log "github.com/sirupsen/logrus"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// random pages are requested this many at a time
+var seedBatch = 20
+
+// titles seeds are drawn from when the API cannot be reached, "" for none
+var seedFile = ""
+
+// random pages of a namespace, fetched in batches
+type seedProvider struct {
+	sync.Mutex
+	// random page API endpoint without its batch size
+	endpoint func() string
+	// namespace of the pages, seeds are only read from file for articles
+	namespace int
+	// links fetched but not handed out yet
+	cache []string
+}
+
+var (
+	articleSeeds  = &seedProvider{endpoint: func() string { return metawikiEndpoint }}
+	categorySeeds = &seedProvider{endpoint: func() string { return randomCategoryEndpoint }, namespace: 14}
+)
+
+// requests batch random pages at a time, falling back to random titles of
+// file, one per line, if the API cannot be reached
+func SetSeeds(batch int, file string) {
+	seedBatch = batch
+	seedFile = file
+	articleSeeds.reset()
+	categorySeeds.reset()
+}
+
+// seeds from cfg.WikipediaSeedBatch and cfg.WikipediaSeedFile
+func configureSeeds(cfg config.Config) {
+	SetSeeds(cfg.WikipediaSeedBatch, cfg.WikipediaSeedFile)
+}
+
+// drops cached links, e.g. of another language
+func (p *seedProvider) reset() {
+	p.Lock()
+	defer p.Unlock()
+	p.cache = nil
+}
+
+// link to a random page, from the cache if there are any left
+func (p *seedProvider) next() (string, error) {
+	p.Lock()
+	defer p.Unlock()
+	if len(p.cache) == 0 {
+		ctx := util.WithLogFields(context.Background(), log.Fields{"stage": "seed"})
+		links, err := p.fetch(ctx)
+		if err != nil {
+			logErr(ctx, "Could not get random pages from metawiki server: %v", err)
+			if p.namespace != 0 || seedFile == "" {
+				return "", err
+			}
+			if links, err = randomTitles(seedFile, seedBatch); err != nil {
+				logErr(ctx, "Could not read random articles from %s: %v", seedFile, err)
+				return "", err
+			}
+		}
+		if len(links) == 0 {
+			return "", fmt.Errorf("could not find any random pages")
+		}
+		p.cache = links
+	}
+	link := p.cache[0]
+	p.cache = p.cache[1:]
+	return link, nil
+}
+
+// a batch of links to random pages from the API
+func (p *seedProvider) fetch(ctx context.Context) ([]string, error) {
+	body, err := fetch(ctx, p.endpoint()+strconv.Itoa(seedBatch))
+	if err != nil {
+		return nil, err
+	}
+	resp := &RArticleResp{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return nil, fmt.Errorf("could not unmarshal response: %v \n body: %s", err, string(body))
+	}
+	links := []string{}
+	for _, page := range resp.Query.Pages {
+		links = append(links, baseEndpoint+articleLink(page.Title))
+	}
+	if len(links) == 0 {
+		return nil, fmt.Errorf("could not find article in response: %s", string(body))
+	}
+	return links, nil
+}
+
+// links to n random articles with titles in the file at path, which may be
+// a dump of all titles like "enwiki-latest-all-titles-in-ns0.gz"
+func randomTitles(path string, n int) ([]string, error) {
+	f, err := openDump(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	links := []string{}
+	seen := 0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		title := strings.TrimSpace(scanner.Text())
+		link := articleLink(title)
+		// title dumps start with the column name
+		if title == "" || title == "page_title" || !IsValidCrawlLink(link) {
+			continue
+		}
+		// reservoir sample, every title is as likely to be picked
+		if seen < n {
+			links = append(links, baseEndpoint+link)
+		} else if i := rand.Intn(seen + 1); i < n {
+			links[i] = baseEndpoint + link
+		}
+		seen++
+	}
+	rand.Shuffle(len(links), func(i, j int) { links[i], links[j] = links[j], links[i] })
+	return links, scanner.Err()
+}
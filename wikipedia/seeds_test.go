@@ -0,0 +1,135 @@
+package wikipedia
+
+import (
+	"context"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"testing"
+)
+
+var randomBatch = `{"query":{"pages":{
+	"1":{"pageid":1,"ns":0,"title":"Brie"},
+	"2":{"pageid":2,"ns":0,"title":"Cheddar cheese"},
+	"3":{"pageid":3,"ns":0,"title":"Gouda"}}}}`
+
+func TestSeedBatches(t *testing.T) {
+	defer SetSeeds(20, "")
+	SetSeeds(3, "")
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", metawikiEndpoint+"3",
+		httpmock.NewStringResponder(200, randomBatch))
+
+	seeds := []string{}
+	for i := 0; i < 3; i++ {
+		seed, err := GetRandomNode()
+		assert.Nil(t, err)
+		seeds = append(seeds, seed)
+	}
+	sort.Strings(seeds)
+	assert.Equal(t, []string{
+		"https://en.wikipedia.org/wiki/Brie",
+		"https://en.wikipedia.org/wiki/Cheddar_cheese",
+		"https://en.wikipedia.org/wiki/Gouda",
+	}, seeds)
+	// unused seeds are cached
+	assert.Equal(t, 1, httpmock.GetTotalCallCount())
+	_, err := GetRandomNode()
+	assert.Nil(t, err)
+	assert.Equal(t, 2, httpmock.GetTotalCallCount())
+
+	// other languages do not get cached seeds
+	defer SetLang("en")
+	SetLang("de")
+	httpmock.RegisterResponder("GET", metawikiEndpoint+"3",
+		httpmock.NewStringResponder(200, `{"query":{"pages":{"1":{"pageid":1,"ns":0,"title":"Käse"}}}}`))
+	seed, err := GetRandomNode()
+	assert.Nil(t, err)
+	assert.Equal(t, "https://de.wikipedia.org/wiki/K%C3%A4se", seed)
+}
+
+func TestSeedFile(t *testing.T) {
+	defer func(l func(context.Context, string, ...interface{})) { logErr = l }(logErr)
+	logErr = func(ctx context.Context, format string, args ...interface{}) {}
+	f, err := ioutil.TempFile("", "titles")
+	require.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("page_title\nBrie\nMain_Page\nCategory:Cheese\n\nC++\n")
+	f.Close()
+	defer SetSeeds(20, "")
+	SetSeeds(20, f.Name())
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	httpmock.RegisterResponder("GET", metawikiEndpoint+"20",
+		httpmock.NewStringResponder(503, "unavailable"))
+	httpmock.RegisterResponder("GET", randomCategoryEndpoint+"20",
+		httpmock.NewStringResponder(503, "unavailable"))
+
+	seeds := []string{}
+	for i := 0; i < 2; i++ {
+		seed, err := GetRandomNode()
+		assert.Nil(t, err)
+		seeds = append(seeds, seed)
+	}
+	sort.Strings(seeds)
+	assert.Equal(t, []string{
+		"https://en.wikipedia.org/wiki/Brie",
+		"https://en.wikipedia.org/wiki/C%2B%2B",
+	}, seeds)
+	// categories are not in the file
+	_, err = GetRandomCategory()
+	assert.EqualError(t, err, randomCategoryEndpoint+"20 responded with 503: unavailable")
+
+	SetSeeds(20, f.Name()+".missing")
+	_, err = GetRandomNode()
+	assert.NotNil(t, err)
+}
+
+func TestRandomTitles(t *testing.T) {
+	f, err := ioutil.TempFile("", "titles")
+	require.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("A\nB\nC\nD\nE\nF\n")
+	f.Close()
+	picked := map[string]int{}
+	for i := 0; i < 100; i++ {
+		links, err := randomTitles(f.Name(), 2)
+		assert.Nil(t, err)
+		assert.Equal(t, 2, len(links))
+		assert.NotEqual(t, links[0], links[1])
+		for _, l := range links {
+			picked[l]++
+		}
+	}
+	// every title can be picked
+	assert.Equal(t, 6, len(picked))
+}
+
+func TestSeedClient(t *testing.T) {
+	defer func(c *http.Client) { client = c }(client)
+	defer SetSeeds(20, "")
+	SetSeeds(3, "")
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+	userAgent := ""
+	httpmock.RegisterResponder("GET", metawikiEndpoint+"3", func(r *http.Request) (*http.Response, error) {
+		userAgent = r.UserAgent()
+		res := httpmock.NewStringResponse(200, randomBatch)
+		res.Header.Set("Content-Type", "application/json")
+		return res, nil
+	})
+	cfg := config.Default()
+	cfg.UserAgent = "cheese-crawler/1.0"
+	// built after activating, so requests go through the mock
+	configureClient(cfg)
+	_, err := GetRandomNode()
+	assert.Nil(t, err)
+	// seeds are requested like pages
+	assert.Equal(t, "cheese-crawler/1.0", userAgent)
+}
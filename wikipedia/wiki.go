@@ -1,93 +1,112 @@
 package wikipedia
 
 import (
-	"encoding/json"
-	"fmt"
+	"context"
+	"github.com/dgoldstein1/crawler/config"
+	"github.com/dgoldstein1/crawler/crawler"
 	"github.com/dgoldstein1/crawler/db"
+	"github.com/dgoldstein1/crawler/util"
 	"github.com/gocolly/colly"
-	log "github.com/sirupsen/logrus"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-	"strings"
 	"time"
 )
 
 // globals
-var logErr = log.Errorf
+var logMsg = func(ctx context.Context, format string, args ...interface{}) {
+	util.Logger(ctx).Infof(format, args...)
+}
+var logErr = func(ctx context.Context, format string, args ...interface{}) {
+	util.Logger(ctx).Errorf(format, args...)
+}
 var prefix = "/wiki/"
-var baseEndpoint = "https://en.wikipedia.org"
-var metawikiEndpoint = "https://en.wikipedia.org/w/api.php?format=json&action=query&prop=extracts&exlimit=max&explaintext&exintro&generator=random&grnnamespace=0&grnlimit=1ts="
+var metawikiQuery = "/w/api.php?format=json&action=query&generator=random&grnnamespace=0&grnlimit=" // + batch size
+var baseEndpoint = English.BaseEndpoint()
+var metawikiEndpoint = baseEndpoint + metawikiQuery
+var randomCategoryEndpoint = baseEndpoint + categoryQuery(metawikiQuery)
 var timeout = time.Duration(5 * time.Second)
 
+// language of wikipedia being crawled
+var lang = English
+
+// crawls the wikipedia of language code, e.g. "de", from now on
+func SetLang(code string) {
+	lang = NewLanguage(code)
+	baseEndpoint = lang.BaseEndpoint()
+	metawikiEndpoint = baseEndpoint + metawikiQuery
+	randomCategoryEndpoint = baseEndpoint + categoryQuery(metawikiQuery)
+	articleSeeds.reset()
+	categorySeeds.reset()
+}
+
+// base URL of the wikipedia being crawled
+func BaseEndpoint() string {
+	return baseEndpoint
+}
+
+// crawls the wikipedia of cfg.WikipediaLang, reading links from the API
+// if cfg.WikipediaLinks is "api" and keeping those in cfg.WikipediaZone.
+// disambiguation pages are crawled as cfg.WikipediaDisambiguation
+func Configure(cfg config.Config) {
+	SetLang(cfg.WikipediaLang)
+	configureSeeds(cfg)
+	configureClient(cfg)
+	SetZone(cfg.WikipediaZone, cfg.WikipediaSeeAlso, cfg.WikipediaInfobox)
+	SetDisambiguation(cfg.WikipediaDisambiguation)
+	zonesEnabled = cfg.WikipediaLinks != "api"
+	if cfg.WikipediaLinks == "api" {
+		crawler.SetLinkAPI(LinkAPI)
+	}
+}
+
 // determines if is good link to crawl on
 func IsValidCrawlLink(link string) bool {
-	validPrefix := strings.HasPrefix(link, "/wiki/")
-	isNotMainPage := strings.ToLower(link) != "/wiki/main_page"
-	noillegalChars := !strings.Contains(link, ":") && !strings.Contains(link, "#")
-	return validPrefix && isNotMainPage && noillegalChars
+	return lang.IsValidCrawlLink(link)
 }
 
-// gets random article from metawiki API
-// returns article in the form "/wiki/XXXXX"
+// gets random article from metawiki API, or SetSeeds's file if it cannot
+// be reached. returns article in the form "https://en.wikipedia.org/wiki/XXXXX"
 func GetRandomNode() (string, error) {
-	req, _ := http.NewRequest("GET", metawikiEndpoint, nil)
-	client := http.Client{
-		Timeout: timeout,
-	}
-	res, err := client.Do(req)
-	if err != nil {
-		logErr("Could not get new article from metawiki server: %v", err)
-		return "", err
-	}
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		logErr("Could not read response from metawiki server: %v", err)
-		return "", err
-	}
-	rArticle := &RArticleResp{}
-	err = json.Unmarshal(body, &rArticle)
-	if err != nil {
-		logErr("could not unmarshal response from metawiki server: %v \n body: %s", err, string(body))
-		return "", err
-	}
-	// etract response
-	for _, p := range rArticle.Query.Pages {
-		// return on first article
-		return baseEndpoint + prefix + p.Title, nil
-	}
-	return "", fmt.Errorf("Could not find article in metawiki response:  %v+", rArticle)
+	return articleSeeds.next()
 }
 
 // decodes and standaridizes URL
 func CleanUrl(link string) string {
-	// trim current node if needed
-	link = strings.TrimPrefix(link, baseEndpoint)
-	link = strings.TrimPrefix(link, prefix)
-	link = strings.ToLower(link)
-	link = strings.ReplaceAll(link, "_", " ")
-	// decode string
-	link, err := url.QueryUnescape(link)
-	if err != nil {
-		logErr("Could not decode string %s: %v", link, err)
-	}
-	return link
+	return lang.CleanUrl(link)
 }
 
-// filters down full page body to elements we want to focus on
+// filters down full page body to the blocks of the article in zone
 func FilterPage(e *colly.HTMLElement) (*colly.HTMLElement, error) {
+	if disambiguation != KeepDisambiguation {
+		findDisambiguation(e)
+	}
+	if zone == PageZone {
+		return e, nil
+	}
+	content, err := articleContent(e.DOM)
+	if err != nil {
+		// no links are better than links from outside of the zone
+		e.DOM = content
+		return e, err
+	}
+	e.DOM = zoneBlocks(content)
 	return e, nil
 }
 
 // adds edge to DB, returns new neighbors added (to crawl on)
 func AddEdgesIfDoNotExist(
+	ctx context.Context,
 	currentNode string,
 	neighborNodes []string,
 ) (
 	neighborsAdded []string,
 	err error,
 ) {
+	return addDisambiguationEdges(withZone(ctx), currentNode, neighborNodes, addEdges)
+}
+
+// adds edges to DB with the keys of the wikipedia being crawled
+func addEdges(ctx context.Context, currentNode string, neighborNodes []string) ([]string, error) {
 	return db.AddEdgesIfDoNotExist(
+		ctx,
 		currentNode,
 		neighborNodes,
 		CleanUrl,
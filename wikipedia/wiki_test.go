@@ -1,13 +1,12 @@
 package wikipedia
 
 import (
+	"context"
 	"fmt"
 	"github.com/gocolly/colly"
 	"github.com/jarcoal/httpmock"
 	"github.com/stretchr/testify/assert"
-	"strings"
 	"testing"
-	"time"
 )
 
 var dbEndpoint = "http://localhost:17474"
@@ -67,7 +66,7 @@ func TestCleanURL(t *testing.T) {
 
 func TestGetRandomNode(t *testing.T) {
 	errorsLogged := []string{}
-	logErr = func(format string, args ...interface{}) {
+	logErr = func(ctx context.Context, format string, args ...interface{}) {
 		if len(args) > 0 {
 			errorsLogged = append(errorsLogged, fmt.Sprintf(format, args))
 		} else {
@@ -77,6 +76,7 @@ func TestGetRandomNode(t *testing.T) {
 
 	type Test struct {
 		Name             string
+		MockedStatus     int
 		MockedRequest    string
 		ExpectedResponse string
 		ExpectedError    string
@@ -85,30 +85,51 @@ func TestGetRandomNode(t *testing.T) {
 	testTable := []Test{
 		Test{
 			Name:             "succesful",
-			MockedRequest:    `{"batchcomplete":"","continue":{"grncontinue":"0.369259750651|0.369260921533|12247122|0","continue":"grncontinue||"},"query":{"pages":{"9820486":{"pageid":9820486,"ns":0,"title":"Oregon Bicycle Racing Association","extract":"The Oregon Bicycle Racing Association is a bicycle racing organization based in the U.S. state of Oregon."}}},"limits":{"extracts":20}}`,
-			ExpectedResponse: "https://en.wikipedia.org/wiki/Oregon Bicycle Racing Association",
+			MockedStatus:     200,
+			MockedRequest:    `{"batchcomplete":"","continue":{"grncontinue":"0.369259750651|0.369260921533|12247122|0","continue":"grncontinue||"},"query":{"pages":{"9820486":{"pageid":9820486,"ns":0,"title":"Oregon Bicycle Racing Association"}}}}`,
+			ExpectedResponse: "https://en.wikipedia.org/wiki/Oregon_Bicycle_Racing_Association",
 			ExpectedError:    "",
 		},
+		Test{
+			Name:             "encodes title",
+			MockedStatus:     200,
+			MockedRequest:    `{"query":{"pages":{"72038":{"pageid":72038,"ns":0,"title":"C++ Builder (Software)"}}}}`,
+			ExpectedResponse: "https://en.wikipedia.org/wiki/C%2B%2B_Builder_%28Software%29",
+			ExpectedError:    "",
+		},
+		Test{
+			Name:             "server error",
+			MockedStatus:     503,
+			MockedRequest:    `upstream request timeout`,
+			ExpectedResponse: "",
+			ExpectedError:    "responded with 503: upstream request timeout",
+		},
+		Test{
+			Name:             "no pages",
+			MockedStatus:     200,
+			MockedRequest:    `{"query":{"pages":{}}}`,
+			ExpectedResponse: "",
+			ExpectedError:    "could not find article in response",
+		},
+		Test{
+			Name:             "bad response",
+			MockedStatus:     200,
+			MockedRequest:    `<html>`,
+			ExpectedResponse: "",
+			ExpectedError:    "could not unmarshal response",
+		},
 	}
 
-	tempEndpointVar := ""
 	for _, test := range testTable {
 		t.Run(test.Name, func(t *testing.T) {
-			// mock out endpoint
-			if test.Name == "ENDPOINT_NOT_FOUND" {
-				tempEndpointVar = metawikiEndpoint
-				metawikiEndpoint = "http://BAD_ENDPOINT"
-				timeout = time.Duration(1 * time.Second)
-			} else {
-				httpmock.Activate()
-				httpmock.RegisterResponder("GET", metawikiEndpoint,
-					httpmock.NewStringResponder(200, test.MockedRequest))
-			}
+			httpmock.Activate()
+			httpmock.RegisterResponder("GET", metawikiEndpoint+"20",
+				httpmock.NewStringResponder(test.MockedStatus, test.MockedRequest))
 			// run test
 			a, err := GetRandomNode()
 			assert.Equal(t, test.ExpectedResponse, a)
 			if err != nil {
-				assert.True(t, strings.Contains(err.Error(), test.ExpectedError))
+				assert.Contains(t, err.Error(), test.ExpectedError)
 				assert.Equal(t, 1, len(errorsLogged))
 			} else {
 				assert.Equal(t, "", test.ExpectedError)
@@ -117,8 +138,6 @@ func TestGetRandomNode(t *testing.T) {
 			// reset
 			httpmock.DeactivateAndReset()
 			errorsLogged = []string{}
-			timeout = time.Duration(5 * time.Second)
-			metawikiEndpoint = tempEndpointVar
 		})
 	}
 
@@ -161,6 +180,6 @@ func TestFilterPage(t *testing.T) {
 func TestAddEdgesIfDoNotExist(t *testing.T) {
 	node := "/wiki/test"
 	neighbors := []string{}
-	added, _ := AddEdgesIfDoNotExist(node, neighbors)
+	added, _ := AddEdgesIfDoNotExist(context.Background(), node, neighbors)
 	assert.Equal(t, added, []string(nil))
 }
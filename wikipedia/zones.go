@@ -0,0 +1,105 @@
+package wikipedia
+
+import (
+	"context"
+	"errors"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dgoldstein1/crawler/util"
+	"golang.org/x/net/html"
+)
+
+// parts of an article links are kept from
+const (
+	// everything on the page, including navigation
+	PageZone = "page"
+	// article text without navboxes, references and maintenance notices
+	BodyZone = "body"
+	// article text before its first section
+	LeadZone = "lead"
+)
+
+// zone links are kept from and whether "See also" and infoboxes are part of it
+var zone = PageZone
+var seeAlso = true
+var infobox = true
+
+// whether edges carry the zone of their links. links read from the API are
+// not kept by zone, there is no page to filter
+var zonesEnabled = true
+
+// blocks of the article text which are never part of the body or lead
+var notContent = ".navbox, .vertical-navbox, .sidebar, .reflist, .references, .mw-references-wrap, .hatnote, .ambox, .metadata, .portalbox, .sistersitebox, .noprint"
+
+// sections at the end of the article which only list sources
+var sourceSections = map[string]bool{
+	"Notes":           true,
+	"References":      true,
+	"Citations":       true,
+	"Sources":         true,
+	"Bibliography":    true,
+	"Further_reading": true,
+	"External_links":  true,
+}
+
+// keeps links from zone of articles, with or without their "See also"
+// section and infobox
+func SetZone(z string, includeSeeAlso bool, includeInfobox bool) {
+	zone = z
+	seeAlso = includeSeeAlso
+	infobox = includeInfobox
+}
+
+// ctx with the zone as attribute of the edges added with it, if enabled.
+// "page" is recorded too, so edges of all links are told apart from
+// those of a zone and from edges crawled without zones
+func withZone(ctx context.Context) context.Context {
+	if !zonesEnabled {
+		return ctx
+	}
+	return util.WithEdgeAttribute(ctx, "zone", zone)
+}
+
+// blocks of the article text in content which are part of zone
+func zoneBlocks(content *goquery.Selection) *goquery.Selection {
+	blocks := []*html.Node{}
+	section := ""
+	content.Children().Not(notContent).Each(func(_ int, s *goquery.Selection) {
+		if id, ok := sectionID(s); ok {
+			section = id
+			return
+		}
+		if s.Is(".infobox") && !infobox {
+			return
+		}
+		if section != "" && (zone == LeadZone || sourceSections[section] || (section == "See_also" && !seeAlso)) {
+			return
+		}
+		blocks = append(blocks, s.Nodes...)
+	})
+	return content.Children().FilterNodes(blocks...)
+}
+
+// id of the section heading s starts, false if s is not a section heading.
+// headings are wrapped in div.mw-heading since MediaWiki 1.43.
+func sectionID(s *goquery.Selection) (string, bool) {
+	h := s
+	if s.HasClass("mw-heading2") {
+		h = s.ChildrenFiltered("h2")
+	}
+	if !h.Is("h2") {
+		return "", false
+	}
+	if id, ok := h.Find(".mw-headline").Attr("id"); ok {
+		return id, true
+	}
+	return h.AttrOr("id", ""), true
+}
+
+// article text of page, without the skin's navigation and footer
+func articleContent(page *goquery.Selection) (*goquery.Selection, error) {
+	content := page.Find("#mw-content-text .mw-parser-output").First()
+	if content.Length() == 0 {
+		return content, errors.New("could not find article content")
+	}
+	return content, nil
+}
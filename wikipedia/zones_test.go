@@ -0,0 +1,83 @@
+package wikipedia
+
+import (
+	"context"
+	"github.com/PuerkitoBio/goquery"
+	"github.com/dgoldstein1/crawler/util"
+	"github.com/gocolly/colly"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+// article with old and new (div.mw-heading) heading markup
+var testArticle = `<html><body>
+<div id="mw-panel"><a href="/wiki/Main_Page">Main page</a></div>
+<div id="mw-content-text"><div class="mw-parser-output">
+	<div class="hatnote">For the dish, see <a href="/wiki/Cheese_(dish)">Cheese (dish)</a></div>
+	<table class="infobox"><tr><td><a href="/wiki/Milk">Milk</a></td></tr></table>
+	<p><b>Cheese</b> is made from <a href="/wiki/Curd">curd</a>.</p>
+	<h2><span class="mw-headline" id="History">History</span></h2>
+	<p>Made since <a href="/wiki/Antiquity">antiquity</a>.</p>
+	<div class="mw-heading mw-heading2"><h2 id="See_also">See also</h2></div>
+	<ul><li><a href="/wiki/Butter">Butter</a></li></ul>
+	<h2><span class="mw-headline" id="References">References</span></h2>
+	<p><a href="/wiki/Some_book">Some book</a></p>
+	<div class="reflist"><a href="/wiki/Some_journal">Some journal</a></div>
+	<div class="navbox"><a href="/wiki/Yogurt">Yogurt</a></div>
+</div></div>
+<div id="catlinks"><a href="/wiki/Category:Cheese">Cheese</a></div>
+</body></html>`
+
+func TestFilterPageZones(t *testing.T) {
+	defer SetZone(PageZone, true, true)
+	type Test struct {
+		Name          string
+		Zone          string
+		SeeAlso       bool
+		Infobox       bool
+		ExpectedLinks []string
+	}
+	testTable := []Test{
+		Test{"whole page", PageZone, true, true, []string{"/wiki/Main_Page", "/wiki/Cheese_(dish)", "/wiki/Milk", "/wiki/Curd", "/wiki/Antiquity", "/wiki/Butter", "/wiki/Some_book", "/wiki/Some_journal", "/wiki/Yogurt", "/wiki/Category:Cheese"}},
+		Test{"body", BodyZone, true, true, []string{"/wiki/Milk", "/wiki/Curd", "/wiki/Antiquity", "/wiki/Butter"}},
+		Test{"body without see also", BodyZone, false, true, []string{"/wiki/Milk", "/wiki/Curd", "/wiki/Antiquity"}},
+		Test{"body without infobox", BodyZone, true, false, []string{"/wiki/Curd", "/wiki/Antiquity", "/wiki/Butter"}},
+		Test{"lead", LeadZone, true, true, []string{"/wiki/Milk", "/wiki/Curd"}},
+		Test{"lead without infobox", LeadZone, true, false, []string{"/wiki/Curd"}},
+	}
+	for _, test := range testTable {
+		t.Run(test.Name, func(t *testing.T) {
+			SetZone(test.Zone, test.SeeAlso, test.Infobox)
+			doc, err := goquery.NewDocumentFromReader(strings.NewReader(testArticle))
+			require.Nil(t, err)
+			e, err := FilterPage(&colly.HTMLElement{DOM: doc.Selection})
+			assert.Nil(t, err)
+			links := []string{}
+			e.DOM.Find("a[href]").Each(func(_ int, s *goquery.Selection) {
+				links = append(links, s.AttrOr("href", ""))
+			})
+			assert.Equal(t, test.ExpectedLinks, links)
+		})
+	}
+	t.Run("keeps no links of pages without article", func(t *testing.T) {
+		SetZone(LeadZone, true, true)
+		doc, _ := goquery.NewDocumentFromReader(strings.NewReader(`<html><body><a href="/wiki/Cheese">Cheese</a></body></html>`))
+		e, err := FilterPage(&colly.HTMLElement{DOM: doc.Selection})
+		assert.EqualError(t, err, "could not find article content")
+		assert.Equal(t, 0, e.DOM.Find("a[href]").Length())
+	})
+}
+
+func TestWithZone(t *testing.T) {
+	defer SetZone(PageZone, true, true)
+	defer func() { zonesEnabled = true }()
+	ctx := context.Background()
+	assert.Equal(t, map[string]string{"zone": "page"}, util.EdgeAttributes(withZone(ctx)))
+	SetZone(LeadZone, true, true)
+	assert.Equal(t, map[string]string{"zone": "lead"}, util.EdgeAttributes(withZone(ctx)))
+	// links read from the API are not kept by zone
+	zonesEnabled = false
+	assert.Empty(t, util.EdgeAttributes(withZone(ctx)))
+}